@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	zest "github.com/me-box/goZestClient"
 )
@@ -22,34 +23,46 @@ type ArbiterClient struct {
 	tokenCache      map[string][]byte
 	tokenCacheMutex *sync.Mutex
 	ZestC           zest.ZestClient
+	closed          bool
+	metrics         *metricsRef
+	exporter        *exporterRef
 }
 
+var errArbiterClientClosed = errors.New("ArbiterClient is closed")
+
 //NewArbiterClient returns an arbiter client for use by components that require conunication with the arbiter
 func NewArbiterClient(arbiterTokenPath string, zmqPublicKeyPath string, arbiterZMQURI string) (*ArbiterClient, error) {
 
-	ac := ArbiterClient{
-		arbiterZMQURI:   arbiterZMQURI,
-		tokenCache:      make(map[string][]byte),
-		tokenCacheMutex: &sync.Mutex{},
-	}
-
 	arbToken, err := ioutil.ReadFile(arbiterTokenPath)
 	if err != nil {
 		fmt.Println("Warning:: failed to read ARBITER_TOKEN using default value")
-		ac.ArbiterToken = "secret"
-	} else {
-		ac.ArbiterToken = string(arbToken)
+		arbToken = []byte(insecureArbiterToken)
 	}
 
 	//get the server public key
 	serverKey, err := ioutil.ReadFile(zmqPublicKeyPath)
 	if err != nil {
 		fmt.Println("Warning:: failed to read ZMQ_PUBLIC_KEY using default value")
-		serverKey = []byte("vl6wu0A@XP?}Or/&BR#LSxn>A+}L)p44/W[wXL3<")
+		serverKey = []byte(insecureStorePublicKey)
 	}
 
+	return newArbiterClient(string(arbToken), string(serverKey), arbiterZMQURI)
+}
+
+func newArbiterClient(arbiterToken string, serverKey string, arbiterZMQURI string) (*ArbiterClient, error) {
+
+	ac := ArbiterClient{
+		arbiterZMQURI:   arbiterZMQURI,
+		ArbiterToken:    arbiterToken,
+		tokenCache:      make(map[string][]byte),
+		tokenCacheMutex: &sync.Mutex{},
+		metrics:         &metricsRef{},
+		exporter:        &exporterRef{},
+	}
+
+	var err error
 	DEndpoint := strings.Replace(arbiterZMQURI, ":4444", ":4445", 1)
-	ac.ZestC, err = zest.New(arbiterZMQURI, DEndpoint, string(serverKey), false)
+	ac.ZestC, err = zest.New(arbiterZMQURI, DEndpoint, serverKey, false)
 	if err != nil {
 		return &ArbiterClient{}, errors.New("Can't connect to Arbiter on " + arbiterZMQURI)
 	}
@@ -129,6 +142,10 @@ func (arb *ArbiterClient) GrantContainerPermissions(permissions ContainerPermiss
 
 func (arb *ArbiterClient) makeArbiterGETRequest(path string, hostname string, endpoint string, method string) ([]byte, int) {
 
+	if arb.isClosed() {
+		return []byte(errArbiterClientClosed.Error()), 500
+	}
+
 	if arb.arbiterZMQURI == "" {
 		return []byte{}, 200
 	}
@@ -144,6 +161,10 @@ func (arb *ArbiterClient) makeArbiterGETRequest(path string, hostname string, en
 
 func (arb *ArbiterClient) makeArbiterPostRequest(path string, hostname string, endpoint string, payload []byte) ([]byte, int) {
 
+	if arb.isClosed() {
+		return []byte(errArbiterClientClosed.Error()), 500
+	}
+
 	if arb.arbiterZMQURI == "" {
 		return nil, 200
 	}
@@ -185,6 +206,16 @@ func (arb *ArbiterClient) RequestDeligatedToken(scrHost string, href string, met
 
 // RequestToken is used internally to request a token from the arbiter for the current host
 func (arb *ArbiterClient) RequestToken(href string, method string, caveat string) ([]byte, error) {
+	return arb.requestToken(href, method, caveat, SpanContext{})
+}
+
+// requestToken is RequestToken with the span of the request that needs the token
+func (arb *ArbiterClient) requestToken(href string, method string, caveat string, parent SpanContext) (token []byte, err error) {
+
+	span := startSpan(arb.exporter.get(), "arbiter.token", parent, map[string]string{"href": href, "method": method})
+	defer func() {
+		span.end(err)
+	}()
 
 	u, err := url.Parse(href)
 	if err != nil {
@@ -198,25 +229,48 @@ func (arb *ArbiterClient) RequestToken(href string, method string, caveat string
 
 	routeHash := host + strings.ToUpper(u.Path) + method + caveat
 	arb.tokenCacheMutex.Lock()
+	if arb.closed {
+		arb.tokenCacheMutex.Unlock()
+		return []byte{}, errArbiterClientClosed
+	}
 	token, exists := arb.tokenCache[routeHash]
 	arb.tokenCacheMutex.Unlock()
+	metrics := arb.metrics.get()
 	if !exists {
+		span.setAttribute("cache", "miss")
 		var status int
 		payload := []byte(`{"target":"` + host + `","path":"` + u.Path + `","method":"` + method + `","caveats":[` + caveat + `]}`)
 
+		start := time.Now()
 		token, status = arb.makeArbiterPostRequest("/token", host, u.Path, payload)
+		metrics.ObserveDuration(MetricArbiterTokenDuration, MetricLabels{}, time.Since(start))
 		if status != 200 {
+			metrics.AddCounter(MetricArbiterTokenRequests, MetricLabels{"result": "error"}, 1)
 			err = errors.New(strconv.Itoa(status) + ": " + string(token))
 			return []byte{}, err
 		}
+		metrics.AddCounter(MetricArbiterTokenRequests, MetricLabels{"result": "miss"}, 1)
 		arb.tokenCacheMutex.Lock()
 		arb.tokenCache[routeHash] = token
 		arb.tokenCacheMutex.Unlock()
+	} else {
+		span.setAttribute("cache", "hit")
+		metrics.AddCounter(MetricArbiterTokenRequests, MetricLabels{"result": "hit"}, 1)
 	}
 
 	return token, err
 }
 
+// SetSpanExporter sets where spans for token requests are sent, nil disables tracing
+func (arb *ArbiterClient) SetSpanExporter(e SpanExporter) {
+	arb.exporter.set(e)
+}
+
+// SetMetrics sets where token cache hits and misses are recorded, nil disables metrics
+func (arb *ArbiterClient) SetMetrics(m Metrics) {
+	arb.metrics.set(m)
+}
+
 // InvalidateCache can be used to remove a token from the arbiterClient cache.
 // This is done automatically if the token is rejected.
 func (arb *ArbiterClient) InvalidateCache(href string, method string, caveats string) error {
@@ -241,6 +295,29 @@ func (arb *ArbiterClient) InvalidateCache(href string, method string, caveats st
 
 }
 
+// Close empties the token cache and stops the client making any more requests to the arbiter, the zest
+// client opens a socket per request so it has nothing to close. CoreStoreClients using this ArbiterClient
+// should be closed first.
+func (arb *ArbiterClient) Close() error {
+
+	arb.tokenCacheMutex.Lock()
+	defer arb.tokenCacheMutex.Unlock()
+
+	if arb.closed {
+		return nil
+	}
+	arb.closed = true
+	arb.tokenCache = make(map[string][]byte)
+
+	return nil
+}
+
+func (arb *ArbiterClient) isClosed() bool {
+	arb.tokenCacheMutex.Lock()
+	defer arb.tokenCacheMutex.Unlock()
+	return arb.closed
+}
+
 func (arb *ArbiterClient) RemoveDataboxComponent() {
 	//delete-continer-info
 }
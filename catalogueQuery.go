@@ -0,0 +1,126 @@
+package libDatabox
+
+import (
+	"strings"
+)
+
+// HypercatCatalogueContentType is the isContentType of root catalogue items that link to a store catalogue
+const HypercatCatalogueContentType = "application/vnd.hypercat.catalogue+json"
+
+// DataSourceQuery describes which datasources to return from a catalogue. Empty fields match
+// everything, string fields must match exactly apart from Description which is a case
+// insensitive substring match. IsActuator and IsFunc are only checked if they are not nil.
+type DataSourceQuery struct {
+	Vendor         string
+	DataSourceType string
+	DataSourceID   string
+	Location       string
+	Unit           string
+	StoreType      StoreType
+	ContentType    StoreContentType
+	IsActuator     *bool
+	IsFunc         *bool
+	Description    string
+}
+
+// CatalogueEntry is a datasource found by a DataSourceQuery
+type CatalogueEntry struct {
+	Item     HypercatItem
+	Metadata DataSourceMetadata
+	StoreURL string
+}
+
+// Matches returns true if dm meets all the conditions in the query
+func (q DataSourceQuery) Matches(dm DataSourceMetadata) bool {
+
+	if q.Vendor != "" && q.Vendor != dm.Vendor {
+		return false
+	}
+	if q.DataSourceType != "" && q.DataSourceType != dm.DataSourceType {
+		return false
+	}
+	if q.DataSourceID != "" && q.DataSourceID != dm.DataSourceID {
+		return false
+	}
+	if q.Location != "" && q.Location != dm.Location {
+		return false
+	}
+	if q.Unit != "" && q.Unit != dm.Unit {
+		return false
+	}
+	if q.StoreType != "" && q.StoreType != dm.StoreType {
+		return false
+	}
+	if q.ContentType != "" && !strings.EqualFold(string(q.ContentType), string(dm.ContentType)) {
+		return false
+	}
+	if q.IsActuator != nil && *q.IsActuator != dm.IsActuator {
+		return false
+	}
+	if q.IsFunc != nil && *q.IsFunc != dm.IsFunc {
+		return false
+	}
+	if q.Description != "" && !strings.Contains(strings.ToLower(dm.Description), strings.ToLower(q.Description)) {
+		return false
+	}
+
+	return true
+}
+
+// IsCatalogueLink returns true if the item links to another catalogue rather than describing a datasource
+func (item HypercatItem) IsCatalogueLink() bool {
+	return item.ItemMetadata.String(RelIsContentType) == HypercatCatalogueContentType
+}
+
+// Find returns the datasources in the catalogue that match q. Links to other catalogues are ignored.
+func (root HypercatRoot) Find(q DataSourceQuery) []CatalogueEntry {
+
+	entries := []CatalogueEntry{}
+	for _, item := range root.Items {
+		if item.IsCatalogueLink() {
+			continue
+		}
+		dm, storeURL, err := HypercatItemToDataSourceMetadata(item)
+		if err != nil {
+			continue
+		}
+		if q.Matches(dm) {
+			entries = append(entries, CatalogueEntry{
+				Item:     item,
+				Metadata: dm,
+				StoreURL: storeURL,
+			})
+		}
+	}
+
+	return entries
+}
+
+// FindDataSources searches the catalogues of all stores linked from the arbiters root catalogue, the store
+// catalogues are read concurrently. Stores that can not be read are skipped, the matches from the other stores are returned along
+// with an error listing the stores that failed.
+func (csc *CoreStoreClient) FindDataSources(q DataSourceQuery) ([]CatalogueEntry, error) {
+
+	rootCat, err := csc.Arbiter.GetRootDataSourceCatalogue()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := rootCat.Find(q)
+
+	hrefs := []string{}
+	for _, item := range rootCat.Items {
+		if item.IsCatalogueLink() {
+			hrefs = append(hrefs, item.Href)
+		}
+	}
+
+	storeCats, err := csc.GetStoreDataSourceCatalogues(hrefs)
+	for _, href := range hrefs {
+		if storeCat, ok := storeCats[href]; ok {
+			entries = append(entries, storeCat.Find(q)...)
+		}
+	}
+
+	return entries, err
+}
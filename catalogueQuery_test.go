@@ -0,0 +1,64 @@
+package libDatabox
+
+import (
+	"testing"
+)
+
+func testCatalogue(t *testing.T) HypercatRoot {
+
+	root := HypercatRoot{}
+	for _, dm := range []DataSourceMetadata{
+		{Description: "Kitchen temperature", ContentType: ContentTypeJSON, Vendor: "acme", DataSourceType: "temperature", DataSourceID: "kitchenTemp", StoreType: StoreTypeTS, Unit: "C", Location: "kitchen"},
+		{Description: "Hall temperature", ContentType: ContentTypeJSON, Vendor: "acme", DataSourceType: "temperature", DataSourceID: "hallTemp", StoreType: StoreTypeTS, Unit: "C", Location: "hall"},
+		{Description: "Kitchen light", ContentType: ContentTypeJSON, Vendor: "bulbco", DataSourceType: "light", DataSourceID: "kitchenLight", StoreType: StoreTypeKV, IsActuator: true, Location: "kitchen"},
+	} {
+		item, err := DataSourceMetadataToHypercat(dm, "tcp://driver-test-core-store:5555")
+		if err != nil {
+			t.Fatalf("DataSourceMetadataToHypercat failed expected err to be nil got %s", err.Error())
+		}
+		root.Items = append(root.Items, item)
+	}
+
+	root.Items = append(root.Items, HypercatItem{
+		ItemMetadata: HypercatMetadata{
+			RelValPair{Rel: RelIsContentType, Val: HypercatCatalogueContentType},
+			RelValPair{Rel: RelHasDescription, Val: "driver-other-core-store"},
+		},
+		Href: "tcp://driver-other-core-store:5555",
+	})
+
+	return root
+}
+
+func TestCatalogueFind(t *testing.T) {
+
+	root := testCatalogue(t)
+	yes := true
+
+	tests := []struct {
+		query    DataSourceQuery
+		expected int
+	}{
+		{DataSourceQuery{}, 3},
+		{DataSourceQuery{Vendor: "acme"}, 2},
+		{DataSourceQuery{Location: "kitchen"}, 2},
+		{DataSourceQuery{Location: "kitchen", StoreType: StoreTypeTS}, 1},
+		{DataSourceQuery{IsActuator: &yes}, 1},
+		{DataSourceQuery{IsFunc: &yes}, 0},
+		{DataSourceQuery{Description: "TEMPERATURE"}, 2},
+		{DataSourceQuery{Unit: "C", ContentType: "json"}, 2},
+		{DataSourceQuery{DataSourceType: "humidity"}, 0},
+	}
+
+	for _, test := range tests {
+		found := root.Find(test.query)
+		if len(found) != test.expected {
+			t.Errorf("Find(%+v) expected %d results got %d", test.query, test.expected, len(found))
+		}
+		for _, entry := range found {
+			if entry.StoreURL != "tcp://driver-test-core-store:5555" {
+				t.Errorf("Find expected store url tcp://driver-test-core-store:5555 got %s", entry.StoreURL)
+			}
+		}
+	}
+}
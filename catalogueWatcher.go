@@ -0,0 +1,219 @@
+package libDatabox
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// CatalogueEventType describes how a datasource changed between two reads of the catalogue
+type CatalogueEventType string
+
+const (
+	CatalogueEventAdded   CatalogueEventType = "added"
+	CatalogueEventRemoved CatalogueEventType = "removed"
+	CatalogueEventChanged CatalogueEventType = "changed"
+	CatalogueEventError   CatalogueEventType = "error"
+)
+
+// DefaultCatalogueWatchInterval is how often WatchCatalogue reads the catalogues if no interval is given
+const DefaultCatalogueWatchInterval = 10 * time.Second
+
+// CatalogueEvent is sent by WatchCatalogue when a datasource is registered, removed or its metadata changes.
+// For removed datasources Metadata holds the last known metadata, for changed datasources Previous
+// holds the metadata before the change. Error events report a store catalogue that could not be read,
+// Href is the catalogue link and Err the reason.
+type CatalogueEvent struct {
+	Type     CatalogueEventType
+	Href     string
+	StoreURL string
+	Metadata DataSourceMetadata
+	Previous DataSourceMetadata
+	Err      error
+}
+
+// catalogueSnapshot holds the datasources found by WatchCatalogue keyed by the href of the store
+// catalogue they were read from and then by their own href. Datasources in the root catalogue use "".
+type catalogueSnapshot map[string]map[string]CatalogueEntry
+
+// WatchCatalogue polls the arbiter root catalogue and all linked store catalogues every interval and
+// sends an event for each datasource matching q that is added, removed or changed. All datasources
+// found on the first read are sent as added. A store catalogue that can not be read is reported with
+// an error event and its datasources are kept as last seen until it can be read again, the changes
+// in the other stores are still sent. Close the returned done channel to stop watching, the event
+// channel will then be closed. An error is returned if the root catalogue can not be read.
+func (csc *CoreStoreClient) WatchCatalogue(q DataSourceQuery, interval time.Duration) (<-chan CatalogueEvent, chan struct{}, error) {
+
+	if interval <= 0 {
+		interval = DefaultCatalogueWatchInterval
+	}
+
+	rootCat, err := csc.Arbiter.GetRootDataSourceCatalogue()
+	if err != nil {
+		return nil, nil, errors.New("Error reading catalogue: " + err.Error())
+	}
+
+	eventChan := make(chan CatalogueEvent)
+	doneChan := make(chan struct{})
+
+	go func() {
+		defer close(eventChan)
+
+		known := catalogueSnapshot{}
+		send := func(rootCat HypercatRoot) bool {
+			next, failed := csc.readCatalogueSnapshot(rootCat, q, known)
+			events := append(failed, diffCatalogue(known.entries(), next.entries())...)
+			for _, event := range events {
+				select {
+				case eventChan <- event:
+				case <-doneChan:
+					return false
+				}
+			}
+			known = next
+			return true
+		}
+
+		if !send(rootCat) {
+			return
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-doneChan:
+				return
+			case <-ticker.C:
+				rootCat, err := csc.Arbiter.GetRootDataSourceCatalogue()
+				if err != nil {
+					//without the root catalogue nothing can be compared so wait for the next poll
+					Warn("[WatchCatalogue] Error reading catalogue: " + err.Error())
+					continue
+				}
+				if !send(rootCat) {
+					return
+				}
+			}
+		}
+	}()
+
+	return eventChan, doneChan, nil
+}
+
+// readCatalogueSnapshot reads the store catalogues linked from rootCat. Stores that can not be read
+// keep their entries from last and an error event is returned for each of them.
+func (csc *CoreStoreClient) readCatalogueSnapshot(rootCat HypercatRoot, q DataSourceQuery, last catalogueSnapshot) (catalogueSnapshot, []CatalogueEvent) {
+
+	hrefs := []string{}
+	for _, item := range rootCat.Items {
+		if item.IsCatalogueLink() {
+			hrefs = append(hrefs, item.Href)
+		}
+	}
+
+	storeCats, errs := csc.readStoreCatalogues(hrefs)
+
+	return mergeCatalogueSnapshot(rootCat, q, hrefs, storeCats, errs, last)
+}
+
+// mergeCatalogueSnapshot builds the snapshot of the stores linked at hrefs from the catalogues that were
+// read, failed stores keep their entries from last. Stores no longer linked are dropped.
+func mergeCatalogueSnapshot(rootCat HypercatRoot, q DataSourceQuery, hrefs []string, storeCats map[string]HypercatRoot, errs map[string]error, last catalogueSnapshot) (catalogueSnapshot, []CatalogueEvent) {
+
+	next := catalogueSnapshot{"": catalogueEntriesByHref(rootCat.Find(q))}
+	failed := []CatalogueEvent{}
+
+	for _, href := range hrefs {
+		if storeCat, ok := storeCats[href]; ok {
+			next[href] = catalogueEntriesByHref(storeCat.Find(q))
+			continue
+		}
+		if entries, ok := last[href]; ok {
+			next[href] = entries
+		}
+		storeURL, _ := GetStoreURLFromDsHref(href)
+		failed = append(failed, CatalogueEvent{
+			Type:     CatalogueEventError,
+			Href:     href,
+			StoreURL: storeURL,
+			Err:      errs[href],
+		})
+	}
+
+	sort.Slice(failed, func(i, j int) bool {
+		return failed[i].Href < failed[j].Href
+	})
+
+	return next, failed
+}
+
+// entries returns the datasources of all stores keyed by href
+func (s catalogueSnapshot) entries() map[string]CatalogueEntry {
+
+	all := map[string]CatalogueEntry{}
+	for _, entries := range s {
+		for href, entry := range entries {
+			all[href] = entry
+		}
+	}
+
+	return all
+}
+
+func catalogueEntriesByHref(entries []CatalogueEntry) map[string]CatalogueEntry {
+
+	byHref := make(map[string]CatalogueEntry, len(entries))
+	for _, entry := range entries {
+		byHref[entry.Item.Href] = entry
+	}
+
+	return byHref
+}
+
+// diffCatalogue returns the events needed to get from the old to the new set of datasources sorted by href
+func diffCatalogue(old map[string]CatalogueEntry, new map[string]CatalogueEntry) []CatalogueEvent {
+
+	events := []CatalogueEvent{}
+
+	for href, entry := range new {
+		oldEntry, exists := old[href]
+		if !exists {
+			events = append(events, CatalogueEvent{
+				Type:     CatalogueEventAdded,
+				Href:     href,
+				StoreURL: entry.StoreURL,
+				Metadata: entry.Metadata,
+			})
+			continue
+		}
+		if !reflect.DeepEqual(oldEntry.Metadata, entry.Metadata) {
+			events = append(events, CatalogueEvent{
+				Type:     CatalogueEventChanged,
+				Href:     href,
+				StoreURL: entry.StoreURL,
+				Metadata: entry.Metadata,
+				Previous: oldEntry.Metadata,
+			})
+		}
+	}
+
+	for href, entry := range old {
+		if _, exists := new[href]; !exists {
+			events = append(events, CatalogueEvent{
+				Type:     CatalogueEventRemoved,
+				Href:     href,
+				StoreURL: entry.StoreURL,
+				Metadata: entry.Metadata,
+			})
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Href < events[j].Href
+	})
+
+	return events
+}
@@ -0,0 +1,91 @@
+package libDatabox
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDiffCatalogue(t *testing.T) {
+
+	root := testCatalogue(t)
+	entries := root.Find(DataSourceQuery{})
+
+	old := catalogueEntriesByHref(entries[:2])
+
+	changed := entries[1]
+	changed.Metadata.Location = "landing"
+
+	next := catalogueEntriesByHref([]CatalogueEntry{changed, entries[2]})
+
+	events := diffCatalogue(old, next)
+	if len(events) != 3 {
+		t.Fatalf("diffCatalogue expected 3 events got %d: %+v", len(events), events)
+	}
+
+	counts := map[CatalogueEventType]int{}
+	for _, event := range events {
+		counts[event.Type]++
+		switch event.Type {
+		case CatalogueEventAdded:
+			if event.Href != entries[2].Item.Href {
+				t.Errorf("diffCatalogue expected %s to be added got %s", entries[2].Item.Href, event.Href)
+			}
+		case CatalogueEventRemoved:
+			if event.Href != entries[0].Item.Href {
+				t.Errorf("diffCatalogue expected %s to be removed got %s", entries[0].Item.Href, event.Href)
+			}
+		case CatalogueEventChanged:
+			if event.Metadata.Location != "landing" || event.Previous.Location != "hall" {
+				t.Errorf("diffCatalogue expected location change hall -> landing got %s -> %s", event.Previous.Location, event.Metadata.Location)
+			}
+		}
+	}
+
+	if counts[CatalogueEventAdded] != 1 || counts[CatalogueEventRemoved] != 1 || counts[CatalogueEventChanged] != 1 {
+		t.Errorf("diffCatalogue expected one of each event got %v", counts)
+	}
+
+	if len(diffCatalogue(next, next)) != 0 {
+		t.Errorf("diffCatalogue expected no events for an unchanged catalogue")
+	}
+}
+
+func TestMergeCatalogueSnapshot(t *testing.T) {
+
+	root := testCatalogue(t)
+	entries := root.Find(DataSourceQuery{})
+
+	storeA := "tcp://driver-a-core-store:5555"
+	storeB := "tcp://driver-b-core-store:5555"
+	hrefs := []string{storeA, storeB}
+
+	last := catalogueSnapshot{
+		storeA: catalogueEntriesByHref(entries[:1]),
+		storeB: catalogueEntriesByHref(entries[1:2]),
+	}
+
+	storeCats := map[string]HypercatRoot{
+		storeA: {Items: []HypercatItem{entries[0].Item, entries[2].Item}},
+	}
+	errs := map[string]error{storeB: errors.New("timeout")}
+
+	next, failed := mergeCatalogueSnapshot(HypercatRoot{}, DataSourceQuery{}, hrefs, storeCats, errs, last)
+
+	if len(failed) != 1 || failed[0].Type != CatalogueEventError || failed[0].Href != storeB || failed[0].Err == nil {
+		t.Fatalf("mergeCatalogueSnapshot expected one error event for %s got %+v", storeB, failed)
+	}
+	if failed[0].StoreURL != storeB {
+		t.Errorf("mergeCatalogueSnapshot expected store url %s got %s", storeB, failed[0].StoreURL)
+	}
+
+	events := diffCatalogue(last.entries(), next.entries())
+	if len(events) != 1 || events[0].Type != CatalogueEventAdded || events[0].Href != entries[2].Item.Href {
+		t.Errorf("mergeCatalogueSnapshot expected only %s to be added got %+v", entries[2].Item.Href, events)
+	}
+
+	next, _ = mergeCatalogueSnapshot(HypercatRoot{}, DataSourceQuery{}, []string{storeA}, storeCats, nil, next)
+	events = diffCatalogue(last.entries(), next.entries())
+	if len(events) != 2 {
+		t.Errorf("mergeCatalogueSnapshot expected the entries of an unlinked store to be removed got %+v", events)
+	}
+}
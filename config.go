@@ -0,0 +1,182 @@
+package libDatabox
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// insecure values used when the databox secrets can not be read, these only work with test setups
+const insecureArbiterToken = "secret"
+const insecureStorePublicKey = "vl6wu0A@XP?}Or/&BR#LSxn>A+}L)p44/W[wXL3<"
+
+// Environment variables read by LoadConfig in addition to EnvArbiterEndpoint and EnvStoreEndpoint
+const (
+	EnvConfigFile         = "DATABOX_CONFIG_FILE"
+	EnvArbiterTokenPath   = "DATABOX_ARBITER_TOKEN_PATH"
+	EnvStorePublicKeyPath = "DATABOX_ZMQ_PUBLIC_KEY_PATH"
+	EnvEnableLogging      = "DATABOX_ENABLE_LOGGING"
+	EnvStrictConfig       = "DATABOX_STRICT_CONFIG"
+)
+
+// Config holds everything needed to connect to the arbiter and a store.
+//
+// LoadConfig fills it in this order, later sources override earlier ones:
+// built-in defaults, the json config file, environment variables and finally the
+// secrets (arbiter token and ZMQ public key) read from ArbiterTokenPath and StorePublicKeyPath.
+// Secrets are never read from the config file.
+type Config struct {
+	ArbiterURI         string `json:"arbiter-uri"`
+	StoreURI           string `json:"store-uri"`
+	ArbiterTokenPath   string `json:"arbiter-token-path"`
+	StorePublicKeyPath string `json:"store-public-key-path"`
+	EnableLogging      bool   `json:"enable-logging"`
+	Strict             bool   `json:"strict"` //refuse to use the insecure built-in secrets
+
+	ArbiterToken   string `json:"-"`
+	StorePublicKey string `json:"-"`
+
+	// InsecureDefaults lists the secrets that could not be read and use built-in values
+	InsecureDefaults []string `json:"-"`
+}
+
+// DefaultConfig returns the built-in configuration used inside databox
+func DefaultConfig() Config {
+	return Config{
+		ArbiterURI:         DefaultArbiterURI,
+		ArbiterTokenPath:   DefaultArbiterKeyPath,
+		StorePublicKeyPath: DefaultStorePublicKeyPath,
+	}
+}
+
+// LoadConfig builds a Config from the defaults, configFile, the environment and the secrets. If configFile
+// is empty the file named in DATABOX_CONFIG_FILE is used, if that is not set no file is read.
+func LoadConfig(configFile string) (Config, error) {
+	return loadConfig(configFile, os.LookupEnv)
+}
+
+func loadConfig(configFile string, lookupEnv func(string) (string, bool)) (Config, error) {
+
+	cfg := DefaultConfig()
+
+	if configFile == "" {
+		configFile, _ = lookupEnv(EnvConfigFile)
+	}
+
+	if configFile != "" {
+		data, err := ioutil.ReadFile(configFile)
+		if err != nil {
+			return Config{}, errors.New("Error reading config file: " + err.Error())
+		}
+		err = json.Unmarshal(data, &cfg)
+		if err != nil {
+			return Config{}, errors.New("Error decoding config file " + configFile + ": " + err.Error())
+		}
+	}
+
+	if v, ok := lookupEnv(EnvArbiterEndpoint); ok && v != "" {
+		cfg.ArbiterURI = v
+	}
+	if v, ok := lookupEnv(EnvStoreEndpoint); ok && v != "" {
+		cfg.StoreURI = v
+	}
+	if v, ok := lookupEnv(EnvArbiterTokenPath); ok && v != "" {
+		cfg.ArbiterTokenPath = v
+	}
+	if v, ok := lookupEnv(EnvStorePublicKeyPath); ok && v != "" {
+		cfg.StorePublicKeyPath = v
+	}
+	for name, dest := range map[string]*bool{EnvEnableLogging: &cfg.EnableLogging, EnvStrictConfig: &cfg.Strict} {
+		v, ok := lookupEnv(name)
+		if !ok || v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, errors.New("Invalid value for " + name + ": " + v)
+		}
+		*dest = b
+	}
+
+	cfg.loadSecrets()
+
+	return cfg, cfg.Validate()
+}
+
+// loadSecrets reads the arbiter token and ZMQ public key if they have not been set,
+// falling back to the insecure built-in values.
+func (cfg *Config) loadSecrets() {
+
+	cfg.InsecureDefaults = nil
+
+	if cfg.ArbiterToken == "" {
+		token, err := ioutil.ReadFile(cfg.ArbiterTokenPath)
+		if err != nil || len(token) == 0 {
+			cfg.ArbiterToken = insecureArbiterToken
+			cfg.InsecureDefaults = append(cfg.InsecureDefaults, "ARBITER_TOKEN")
+		} else {
+			cfg.ArbiterToken = string(token)
+		}
+	}
+
+	if cfg.StorePublicKey == "" {
+		key, err := ioutil.ReadFile(cfg.StorePublicKeyPath)
+		if err != nil || len(key) == 0 {
+			cfg.StorePublicKey = insecureStorePublicKey
+			cfg.InsecureDefaults = append(cfg.InsecureDefaults, "ZMQ_PUBLIC_KEY")
+		} else {
+			cfg.StorePublicKey = string(key)
+		}
+	}
+}
+
+// Validate checks the config is usable. In strict mode using the insecure built-in secrets is an error.
+func (cfg Config) Validate() error {
+
+	if cfg.ArbiterURI == "" {
+		return errors.New("Invalid config: arbiter-uri is required")
+	}
+
+	if cfg.Strict && len(cfg.InsecureDefaults) > 0 {
+		return errors.New("Invalid config: strict mode refuses insecure default " + strings.Join(cfg.InsecureDefaults, ", "))
+	}
+
+	return nil
+}
+
+// NewCoreStoreClientFromConfig creates an ArbiterClient and a CoreStoreClient for cfg.StoreURI.
+// The ArbiterClient is available as Arbiter on the returned client.
+func NewCoreStoreClientFromConfig(cfg Config) (*CoreStoreClient, error) {
+
+	if cfg.ArbiterToken == "" || cfg.StorePublicKey == "" {
+		cfg.loadSecrets()
+	}
+
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.StoreURI == "" {
+		return nil, errors.New("Invalid config: store-uri is required")
+	}
+
+	for _, secret := range cfg.InsecureDefaults {
+		Warn("failed to read " + secret + " using insecure default value")
+	}
+
+	arbiterClient, err := newArbiterClient(cfg.ArbiterToken, cfg.StorePublicKey, cfg.ArbiterURI)
+	if err != nil {
+		return nil, err
+	}
+
+	csc, err := newCoreStoreClient(arbiterClient, cfg.StorePublicKey, cfg.StoreURI, cfg.EnableLogging)
+	if err != nil {
+		return nil, errors.New("Error connecting to store " + cfg.StoreURI + ": " + err.Error())
+	}
+
+	return csc, nil
+}
@@ -0,0 +1,108 @@
+package libDatabox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testEnv(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "libDataboxConfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configFile := filepath.Join(dir, "config.json")
+	tokenFile := filepath.Join(dir, "token")
+	keyFile := filepath.Join(dir, "key")
+	ioutil.WriteFile(configFile, []byte(`{"arbiter-uri":"tcp://file-arbiter:4444","store-uri":"tcp://file-store:5555","arbiter-token-path":"`+tokenFile+`","enable-logging":true}`), 0600)
+	ioutil.WriteFile(tokenFile, []byte("fileToken"), 0600)
+	ioutil.WriteFile(keyFile, []byte("fileKey"), 0600)
+
+	cfg, err := loadConfig(configFile, testEnv(map[string]string{
+		EnvStoreEndpoint:      "tcp://env-store:5555",
+		EnvStorePublicKeyPath: keyFile,
+	}))
+	if err != nil {
+		t.Fatalf("loadConfig failed expected err to be nil got %s", err.Error())
+	}
+
+	if cfg.ArbiterURI != "tcp://file-arbiter:4444" {
+		t.Errorf("loadConfig expected arbiter-uri from file got %s", cfg.ArbiterURI)
+	}
+	if cfg.StoreURI != "tcp://env-store:5555" {
+		t.Errorf("loadConfig expected store-uri from env got %s", cfg.StoreURI)
+	}
+	if !cfg.EnableLogging {
+		t.Errorf("loadConfig expected enable-logging from file")
+	}
+	if cfg.ArbiterToken != "fileToken" || cfg.StorePublicKey != "fileKey" {
+		t.Errorf("loadConfig expected secrets from files got %s and %s", cfg.ArbiterToken, cfg.StorePublicKey)
+	}
+	if len(cfg.InsecureDefaults) != 0 {
+		t.Errorf("loadConfig expected no insecure defaults got %v", cfg.InsecureDefaults)
+	}
+}
+
+func TestLoadConfigStrict(t *testing.T) {
+
+	env := map[string]string{
+		EnvArbiterTokenPath:   "/does/not/exist",
+		EnvStorePublicKeyPath: "/does/not/exist",
+	}
+
+	cfg, err := loadConfig("", testEnv(env))
+	if err != nil {
+		t.Fatalf("loadConfig failed expected err to be nil got %s", err.Error())
+	}
+	if cfg.ArbiterToken != insecureArbiterToken || len(cfg.InsecureDefaults) != 2 {
+		t.Errorf("loadConfig expected insecure defaults got %v", cfg.InsecureDefaults)
+	}
+
+	env[EnvStrictConfig] = "true"
+	_, err = loadConfig("", testEnv(env))
+	if err == nil {
+		t.Errorf("loadConfig expected strict mode to refuse insecure defaults")
+	}
+
+	env[EnvStrictConfig] = "maybe"
+	_, err = loadConfig("", testEnv(env))
+	if err == nil {
+		t.Errorf("loadConfig expected an error for an invalid bool")
+	}
+}
+
+func TestNewCoreStoreClientFromConfig(t *testing.T) {
+
+	cfg := Config{
+		ArbiterURI:     ArbiterURL,
+		StoreURI:       StoreURL,
+		ArbiterToken:   "token",
+		StorePublicKey: "key",
+		Strict:         true,
+	}
+
+	csc, err := NewCoreStoreClientFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("NewCoreStoreClientFromConfig failed expected err to be nil got %s", err.Error())
+	}
+	if csc.Arbiter == nil || csc.Arbiter.ArbiterToken != "token" || csc.ZEndpoint != StoreURL {
+		t.Errorf("NewCoreStoreClientFromConfig returned a badly configured client")
+	}
+
+	cfg.StoreURI = ""
+	_, err = NewCoreStoreClientFromConfig(cfg)
+	if err == nil {
+		t.Errorf("NewCoreStoreClientFromConfig expected an error without a store-uri")
+	}
+}
@@ -0,0 +1,472 @@
+package libDatabox
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	zest "github.com/me-box/goZestClient"
+)
+
+// DefaultReadCacheMaxEntries is the number of values the read cache keeps if no limit is given
+const DefaultReadCacheMaxEntries = 1000
+
+// DefaultReadCacheMaxAge is how long a value is cached if no MaxAge is given
+const DefaultReadCacheMaxAge = 30 * time.Second
+
+// readCacheObserveRetry is how long reads of a datasource that could not be observed skip the cache
+const readCacheObserveRetry = 30 * time.Second
+
+// ReadCacheOptions sets the size of the read cache. MaxEntries <= 0 uses DefaultReadCacheMaxEntries,
+// MaxBytes <= 0 does not limit the size of the cached values. Values are read again once they are
+// older than MaxAge, 0 uses DefaultReadCacheMaxAge and < 0 keeps them until they are invalidated.
+type ReadCacheOptions struct {
+	MaxEntries int
+	MaxBytes   int
+	MaxAge     time.Duration
+}
+
+// ReadCacheStats are the counters of the read cache since it was enabled
+type ReadCacheStats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+	Entries       int    `json:"entries"`
+	Bytes         int    `json:"bytes"`
+}
+
+// EnableReadCache starts caching KVStore.Read, TSBlobStore.Latest and TSStore.Latest. The first read
+// from a datasource observes it and later writes update or drop the cached values, if the datasource
+// can not be observed its reads are not cached. Calling it again replaces the cache with an empty one.
+//
+// The store does not report deletes. Deletes made by this client and KV deletes by clients publishing
+// delete events (see KVStore.PublishDeletes) drop the cached values straight away, other deletes are
+// only seen once the values are older than ReadCacheOptions.MaxAge.
+func (csc *CoreStoreClient) EnableReadCache(opts ReadCacheOptions) {
+	csc.cache.set(newReadCache(csc, opts)).close()
+}
+
+// DisableReadCache stops caching reads and stops observing the cached datasources
+func (csc *CoreStoreClient) DisableReadCache() {
+	csc.cache.set(nil).close()
+}
+
+// ReadCacheStats returns the counters of the read cache, they are all zero if the cache is not enabled
+func (csc *CoreStoreClient) ReadCacheStats() ReadCacheStats {
+	return csc.cache.get().statistics()
+}
+
+// readCacheRef holds the read cache of a client, it is shared with the clients returned by WithSpan
+type readCacheRef struct {
+	v    atomic.Value
+	lock *sync.Mutex
+}
+
+type readCacheBox struct {
+	c *readCache
+}
+
+func (r *readCacheRef) get() *readCache {
+	if r == nil {
+		return nil
+	}
+	if box, ok := r.v.Load().(readCacheBox); ok {
+		return box.c
+	}
+	return nil
+}
+
+// set replaces the cache and returns the old one
+func (r *readCacheRef) set(c *readCache) *readCache {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	old := r.get()
+	r.v.Store(readCacheBox{c})
+
+	return old
+}
+
+// cacheWatchSpec describes the observe request that keeps a group of cached values fresh
+type cacheWatchSpec struct {
+	contentType StoreContentType
+	path        string
+	onEvent     func(c *readCache, w *cacheWatch, resp ObserveResponse) //called with the cache locked
+}
+
+// cacheWatch is a running observe request and the cached values it keeps fresh. generation is
+// incremented whenever the values may have changed so reads that started before are not cached.
+type cacheWatch struct {
+	key        string
+	spec       cacheWatchSpec
+	keys       map[string]bool
+	generation uint64
+	ready      chan struct{} //closed once the observe request has been sent
+	err        error
+	retryAt    time.Time
+	stop       func()
+}
+
+type cacheEntry struct {
+	key    string
+	watch  *cacheWatch
+	value  []byte
+	expiry time.Time //zero if the entry does not expire
+}
+
+// readCache is a least recently used cache of store reads. All methods can be called on a nil
+// readCache, reads then go straight to the store.
+type readCache struct {
+	csc        *CoreStoreClient
+	maxEntries int
+	maxBytes   int
+	maxAge     time.Duration
+
+	lock    *sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	watches map[string]*cacheWatch
+	bytes   int
+	stats   ReadCacheStats
+	closed  bool
+}
+
+func newReadCache(csc *CoreStoreClient, opts ReadCacheOptions) *readCache {
+
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = DefaultReadCacheMaxEntries
+	}
+	if opts.MaxAge == 0 {
+		opts.MaxAge = DefaultReadCacheMaxAge
+	}
+
+	return &readCache{
+		csc:        csc,
+		maxEntries: opts.MaxEntries,
+		maxBytes:   opts.MaxBytes,
+		maxAge:     opts.MaxAge,
+		lock:       &sync.Mutex{},
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		watches:    make(map[string]*cacheWatch),
+	}
+}
+
+func cacheKey(contentType StoreContentType, path string) string {
+	return string(contentType) + " " + path
+}
+
+// read returns the value at path from the cache or reads it with fetch and caches it
+func (c *readCache) read(spec cacheWatchSpec, path string, fetch func() ([]byte, error)) ([]byte, error) {
+
+	if c == nil {
+		return fetch()
+	}
+
+	key := cacheKey(spec.contentType, path)
+
+	c.lock.Lock()
+	if elem, ok := c.entries[key]; ok && c.expiredLocked(elem) {
+		//it may have been deleted by a client that does not publish delete events
+		c.removeLocked(elem)
+		c.stats.Invalidations++
+	} else if ok {
+		c.lru.MoveToFront(elem)
+		c.stats.Hits++
+		value := append([]byte{}, elem.Value.(*cacheEntry).value...)
+		c.lock.Unlock()
+		c.record("hit")
+		return value, nil
+	}
+	c.stats.Misses++
+	c.lock.Unlock()
+	c.record("miss")
+
+	w := c.watch(spec)
+	if w == nil {
+		return fetch()
+	}
+
+	c.lock.Lock()
+	generation := w.generation
+	c.lock.Unlock()
+
+	value, err := fetch()
+	if err != nil {
+		return value, err
+	}
+
+	c.put(w, generation, key, append([]byte{}, value...))
+
+	return value, nil
+}
+
+// invalidate drops the cached value at path after a write by this client, an empty path drops all values kept fresh by spec
+func (c *readCache) invalidate(spec cacheWatchSpec, path string) {
+
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	w, ok := c.watches[cacheKey(spec.contentType, spec.path)]
+	if !ok {
+		return
+	}
+
+	if path == "" {
+		c.invalidateWatchLocked(w)
+		return
+	}
+
+	w.generation++
+	if elem, ok := c.entries[cacheKey(spec.contentType, path)]; ok {
+		c.removeLocked(elem)
+		c.stats.Invalidations++
+	}
+}
+
+// watch returns the running observe request for spec starting it if needed, nil is returned if the
+// datasource can not be observed
+func (c *readCache) watch(spec cacheWatchSpec) *cacheWatch {
+
+	key := cacheKey(spec.contentType, spec.path)
+
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return nil
+	}
+	w, ok := c.watches[key]
+	if ok && w.err != nil && time.Now().After(w.retryAt) {
+		ok = false
+	}
+	if !ok {
+		w = &cacheWatch{
+			key:   key,
+			spec:  spec,
+			keys:  make(map[string]bool),
+			ready: make(chan struct{}),
+		}
+		c.watches[key] = w
+		c.lock.Unlock()
+		c.startWatch(w)
+	} else {
+		c.lock.Unlock()
+	}
+
+	<-w.ready
+	if w.err != nil {
+		return nil
+	}
+
+	return w
+}
+
+func (c *readCache) startWatch(w *cacheWatch) {
+
+	observeChan, stop, err := c.csc.observeWithCancel(w.spec.path, w.spec.contentType, zest.ObserveModeData)
+
+	c.lock.Lock()
+	w.err = err
+	w.stop = stop
+	if err != nil {
+		w.retryAt = time.Now().Add(readCacheObserveRetry)
+		Debug("[readCache] not caching " + w.spec.path + ": " + err.Error())
+	} else if c.closed {
+		stop()
+	}
+	c.lock.Unlock()
+	close(w.ready)
+
+	if err != nil {
+		return
+	}
+
+	go func() {
+		for resp := range observeChan {
+			c.lock.Lock()
+			w.spec.onEvent(c, w, resp)
+			c.lock.Unlock()
+		}
+
+		//the observe request has ended so the values can no longer be kept fresh
+		c.lock.Lock()
+		if c.watches[w.key] == w {
+			delete(c.watches, w.key)
+		}
+		c.invalidateWatchLocked(w)
+		c.lock.Unlock()
+	}()
+}
+
+func (c *readCache) put(w *cacheWatch, generation uint64, key string, value []byte) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed || c.watches[w.key] != w || w.generation != generation {
+		return
+	}
+
+	size := len(key) + len(value)
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		c.removeLocked(elem)
+	}
+	entry := &cacheEntry{key: key, watch: w, value: value}
+	if c.maxAge > 0 {
+		entry.expiry = time.Now().Add(c.maxAge)
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	w.keys[key] = true
+	c.bytes += size
+
+	for c.lru.Len() > c.maxEntries || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.removeLocked(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// updateLocked replaces a cached value after the store reported a write, values that are not cached are not added
+func (c *readCache) updateLocked(w *cacheWatch, key string, value []byte) {
+
+	w.generation++
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	if len(value) == 0 {
+		c.removeLocked(elem)
+		c.stats.Invalidations++
+		return
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	c.bytes += len(value) - len(entry.value)
+	entry.value = append([]byte{}, value...)
+	if c.maxAge > 0 {
+		entry.expiry = time.Now().Add(c.maxAge)
+	}
+	c.lru.MoveToFront(elem)
+}
+
+func (c *readCache) expiredLocked(elem *list.Element) bool {
+	expiry := elem.Value.(*cacheEntry).expiry
+	return !expiry.IsZero() && time.Now().After(expiry)
+}
+
+func (c *readCache) invalidateWatchLocked(w *cacheWatch) {
+
+	w.generation++
+	for key := range w.keys {
+		if elem, ok := c.entries[key]; ok {
+			c.removeLocked(elem)
+			c.stats.Invalidations++
+		}
+	}
+}
+
+func (c *readCache) removeLocked(elem *list.Element) {
+
+	entry := elem.Value.(*cacheEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	delete(entry.watch.keys, entry.key)
+	c.bytes -= len(entry.key) + len(entry.value)
+}
+
+func (c *readCache) statistics() ReadCacheStats {
+
+	if c == nil {
+		return ReadCacheStats{}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	stats.Bytes = c.bytes
+
+	return stats
+}
+
+func (c *readCache) record(result string) {
+	c.csc.metrics.get().AddCounter(MetricStoreCacheRequests, MetricLabels{"store": c.csc.ZEndpoint, "result": result}, 1)
+}
+
+// close stops observing the cached datasources and drops all values
+func (c *readCache) close() {
+
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	c.closed = true
+	stops := []func(){}
+	for _, w := range c.watches {
+		if w.stop != nil {
+			stops = append(stops, w.stop)
+		}
+	}
+	c.watches = make(map[string]*cacheWatch)
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.bytes = 0
+	c.lock.Unlock()
+
+	for _, stop := range stops {
+		stop()
+	}
+}
+
+// cacheWatch keeps cached values of the datasource up to date with writes from any client
+func (kvj *KVStore) cacheWatch(dataSourceID string) cacheWatchSpec {
+
+	return cacheWatchSpec{
+		contentType: kvj.contentType,
+		path:        "/kv/" + dataSourceID + "/*",
+		onEvent: func(c *readCache, w *cacheWatch, resp ObserveResponse) {
+			if resp.Key == DeleteEventKey {
+				c.invalidateWatchLocked(w)
+				return
+			}
+			//expiry records are cached along with the values
+			c.updateLocked(w, cacheKey(kvj.contentType, "/kv/"+dataSourceID+"/"+resp.Key), resp.Data)
+		},
+	}
+}
+
+// cacheWatch drops the cached latest value of the datasource when anything is written to it
+func (tbs *TSBlobStore) cacheWatch(dataSourceID string) cacheWatchSpec {
+
+	return cacheWatchSpec{
+		contentType: tbs.contentType,
+		path:        "/ts/blob/" + dataSourceID,
+		onEvent: func(c *readCache, w *cacheWatch, resp ObserveResponse) {
+			c.invalidateWatchLocked(w)
+		},
+	}
+}
+
+// cacheWatch drops the cached latest value of the datasource when anything is written to it
+func (tsc TSStore) cacheWatch(dataSourceID string) cacheWatchSpec {
+
+	return cacheWatchSpec{
+		contentType: ContentTypeJSON,
+		path:        "/ts/" + dataSourceID,
+		onEvent: func(c *readCache, w *cacheWatch, resp ObserveResponse) {
+			c.invalidateWatchLocked(w)
+		},
+	}
+}
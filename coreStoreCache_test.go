@@ -0,0 +1,221 @@
+package libDatabox
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newWatchedReadCache returns a cache that treats spec as already observed
+func newWatchedReadCache(opts ReadCacheOptions, spec cacheWatchSpec) (*readCache, *cacheWatch) {
+
+	c := newReadCache(StoreClient, opts)
+	w := &cacheWatch{
+		key:   cacheKey(spec.contentType, spec.path),
+		spec:  spec,
+		keys:  make(map[string]bool),
+		ready: make(chan struct{}),
+	}
+	close(w.ready)
+	c.watches[w.key] = w
+
+	return c, w
+}
+
+func countingFetch(count *int, value string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		*count++
+		return []byte(value), nil
+	}
+}
+
+func TestReadCacheHitMiss(t *testing.T) {
+
+	spec := StoreClient.KVJSON.cacheWatch(dsID)
+	c, _ := newWatchedReadCache(ReadCacheOptions{}, spec)
+
+	fetches := 0
+	for i := 0; i < 3; i++ {
+		value, err := c.read(spec, "/kv/"+dsID+"/a", countingFetch(&fetches, `{"v":1}`))
+		if err != nil || string(value) != `{"v":1}` {
+			t.Errorf("read expected {\"v\":1} got %s %v", value, err)
+		}
+	}
+
+	if fetches != 1 {
+		t.Errorf("read expected 1 fetch got %d", fetches)
+	}
+	stats := c.statistics()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Errorf("statistics expected 2 hits 1 miss and 1 entry got %+v", stats)
+	}
+}
+
+func TestReadCacheMaxAge(t *testing.T) {
+
+	spec := StoreClient.KVJSON.cacheWatch(dsID)
+	path := "/kv/" + dsID + "/a"
+
+	for maxAge, expected := range map[time.Duration]int{20 * time.Millisecond: 2, -1: 1} {
+		c, _ := newWatchedReadCache(ReadCacheOptions{MaxAge: maxAge}, spec)
+
+		fetches := 0
+		c.read(spec, path, countingFetch(&fetches, "1"))
+		time.Sleep(40 * time.Millisecond)
+		c.read(spec, path, countingFetch(&fetches, "1"))
+
+		if fetches != expected {
+			t.Errorf("read with MaxAge %s expected %d fetches got %d", maxAge, expected, fetches)
+		}
+	}
+}
+
+func TestReadCacheEviction(t *testing.T) {
+
+	spec := StoreClient.KVJSON.cacheWatch(dsID)
+	c, _ := newWatchedReadCache(ReadCacheOptions{MaxEntries: 2}, spec)
+
+	fetches := 0
+	c.read(spec, "/kv/"+dsID+"/a", countingFetch(&fetches, "1"))
+	c.read(spec, "/kv/"+dsID+"/b", countingFetch(&fetches, "2"))
+	c.read(spec, "/kv/"+dsID+"/a", countingFetch(&fetches, "1"))
+	c.read(spec, "/kv/"+dsID+"/c", countingFetch(&fetches, "3"))
+
+	//b was the least recently used
+	c.read(spec, "/kv/"+dsID+"/a", countingFetch(&fetches, "1"))
+	c.read(spec, "/kv/"+dsID+"/b", countingFetch(&fetches, "2"))
+
+	if fetches != 4 {
+		t.Errorf("read expected 4 fetches got %d", fetches)
+	}
+	stats := c.statistics()
+	if stats.Evictions != 2 || stats.Entries != 2 {
+		t.Errorf("statistics expected 2 evictions and 2 entries got %+v", stats)
+	}
+}
+
+func TestReadCacheMaxBytes(t *testing.T) {
+
+	spec := StoreClient.KVJSON.cacheWatch(dsID)
+	path := "/kv/" + dsID + "/a"
+	c, _ := newWatchedReadCache(ReadCacheOptions{MaxBytes: len(cacheKey(ContentTypeJSON, path)) + 4}, spec)
+
+	fetches := 0
+	c.read(spec, path, countingFetch(&fetches, "12345"))
+	c.read(spec, path, countingFetch(&fetches, "12345"))
+	if fetches != 2 {
+		t.Errorf("read of a value larger than MaxBytes expected 2 fetches got %d", fetches)
+	}
+
+	c.read(spec, path, countingFetch(&fetches, "1234"))
+	c.read(spec, path, countingFetch(&fetches, "1234"))
+	if fetches != 3 || c.statistics().Bytes != c.maxBytes {
+		t.Errorf("read of a value that fits expected 3 fetches got %d %+v", fetches, c.statistics())
+	}
+}
+
+func TestReadCacheKVEvents(t *testing.T) {
+
+	spec := StoreClient.KVJSON.cacheWatch(dsID)
+	c, w := newWatchedReadCache(ReadCacheOptions{}, spec)
+
+	fetches := 0
+	c.read(spec, "/kv/"+dsID+"/a", countingFetch(&fetches, `{"v":1}`))
+	c.read(spec, "/kv/"+dsID+"/b", countingFetch(&fetches, `{"v":2}`))
+
+	c.lock.Lock()
+	spec.onEvent(c, w, ObserveResponse{DataSourceID: dsID, Key: "a", Data: []byte(`{"v":3}`)})
+	spec.onEvent(c, w, ObserveResponse{DataSourceID: dsID, Key: BatchEventKey, Data: []byte(`{}`)})
+	c.lock.Unlock()
+
+	value, _ := c.read(spec, "/kv/"+dsID+"/a", countingFetch(&fetches, `{"v":1}`))
+	if string(value) != `{"v":3}` || fetches != 2 {
+		t.Errorf("read after a write event expected the new value from the cache got %s after %d fetches", value, fetches)
+	}
+
+	c.lock.Lock()
+	spec.onEvent(c, w, ObserveResponse{DataSourceID: dsID, Key: DeleteEventKey, Data: []byte(`{"keys":["b"]}`)})
+	c.lock.Unlock()
+
+	if stats := c.statistics(); stats.Entries != 0 || stats.Invalidations != 2 {
+		t.Errorf("delete event expected all entries to be invalidated got %+v", stats)
+	}
+}
+
+func TestReadCacheInvalidateDuringFetch(t *testing.T) {
+
+	spec := StoreClient.TSBlobJSON.cacheWatch(dsID)
+	path := "/ts/blob/" + dsID + "/latest"
+	c, _ := newWatchedReadCache(ReadCacheOptions{}, spec)
+
+	c.read(spec, path, func() ([]byte, error) {
+		//a write by this client while the read is in flight
+		c.invalidate(spec, "")
+		return []byte("old"), nil
+	})
+
+	if c.statistics().Entries != 0 {
+		t.Error("read expected a value fetched before an invalidation not to be cached")
+	}
+}
+
+func TestReadCacheDisabled(t *testing.T) {
+
+	var c *readCache
+
+	fetches := 0
+	c.read(StoreClient.KVJSON.cacheWatch(dsID), "/kv/"+dsID+"/a", countingFetch(&fetches, "1"))
+	c.read(StoreClient.KVJSON.cacheWatch(dsID), "/kv/"+dsID+"/a", countingFetch(&fetches, "1"))
+	c.invalidate(StoreClient.KVJSON.cacheWatch(dsID), "")
+	c.close()
+
+	if fetches != 2 || c.statistics() != (ReadCacheStats{}) {
+		t.Errorf("disabled cache expected every read to fetch got %d", fetches)
+	}
+}
+
+func TestReadCacheClose(t *testing.T) {
+
+	csc := NewCoreStoreClient(Arbiter, "", StoreURL, false)
+	csc.EnableReadCache(ReadCacheOptions{})
+	if csc.cache.get() == nil {
+		t.Fatal("EnableReadCache expected a cache")
+	}
+
+	csc.Close()
+	if csc.cache.get() != nil {
+		t.Error("Close expected the read cache to be disabled")
+	}
+}
+
+func TestKVJSONReadCache(t *testing.T) {
+
+	csc := NewCoreStoreClient(Arbiter, "", StoreURL, false)
+	defer csc.Close()
+	csc.EnableReadCache(ReadCacheOptions{})
+
+	key := "cache" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	err := csc.KVJSON.Write(dsID, key, []byte(`{"v":1}`))
+	if err != nil {
+		t.Fatalf("Write failed expected err to be nil got %s", err.Error())
+	}
+
+	csc.KVJSON.Read(dsID, key)
+	value, err := csc.KVJSON.Read(dsID, key)
+	if err != nil || string(value) != `{"v":1}` {
+		t.Errorf("Read expected {\"v\":1} got %s %v", value, err)
+	}
+
+	//a write from another client reaches the cache through observe
+	StoreClient.KVJSON.Write(dsID, key, []byte(`{"v":2}`))
+	time.Sleep(100 * time.Millisecond)
+
+	value, _ = csc.KVJSON.Read(dsID, key)
+	if string(value) != `{"v":2}` {
+		t.Errorf("Read after a write by another client expected {\"v\":2} got %s", value)
+	}
+
+	if stats := csc.ReadCacheStats(); stats.Hits < 2 {
+		t.Errorf("ReadCacheStats expected at least 2 hits got %+v", stats)
+	}
+}
@@ -6,8 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	zest "github.com/me-box/goZestClient"
 )
@@ -26,6 +30,52 @@ type CoreStoreClient struct {
 	TSJSON     *TSStore
 	FUNC       *Func
 	EXPORT     *Export
+
+	serverKey        string
+	enableLogging    bool
+	storeClients     map[string]zest.ZestClient
+	storeClientsLock *sync.Mutex
+
+	subscriptions     map[*subscription]struct{}
+	subscriptionsLock *sync.Mutex
+	workers           *sync.WaitGroup //goroutines forwarding observe and notify data
+	calls             *sync.WaitGroup //function calls and requests being processed
+	closing           chan struct{}   //closed when Close is called, no new observes or calls are accepted
+	closingLock       *sync.Mutex     //held while closing is closed so workers and calls are not added during Shutdown
+	closed            chan struct{}   //closed when Close has finished, all requests fail
+	closeOnce         *sync.Once
+	inFlight          *int64 //store requests that have started but not finished
+
+	metrics      *metricsRef
+	exporter     *exporterRef
+	cache        *readCacheRef
+	deleteEvents *deleteEvents
+	expiring     *expiringDataSources
+	parentSpan   SpanContext //parent of spans started by this client, see WithSpan
+}
+
+// DefaultCloseTimeout is how long Close waits for function calls to finish
+const DefaultCloseTimeout = 5 * time.Second
+
+var errClientClosed = errors.New("CoreStoreClient is closed")
+
+// observeDrainTimeout is how long a stopped observe keeps discarding messages from the zest client
+const observeDrainTimeout = time.Second
+
+// subscription is a running observe or notify request
+type subscription struct {
+	zestDone chan struct{}
+	stop     chan struct{}
+	once     *sync.Once
+}
+
+func (s *subscription) cancel() {
+	s.once.Do(func() {
+		close(s.stop)
+		if s.zestDone != nil {
+			close(s.zestDone)
+		}
+	})
 }
 
 func NewDefaultCoreStoreClient(storeEndPoint string) *CoreStoreClient {
@@ -35,24 +85,62 @@ func NewDefaultCoreStoreClient(storeEndPoint string) *CoreStoreClient {
 }
 
 func NewCoreStoreClient(arbiterClient *ArbiterClient, zmqPublicKeyPath string, storeEndPoint string, enableLogging bool) *CoreStoreClient {
-	csc := &CoreStoreClient{
-		Arbiter: arbiterClient,
-	}
 
 	//get the server key
 	serverKey, err := ioutil.ReadFile(zmqPublicKeyPath)
 	if err != nil {
 		fmt.Println("Warning:: failed to read ZMQ_PUBLIC_KEY using default value")
-		serverKey = []byte("vl6wu0A@XP?}Or/&BR#LSxn>A+}L)p44/W[wXL3<")
+		serverKey = []byte(insecureStorePublicKey)
 	}
 
-	csc.ZEndpoint = storeEndPoint
-	csc.DEndpoint = strings.Replace(storeEndPoint, ":5555", ":5556", 1)
-	csc.ZestC, err = zest.New(csc.ZEndpoint, csc.DEndpoint, string(serverKey), enableLogging)
+	csc, err := newCoreStoreClient(arbiterClient, string(serverKey), storeEndPoint, enableLogging)
 	if err != nil {
 		fmt.Println("[NewCoreStoreClient] Error zest.New ", err.Error())
 	}
 
+	return csc
+}
+
+// newCoreStoreClient returns the client along with the error from connecting to the store, the
+// client is returned even if the connection failed
+func newCoreStoreClient(arbiterClient *ArbiterClient, serverKey string, storeEndPoint string, enableLogging bool) (*CoreStoreClient, error) {
+
+	csc := &CoreStoreClient{
+		Arbiter:          arbiterClient,
+		enableLogging:    enableLogging,
+		storeClients:     make(map[string]zest.ZestClient),
+		storeClientsLock: &sync.Mutex{},
+
+		subscriptions:     make(map[*subscription]struct{}),
+		subscriptionsLock: &sync.Mutex{},
+		workers:           &sync.WaitGroup{},
+		calls:             &sync.WaitGroup{},
+		closing:           make(chan struct{}),
+		closingLock:       &sync.Mutex{},
+		closed:            make(chan struct{}),
+		closeOnce:         &sync.Once{},
+		inFlight:          new(int64),
+
+		metrics:      &metricsRef{},
+		exporter:     &exporterRef{},
+		cache:        &readCacheRef{lock: &sync.Mutex{}},
+		deleteEvents: newDeleteEvents(),
+		expiring:     newExpiringDataSources(),
+	}
+
+	var err error
+	csc.serverKey = serverKey
+	csc.ZEndpoint = storeEndPoint
+	csc.DEndpoint = strings.Replace(storeEndPoint, ":5555", ":5556", 1)
+	csc.ZestC, err = zest.New(csc.ZEndpoint, csc.DEndpoint, serverKey, enableLogging)
+
+	csc.newStores()
+	csc.FUNC = newFunc(csc)
+	csc.EXPORT = newExport(csc.Arbiter)
+	return csc, err
+}
+
+func (csc *CoreStoreClient) newStores() {
 	csc.KVJSON = newKVStore(csc, ContentTypeJSON)
 	csc.KVText = newKVStore(csc, ContentTypeTEXT)
 	csc.KVBin = newKVStore(csc, ContentTypeBINARY)
@@ -60,14 +148,42 @@ func NewCoreStoreClient(arbiterClient *ArbiterClient, zmqPublicKeyPath string, s
 	csc.TSBlobText = newTSBlobStore(csc, ContentTypeTEXT)
 	csc.TSBlobBin = newTSBlobStore(csc, ContentTypeBINARY)
 	csc.TSJSON = newTSStore(csc, ContentTypeBINARY)
-	csc.FUNC = newFunc(csc)
-	csc.EXPORT = newExport(csc.Arbiter)
-	return csc
 }
 
+// WithSpan returns a client that shares this clients connections and state but starts its spans as
+// children of parent. Use it to link store requests to a span from another part of your app. Function
+// calls made with the returned client send the span context to the function wrapped around the payload,
+// functions registered with this library unwrap it.
+func (csc *CoreStoreClient) WithSpan(parent SpanContext) *CoreStoreClient {
+
+	c := *csc
+	c.parentSpan = parent
+	c.newStores()
+
+	f := *csc.FUNC
+	f.csc = &c
+	c.FUNC = &f
+
+	return &c
+}
+
+// SetSpanExporter sets where spans for store requests and function calls are sent, nil disables tracing.
+// The ArbiterClient has its own SetSpanExporter as it may be shared with other clients.
+func (csc *CoreStoreClient) SetSpanExporter(e SpanExporter) {
+	csc.exporter.set(e)
+}
+
+// GetStoreDataSourceCatalogue returns the hypercat catalogue of the store at href. href can be the store url
+// or the href of any datasource in that store. Connections to stores other than this clients store are
+// created on first use and reused.
 func (csc *CoreStoreClient) GetStoreDataSourceCatalogue(href string) (HypercatRoot, error) {
 
-	target := href + "/cat"
+	storeURL, err := GetStoreURLFromDsHref(href)
+	if err != nil {
+		return HypercatRoot{}, err
+	}
+
+	target := storeURL + "/cat"
 	method := "GET"
 
 	token, err := csc.Arbiter.RequestToken(target, method, "")
@@ -76,18 +192,120 @@ func (csc *CoreStoreClient) GetStoreDataSourceCatalogue(href string) (HypercatRo
 	}
 	//log.Debug("[GetStoreDataSourceCatalogue] got Token: " + string(token))
 
-	hypercatJSON, getErr := csc.ZestC.Get(string(token), "/cat", "JSON")
-	if getErr != nil {
+	zestC, err := csc.storeClient(storeURL)
+	if err != nil {
 		return HypercatRoot{}, err
 	}
+
+	hypercatJSON, getErr := zestC.Get(string(token), "/cat", "JSON")
+	if getErr != nil {
+		csc.Arbiter.InvalidateCache(target, method, "")
+		return HypercatRoot{}, errors.New("Error reading catalogue from " + storeURL + ": " + getErr.Error())
+	}
 	//log.Debug("[GetStoreDataSourceCatalogue] got store cat: " + string(hypercatJSON))
 	cat := HypercatRoot{}
-	json.Unmarshal(hypercatJSON, &cat)
+	err = json.Unmarshal(hypercatJSON, &cat)
+	if err != nil {
+		return HypercatRoot{}, errors.New("Error decoding catalogue from " + storeURL + ": " + err.Error())
+	}
 
 	return cat, nil
 
 }
 
+// GetStoreDataSourceCatalogues reads the catalogues of several stores concurrently. The returned map is keyed
+// by the hrefs passed in. If any store can not be read the catalogues of the other stores are still returned
+// along with an error listing the stores that failed.
+func (csc *CoreStoreClient) GetStoreDataSourceCatalogues(hrefs []string) (map[string]HypercatRoot, error) {
+
+	cats, errs := csc.readStoreCatalogues(hrefs)
+
+	if len(errs) > 0 {
+		failed := []string{}
+		for href, err := range errs {
+			failed = append(failed, href+" ("+err.Error()+")")
+		}
+		sort.Strings(failed)
+		return cats, errors.New("Error reading store catalogues: " + strings.Join(failed, ", "))
+	}
+
+	return cats, nil
+}
+
+// readStoreCatalogues reads the catalogues of several stores concurrently returning the catalogues
+// and the errors keyed by href
+func (csc *CoreStoreClient) readStoreCatalogues(hrefs []string) (map[string]HypercatRoot, map[string]error) {
+
+	type result struct {
+		href string
+		cat  HypercatRoot
+		err  error
+	}
+
+	results := make(chan result, len(hrefs))
+	var wg sync.WaitGroup
+	for _, href := range hrefs {
+		wg.Add(1)
+		go func(href string) {
+			defer wg.Done()
+			cat, err := csc.GetStoreDataSourceCatalogue(href)
+			results <- result{href: href, cat: cat, err: err}
+		}(href)
+	}
+	wg.Wait()
+	close(results)
+
+	cats := make(map[string]HypercatRoot, len(hrefs))
+	errs := make(map[string]error)
+	for r := range results {
+		if r.err != nil {
+			errs[r.href] = r.err
+			continue
+		}
+		cats[r.href] = r.cat
+	}
+
+	return cats, errs
+}
+
+// storeClient returns a zest client for the store at storeURL creating one if needed
+func (csc *CoreStoreClient) storeClient(storeURL string) (zest.ZestClient, error) {
+
+	if storeURL == csc.ZEndpoint {
+		return csc.ZestC, nil
+	}
+
+	csc.storeClientsLock.Lock()
+	defer csc.storeClientsLock.Unlock()
+
+	if csc.isClosed() {
+		return zest.ZestClient{}, errClientClosed
+	}
+
+	if zestC, ok := csc.storeClients[storeURL]; ok {
+		return zestC, nil
+	}
+
+	dealerEndpoint := strings.Replace(storeURL, ":5555", ":5556", 1)
+	zestC, err := zest.New(storeURL, dealerEndpoint, csc.serverKey, csc.enableLogging)
+	if err != nil {
+		return zest.ZestClient{}, errors.New("Error connecting to store " + storeURL + ": " + err.Error())
+	}
+	csc.storeClients[storeURL] = zestC
+
+	return zestC, nil
+}
+
+// dropStoreClients forgets the zest clients of the other stores this client has read catalogues from.
+// The zest client opens a socket per request and has no Close so there is nothing else to release.
+func (csc *CoreStoreClient) dropStoreClients() {
+
+	csc.storeClientsLock.Lock()
+	defer csc.storeClientsLock.Unlock()
+
+	csc.storeClients = make(map[string]zest.ZestClient)
+}
+
 // RegisterDatasource is used by apps and drivers to register datasource in stores they
 // own.
 func (csc *CoreStoreClient) RegisterDatasource(metadata DataSourceMetadata) error {
@@ -99,6 +317,9 @@ func (csc *CoreStoreClient) RegisterDatasource(metadata DataSourceMetadata) erro
 		return err
 	}
 	hypercatJSON, err := csc.dataSourceMetadataToHypercat(metadata, csc.ZEndpoint)
+	if err != nil {
+		return err
+	}
 
 	_, writeErr := csc.ZestC.Post(string(token), path, hypercatJSON, "JSON")
 	if writeErr != nil {
@@ -109,56 +330,28 @@ func (csc *CoreStoreClient) RegisterDatasource(metadata DataSourceMetadata) erro
 	return nil
 }
 
-//dataSourceMetadataToHypercat converts a DataSourceMetadata instance to json for registering a data source
+// dataSourceMetadataToHypercat converts a DataSourceMetadata instance to json for registering a data source
 func (csc *CoreStoreClient) dataSourceMetadataToHypercat(metadata DataSourceMetadata, endPoint string) ([]byte, error) {
 
-	if metadata.Description == "" ||
-		metadata.ContentType == "" ||
-		metadata.Vendor == "" ||
-		metadata.DataSourceType == "" ||
-		metadata.DataSourceID == "" ||
-		metadata.StoreType == "" {
-
-		return nil, errors.New("Missing required metadata")
+	cat, err := DataSourceMetadataToHypercat(metadata, endPoint)
+	if err != nil {
+		return nil, err
 	}
 
-	cat := HypercatItem{}
-	cat.ItemMetadata = append(cat.ItemMetadata, RelValPair{Rel: "urn:X-hypercat:rels:hasDescription:en", Val: metadata.Description})
-	cat.ItemMetadata = append(cat.ItemMetadata, RelValPair{Rel: "urn:X-hypercat:rels:isContentType", Val: string(metadata.ContentType)})
-	cat.ItemMetadata = append(cat.ItemMetadata, RelValPair{Rel: "urn:X-databox:rels:hasVendor", Val: metadata.Vendor})
-	cat.ItemMetadata = append(cat.ItemMetadata, RelValPair{Rel: "urn:X-databox:rels:hasType", Val: metadata.DataSourceType})
-	cat.ItemMetadata = append(cat.ItemMetadata, RelValPair{Rel: "urn:X-databox:rels:hasDatasourceid", Val: metadata.DataSourceID})
-	cat.ItemMetadata = append(cat.ItemMetadata, RelValPair{Rel: "urn:X-databox:rels:hasStoreType", Val: string(metadata.StoreType)})
-
-	if metadata.IsActuator {
-		cat.ItemMetadata = append(cat.ItemMetadata, RelValPairBool{Rel: "urn:X-databox:rels:isActuator", Val: true})
-	}
+	return json.Marshal(cat)
 
-	if metadata.IsFunc {
-		cat.ItemMetadata = append(cat.ItemMetadata, RelValPairBool{Rel: "urn:X-databox:rels:isFunc", Val: true})
-	}
+}
 
-	if metadata.Location != "" {
-		cat.ItemMetadata = append(cat.ItemMetadata, RelValPair{Rel: "urn:X-databox:rels:hasLocation", Val: metadata.Location})
-	}
+func (csc *CoreStoreClient) delete(path string, contentType StoreContentType) (err error) {
 
-	if metadata.Unit != "" {
-		cat.ItemMetadata = append(cat.ItemMetadata, RelValPair{Rel: "urn:X-databox:rels:hasUnit", Val: metadata.Unit})
-	}
+	req := csc.startRequest("delete", path)
+	defer req.finish(&err)
 
-	if metadata.IsFunc {
-		cat.Href = endPoint + "/request/" + metadata.DataSourceID
-	} else {
-		cat.Href = endPoint + "/" + string(metadata.StoreType) + "/" + metadata.DataSourceID
+	if csc.isClosed() {
+		return errClientClosed
 	}
 
-	return json.Marshal(cat)
-
-}
-
-func (csc *CoreStoreClient) delete(path string, contentType StoreContentType) error {
-
-	token, err := csc.Arbiter.RequestToken(csc.ZEndpoint+path, "DELETE", "")
+	token, err := csc.Arbiter.requestToken(csc.ZEndpoint+path, "DELETE", "", req.span.context())
 	if err != nil {
 		return errors.New("Error getting Arbiter Token: " + err.Error())
 	}
@@ -172,9 +365,16 @@ func (csc *CoreStoreClient) delete(path string, contentType StoreContentType) er
 	return nil
 }
 
-func (csc *CoreStoreClient) read(path string, contentType StoreContentType) ([]byte, error) {
+func (csc *CoreStoreClient) read(path string, contentType StoreContentType) (resp []byte, err error) {
+
+	req := csc.startRequest("read", path)
+	defer req.finish(&err)
+
+	if csc.isClosed() {
+		return []byte(""), errClientClosed
+	}
 
-	token, err := csc.Arbiter.RequestToken(csc.ZEndpoint+path, "GET", "")
+	token, err := csc.Arbiter.requestToken(csc.ZEndpoint+path, "GET", "", req.span.context())
 	if err != nil {
 		return []byte(""), errors.New("Error getting Arbiter Token: " + err.Error())
 
@@ -191,66 +391,342 @@ func (csc *CoreStoreClient) read(path string, contentType StoreContentType) ([]b
 
 func (csc *CoreStoreClient) observe(path string, contentType StoreContentType, observeMode zest.ObserveMode) (<-chan ObserveResponse, error) {
 
-	token, err := csc.Arbiter.RequestToken(csc.ZEndpoint+path, "GET", "")
+	objectChan, _, err := csc.observeWithCancel(path, contentType, observeMode)
+	return objectChan, err
+}
+
+// observeWithCancel is observe that also returns a function to stop observing
+func (csc *CoreStoreClient) observeWithCancel(path string, contentType StoreContentType, observeMode zest.ObserveMode) (<-chan ObserveResponse, func(), error) {
+	return csc.observeFiltered(path, contentType, observeMode, nil)
+}
+
+// observeFiltered is observeWithCancel that only forwards responses for which keep returns true, a nil keep forwards all
+func (csc *CoreStoreClient) observeFiltered(path string, contentType StoreContentType, observeMode zest.ObserveMode, keep func(ObserveResponse) bool) (_ <-chan ObserveResponse, _ func(), err error) {
+
+	req := csc.startRequest("observe", path)
+	defer req.finish(&err)
+
+	if csc.isClosing() {
+		return nil, nil, errClientClosed
+	}
+
+	token, err := csc.Arbiter.requestToken(csc.ZEndpoint+path, "GET", "", req.span.context())
 	if err != nil {
-		return nil, errors.New("Error getting Arbiter Token: " + err.Error())
+		return nil, nil, errors.New("Error getting Arbiter Token: " + err.Error())
 
 	}
 
-	payloadChan, _, getErr := csc.ZestC.Observe(string(token), path, string(contentType), observeMode, 0)
+	payloadChan, zestDone, getErr := csc.ZestC.Observe(string(token), path, string(contentType), observeMode, 0)
 	if getErr != nil {
 		csc.Arbiter.InvalidateCache(csc.ZEndpoint+path, "GET", "")
-		return nil, errors.New("Error observing: " + getErr.Error())
+		return nil, nil, errors.New("Error observing: " + getErr.Error())
 	}
 
+	sub, err := csc.addSubscription(zestDone)
+	if err != nil {
+		return nil, nil, err
+	}
 	objectChan := make(chan ObserveResponse)
+	metrics := csc.metrics.get()
 
 	go func() {
-		for data := range payloadChan {
-			if observeMode == zest.ObserveModeNotification {
-				objectChan <- csc.parseRawObserveResponseNotification(data)
-			} else {
-				objectChan <- csc.parseRawObserveResponseData(data)
+		defer csc.workers.Done()
+		defer csc.removeSubscription(sub)
+		//if we get here then payloadChan has been closed or we have been stopped so close objectChan
+		defer close(objectChan)
+		//a message read before the stop may still be waiting to be sent on payloadChan
+		defer drainPayloads(payloadChan)
+
+		for {
+			select {
+			case data, ok := <-payloadChan:
+				if !ok {
+					return
+				}
+				metrics.AddCounter(MetricStoreObserveMessages, MetricLabels{"store": csc.ZEndpoint}, 1)
+				var resp ObserveResponse
+				if observeMode == zest.ObserveModeNotification {
+					resp = csc.parseRawObserveResponseNotification(data)
+				} else {
+					resp = csc.parseRawObserveResponseData(data)
+				}
+				if keep != nil && !keep(resp) {
+					continue
+				}
+				select {
+				case objectChan <- resp:
+				case <-sub.stop:
+					return
+				}
+			case <-sub.stop:
+				return
 			}
 		}
-
-		//if we get here then payloadChan has been closed so close objectChan
-		close(objectChan)
 	}()
 
-	return objectChan, err
+	return objectChan, sub.cancel, nil
+}
+
+// drainPayloads discards the messages left on payloadChan after an observe is stopped so the zest
+// reader is not left blocked sending one. It gives up once no message arrives for observeDrainTimeout.
+func drainPayloads(payloadChan <-chan []byte) {
+
+	go func() {
+		timer := time.NewTimer(observeDrainTimeout)
+		defer timer.Stop()
+		for {
+			select {
+			case _, ok := <-payloadChan:
+				if !ok {
+					return
+				}
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(observeDrainTimeout)
+			case <-timer.C:
+				return
+			}
+		}
+	}()
 }
 
-func (csc *CoreStoreClient) notify(path string, contentType StoreContentType) (<-chan NotifyResponse, chan struct{}, error) {
+// notify waits for a single response on path. Call the returned function once the response
+// has been received or is no longer needed.
+func (csc *CoreStoreClient) notify(path string, contentType StoreContentType) (<-chan NotifyResponse, func(), error) {
 
-	token, err := csc.Arbiter.RequestToken(csc.ZEndpoint+path, "GET", "")
+	if csc.isClosing() {
+		return nil, nil, errClientClosed
+	}
+
+	token, err := csc.Arbiter.requestToken(csc.ZEndpoint+path, "GET", "", csc.parentSpan)
 	if err != nil {
 		return nil, nil, errors.New("Error getting Arbiter Token: " + err.Error())
 	}
 
-	payloadChan, doneChan, getErr := csc.ZestC.Notify(string(token), path, string(contentType), 0)
+	payloadChan, zestDone, getErr := csc.ZestC.Notify(string(token), path, string(contentType), 0)
 	if getErr != nil {
 		csc.Arbiter.InvalidateCache(csc.ZEndpoint+path, "GET", "")
 		return nil, nil, errors.New("Error starting notify: " + getErr.Error())
 	}
 
-	objectChan := make(chan NotifyResponse)
+	sub, err := csc.addSubscription(zestDone)
+	if err != nil {
+		return nil, nil, err
+	}
+	objectChan := make(chan NotifyResponse, 1)
 
 	go func() {
-		for data := range payloadChan {
-			objectChan <- csc.parseRawNotifyResponse(data)
-			break
+		defer csc.workers.Done()
+		defer csc.removeSubscription(sub)
+		//if we get here then we have a response, payloadChan has been closed or we have been stopped so close objectChan
+		defer close(objectChan)
+		defer drainPayloads(payloadChan)
+
+		select {
+		case data, ok := <-payloadChan:
+			if ok {
+				objectChan <- csc.parseRawNotifyResponse(data)
+			}
+		case <-sub.stop:
 		}
-		//if we get here then payloadChan has been closed so close objectChan
-		close(objectChan)
 	}()
 
-	return objectChan, doneChan, err
+	return objectChan, sub.cancel, nil
 }
 
-func (csc *CoreStoreClient) write(path string, payload []byte, contentType StoreContentType) error {
+// SetMetrics sets where the client records metrics for store requests and function calls, nil disables metrics.
+// The ArbiterClient has its own SetMetrics as it may be shared with other clients.
+func (csc *CoreStoreClient) SetMetrics(m Metrics) {
+	csc.metrics.set(m)
+}
 
-	token, err := csc.Arbiter.RequestToken(csc.ZEndpoint+path, "POST", "")
+// storeRequest records the metrics and span of a single store request
+type storeRequest struct {
+	csc       *CoreStoreClient
+	operation string
+	start     time.Time
+	span      *activeSpan
+}
+
+func (csc *CoreStoreClient) startRequest(operation string, path string) *storeRequest {
+	atomic.AddInt64(csc.inFlight, 1)
+	return &storeRequest{
+		csc:       csc,
+		operation: operation,
+		start:     time.Now(),
+		span:      startSpan(csc.exporter.get(), "store."+operation, csc.parentSpan, map[string]string{"store": csc.ZEndpoint, "path": path}),
+	}
+}
+
+// finish ends the request, it failed if *err is not nil
+func (r *storeRequest) finish(err *error) {
+
+	atomic.AddInt64(r.csc.inFlight, -1)
+
+	metrics := r.csc.metrics.get()
+	metrics.AddCounter(MetricStoreRequests, MetricLabels{"operation": r.operation, "store": r.csc.ZEndpoint, "status": metricStatus(*err)}, 1)
+	metrics.ObserveDuration(MetricStoreRequestDuration, MetricLabels{"operation": r.operation, "store": r.csc.ZEndpoint}, time.Since(r.start))
+
+	r.span.end(*err)
+}
+
+func (csc *CoreStoreClient) addSubscription(zestDone chan struct{}) (*subscription, error) {
+
+	sub := &subscription{
+		zestDone: zestDone,
+		stop:     make(chan struct{}),
+		once:     &sync.Once{},
+	}
+
+	err := csc.track(csc.workers)
+	if err != nil {
+		sub.cancel()
+		return nil, err
+	}
+
+	csc.subscriptionsLock.Lock()
+	csc.subscriptions[sub] = struct{}{}
+	if csc.isClosing() {
+		//Shutdown may already have cancelled the other subscriptions
+		sub.cancel()
+	}
+	csc.subscriptionsLock.Unlock()
+
+	return sub, nil
+}
+
+// track adds one to wg unless Shutdown has started, Shutdown only waits for wg once no more can be added
+func (csc *CoreStoreClient) track(wg *sync.WaitGroup) error {
+
+	csc.closingLock.Lock()
+	defer csc.closingLock.Unlock()
+
+	if csc.isClosing() {
+		return errClientClosed
+	}
+	wg.Add(1)
+
+	return nil
+}
+
+func (csc *CoreStoreClient) removeSubscription(sub *subscription) {
+
+	csc.subscriptionsLock.Lock()
+	delete(csc.subscriptions, sub)
+	csc.subscriptionsLock.Unlock()
+}
+
+// inUse is true while the client has store requests in progress or running observes, notifies or function calls
+func (csc *CoreStoreClient) inUse() bool {
+
+	if atomic.LoadInt64(csc.inFlight) > 0 {
+		return true
+	}
+
+	csc.subscriptionsLock.Lock()
+	defer csc.subscriptionsLock.Unlock()
+
+	return len(csc.subscriptions) > 0
+}
+
+func (csc *CoreStoreClient) isClosing() bool {
+	select {
+	case <-csc.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+func (csc *CoreStoreClient) isClosed() bool {
+	select {
+	case <-csc.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close shuts the client down waiting up to DefaultCloseTimeout for function calls to finish, see Shutdown.
+func (csc *CoreStoreClient) Close() error {
+	return csc.Shutdown(DefaultCloseTimeout)
+}
+
+// Shutdown stops the client. New observes and function calls are refused straight away, registered
+// functions stop receiving requests and the client waits up to timeout for requests being handled
+// and function calls in progress to finish. All observes are then stopped and their channels closed.
+// The zest clients are then closed and after Shutdown returns all store requests fail. The ArbiterClient
+// is not closed as it may be shared with other clients. An error is returned if timeout was reached
+// before everything finished.
+func (csc *CoreStoreClient) Shutdown(timeout time.Duration) error {
+
+	var err error
+
+	csc.closeOnce.Do(func() {
+		deadline := time.Now().Add(timeout)
+		csc.closingLock.Lock()
+		close(csc.closing)
+		csc.closingLock.Unlock()
+
+		//stop receiving new function requests
+		csc.FUNC.stopListening()
+
+		if !waitTimeout(csc.calls, time.Until(deadline)) {
+			err = errors.New("Timeout waiting for function calls to finish")
+		}
+
+		//the cached values can not be kept fresh once observes stop
+		csc.cache.set(nil).close()
+
+		csc.subscriptionsLock.Lock()
+		for sub := range csc.subscriptions {
+			sub.cancel()
+		}
+		csc.subscriptionsLock.Unlock()
+
+		if !waitTimeout(csc.workers, time.Until(deadline)) && err == nil {
+			err = errors.New("Timeout waiting for observers to stop")
+		}
+
+		csc.dropStoreClients()
+		close(csc.closed)
+	})
+
+	return err
+}
+
+// waitTimeout waits for wg returning false if timeout is reached first
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	if timeout <= 0 {
+		timeout = time.Millisecond
+	}
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (csc *CoreStoreClient) write(path string, payload []byte, contentType StoreContentType) (err error) {
+
+	req := csc.startRequest("write", path)
+	defer req.finish(&err)
+
+	if csc.isClosed() {
+		return errClientClosed
+	}
+
+	token, err := csc.Arbiter.requestToken(csc.ZEndpoint+path, "POST", "", req.span.context())
 	if err != nil {
 		return errors.New("Error getting Arbiter Token: " + err.Error())
 	}
@@ -0,0 +1,143 @@
+package libDatabox
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCoreStoreClientShutdown(t *testing.T) {
+
+	csc := NewCoreStoreClient(Arbiter, "", StoreURL, false)
+
+	err := csc.Shutdown(time.Second)
+	if err != nil {
+		t.Errorf("Shutdown failed expected err to be nil got %s", err.Error())
+	}
+
+	err = csc.Close()
+	if err != nil {
+		t.Errorf("Close after Shutdown expected err to be nil got %s", err.Error())
+	}
+
+	_, err = csc.KVJSON.Read(dsID, "key")
+	if err != errClientClosed {
+		t.Errorf("Read after Shutdown expected %v got %v", errClientClosed, err)
+	}
+
+	_, err = csc.KVJSON.Observe(dsID)
+	if err == nil {
+		t.Error("Observe after Shutdown expected an error")
+	}
+
+	_, err = csc.FUNC.Call("test", []byte("{}"), ContentTypeJSON)
+	if err == nil {
+		t.Error("Call after Shutdown expected an error")
+	}
+}
+
+func TestCoreStoreClientShutdownTimeout(t *testing.T) {
+
+	csc := NewCoreStoreClient(Arbiter, "", StoreURL, false)
+
+	//a function call that never finishes
+	csc.calls.Add(1)
+	defer csc.calls.Done()
+
+	start := time.Now()
+	err := csc.Shutdown(50 * time.Millisecond)
+	if err == nil {
+		t.Error("Shutdown expected a timeout error")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Shutdown took %s expected it to stop at the timeout", time.Since(start))
+	}
+
+	if !csc.isClosed() {
+		t.Error("Shutdown expected client to be closed after a timeout")
+	}
+}
+
+func TestCoreStoreClientShutdownDuringCalls(t *testing.T) {
+
+	csc := NewCoreStoreClient(Arbiter, "", StoreURL, false)
+
+	started := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		close(started)
+		for i := 0; i < 100; i++ {
+			resChan, err := csc.FUNC.Call("test", []byte("{}"), ContentTypeJSON)
+			if err != nil {
+				return
+			}
+			<-resChan
+		}
+	}()
+
+	<-started
+	csc.Shutdown(time.Second)
+	<-done
+
+	if err := csc.track(csc.calls); err == nil {
+		t.Error("track after Shutdown expected an error")
+	}
+}
+
+func TestWaitTimeout(t *testing.T) {
+
+	wg := &sync.WaitGroup{}
+	if !waitTimeout(wg, 10*time.Millisecond) {
+		t.Error("waitTimeout expected true for an empty WaitGroup")
+	}
+
+	wg.Add(1)
+	if waitTimeout(wg, 10*time.Millisecond) {
+		t.Error("waitTimeout expected false before Done")
+	}
+
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		wg.Done()
+	}()
+	if !waitTimeout(wg, time.Second) {
+		t.Error("waitTimeout expected true after Done")
+	}
+}
+
+func TestDrainPayloads(t *testing.T) {
+
+	payloadChan := make(chan []byte)
+	drainPayloads(payloadChan)
+
+	select {
+	case payloadChan <- []byte("late"):
+	case <-time.After(time.Second):
+		t.Fatal("drainPayloads expected a message sent after the stop to be read")
+	}
+	close(payloadChan)
+}
+
+func TestArbiterClientClose(t *testing.T) {
+
+	arb, err := newArbiterClient("secret", "", ArbiterURL)
+	if err != nil {
+		t.Fatalf("newArbiterClient failed expected err to be nil got %s", err.Error())
+	}
+
+	arb.tokenCache["cached"] = []byte("token")
+
+	err = arb.Close()
+	if err != nil {
+		t.Errorf("Close failed expected err to be nil got %s", err.Error())
+	}
+	if len(arb.tokenCache) != 0 {
+		t.Error("Close expected token cache to be empty")
+	}
+
+	_, err = arb.RequestToken(StoreURL+"/kv/"+dsID, "GET", "")
+	if err != errArbiterClientClosed {
+		t.Errorf("RequestToken after Close expected %v got %v", errArbiterClientClosed, err)
+	}
+}
@@ -3,6 +3,8 @@ package libDatabox
 import (
 	"encoding/json"
 	"errors"
+	"strings"
+	"time"
 
 	zest "github.com/me-box/goZestClient"
 )
@@ -20,45 +22,140 @@ func newKVStore(csc *CoreStoreClient, contentType StoreContentType) *KVStore {
 }
 
 // Write Write will add data to the key value data store.
+// In datasources tracked with TrackExpiry the expiry record of the key is removed.
 func (kvj *KVStore) Write(dataSourceID string, key string, payload []byte) error {
 
+	err := kvj.write(dataSourceID, key, payload)
+	if err != nil || strings.HasPrefix(key, internalKeyPrefix) || !kvj.csc.expiring.has(dataSourceID) {
+		return err
+	}
+
+	err = kvj.delete(dataSourceID, ExpiryKeyPrefix+key)
+	if err != nil {
+		return errors.New("Error removing expiry of " + key + ": " + err.Error())
+	}
+
+	return nil
+}
+
+func (kvj *KVStore) write(dataSourceID string, key string, payload []byte) error {
+
 	path := "/kv/" + dataSourceID + "/" + key
 
-	return kvj.csc.write(path, payload, kvj.contentType)
+	err := kvj.csc.write(path, payload, kvj.contentType)
+	if err == nil {
+		kvj.csc.cache.get().invalidate(kvj.cacheWatch(dataSourceID), path)
+	}
+
+	return err
 
 }
 
 // Read will read the vale store at under tha key
 // return data is a  object of the format {"timestamp":213123123,"data":[data-written-by-driver]}
+// In datasources tracked with TrackExpiry the expiry record of the key is also read and values that
+// have expired are returned empty as if the key did not exist. If the read cache is enabled both are
+// served from it when possible.
 func (kvj *KVStore) Read(dataSourceID string, key string) ([]byte, error) {
 
+	raw, err := kvj.cachedRead(dataSourceID, key)
+	if err != nil || !kvj.csc.expiring.has(dataSourceID) {
+		return raw, err
+	}
+
+	return kvj.checkExpiry(dataSourceID, key, raw, time.Now()), nil
+
+}
+
+func (kvj *KVStore) cachedRead(dataSourceID string, key string) ([]byte, error) {
+
+	path := "/kv/" + dataSourceID + "/" + key
+
+	return kvj.csc.cache.get().read(kvj.cacheWatch(dataSourceID), path, func() ([]byte, error) {
+		return kvj.readRaw(dataSourceID, key)
+	})
+
+}
+
+func (kvj *KVStore) readRaw(dataSourceID string, key string) ([]byte, error) {
+
 	path := "/kv/" + dataSourceID + "/" + key
 
 	return kvj.csc.read(path, kvj.contentType)
 
 }
 
-// Delete deletes data under the key.
+// Delete deletes data under the key along with its expiry and version records. If they or the delete
+// event a Changes feed needs can not be removed or written the key is still deleted and the error is returned.
 func (kvj *KVStore) Delete(dataSourceID string, key string) error {
 
+	err := kvj.delete(dataSourceID, key)
+	if err != nil || strings.HasPrefix(key, internalKeyPrefix) {
+		return err
+	}
+
+	failed := []string{}
+	for _, record := range []string{ExpiryKeyPrefix + key, VersionKeyPrefix + key} {
+		err := kvj.delete(dataSourceID, record)
+		if err != nil {
+			failed = append(failed, record+" ("+err.Error()+")")
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New("Error deleting records of " + key + ": " + strings.Join(failed, ", "))
+	}
+
+	return kvj.writeDeleteEvent(dataSourceID, kvDeleteEvent{Keys: []string{key}})
+}
+
+func (kvj *KVStore) delete(dataSourceID string, key string) error {
+
 	path := "/kv/" + dataSourceID + "/" + key
 
-	return kvj.csc.delete(path, kvj.contentType)
+	err := kvj.csc.delete(path, kvj.contentType)
+	if err == nil {
+		kvj.csc.cache.get().invalidate(kvj.cacheWatch(dataSourceID), path)
+	}
+
+	return err
 
 }
 
-// DeleteAll deletes all keys and data from the datasource.
+// DeleteAll deletes all keys and data from the datasource. The delete event for Changes feeds is
+// written first so the delete removes it too, if it can not be written the keys are still deleted
+// and the error is returned.
 func (kvj *KVStore) DeleteAll(dataSourceID string) error {
 
 	path := "/kv/" + dataSourceID
 
-	return kvj.csc.delete(path, kvj.contentType)
+	eventErr := kvj.writeDeleteEvent(dataSourceID, kvDeleteEvent{All: true})
+
+	err := kvj.csc.delete(path, kvj.contentType)
+	if err != nil {
+		return err
+	}
+	kvj.csc.cache.get().invalidate(kvj.cacheWatch(dataSourceID), "")
+
+	return eventErr
 
 }
 
-// ListKeys returns an array of key registed under the dataSourceID
+// ListKeys returns an array of key registed under the dataSourceID. Keys written with WriteWithTTL
+// that have expired are not included.
 func (kvj *KVStore) ListKeys(dataSourceID string) ([]string, error) {
 
+	keys, err := kvj.listAllKeys(dataSourceID)
+	if err != nil {
+		return keys, err
+	}
+
+	return kvj.liveKeys(dataSourceID, keys)
+
+}
+
+// listAllKeys returns every key in the datasource including expired keys and expiry records
+func (kvj *KVStore) listAllKeys(dataSourceID string) ([]string, error) {
+
 	path := "/kv/" + dataSourceID + "/keys"
 
 	data, err := kvj.csc.read(path, kvj.contentType)
@@ -76,18 +173,30 @@ func (kvj *KVStore) ListKeys(dataSourceID string) ([]string, error) {
 
 }
 
+// Observe returns a channel receiving every write to the datasource. Writes to the keys used
+// internally by this library are not included.
 func (kvj *KVStore) Observe(dataSourceID string) (<-chan ObserveResponse, error) {
 
 	path := "/kv/" + dataSourceID + "/*"
 
-	return kvj.csc.observe(path, kvj.contentType, zest.ObserveModeData)
+	observeChan, _, err := kvj.csc.observeFiltered(path, kvj.contentType, zest.ObserveModeData, isUserKey)
+
+	return observeChan, err
 
 }
 
+// ObserveKey returns a channel receiving every write to key
 func (kvj *KVStore) ObserveKey(dataSourceID string, key string) (<-chan ObserveResponse, error) {
 
 	path := "/kv/" + dataSourceID + "/" + key
 
-	return kvj.csc.observe(path, kvj.contentType, zest.ObserveModeData)
+	observeChan, _, err := kvj.csc.observeFiltered(path, kvj.contentType, zest.ObserveModeData, isUserKey)
+
+	return observeChan, err
+
+}
 
+// isUserKey is false for observe responses about keys used internally by this library
+func isUserKey(resp ObserveResponse) bool {
+	return !strings.HasPrefix(resp.Key, internalKeyPrefix)
 }
@@ -0,0 +1,316 @@
+package libDatabox
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	zest "github.com/me-box/goZestClient"
+)
+
+// BatchJournalKeyPrefix names the journal written while a batch is applied, a journal left
+// behind by a client that stopped part way through is rolled back by RecoverBatches.
+const BatchJournalKeyPrefix = internalKeyPrefix + "batch."
+
+// BatchEventKey is written with the whole batch after each batch is committed, use ObserveBatches to receive them
+const BatchEventKey = internalKeyPrefix + "batch"
+
+// KVBatchOpType is the kind of change made by a KVBatchOp
+type KVBatchOpType string
+
+const (
+	KVBatchWrite  KVBatchOpType = "write"
+	KVBatchDelete KVBatchOpType = "delete"
+)
+
+// KVBatchOp is a single write or delete in a batch
+type KVBatchOp struct {
+	Type  KVBatchOpType `json:"type"`
+	Key   string        `json:"key"`
+	Value []byte        `json:"value,omitempty"`
+}
+
+// KVBatchEvent is received from ObserveBatches for each committed batch
+type KVBatchEvent struct {
+	ID           string      `json:"id"`
+	DataSourceID string      `json:"datasourceId"`
+	TimestampMS  int64       `json:"timestamp"`
+	Ops          []KVBatchOp `json:"ops"`
+}
+
+// KVBatch stages writes and deletes to a datasource and applies them together with Commit
+type KVBatch struct {
+	kv           *KVStore
+	dataSourceID string
+	ops          []KVBatchOp
+}
+
+// kvBatchJournal records a batch and the values it replaces so it can be rolled back
+type kvBatchJournal struct {
+	ID       string            `json:"id"`
+	Ops      []KVBatchOp       `json:"ops"`
+	Previous []kvBatchPrevious `json:"previous"`
+}
+
+type kvBatchPrevious struct {
+	Key     string `json:"key"`
+	Value   []byte `json:"value,omitempty"`
+	Existed bool   `json:"existed"`
+}
+
+// Batch returns an empty batch for dataSourceID
+func (kvj *KVStore) Batch(dataSourceID string) *KVBatch {
+	return &KVBatch{
+		kv:           kvj,
+		dataSourceID: dataSourceID,
+		ops:          []KVBatchOp{},
+	}
+}
+
+// Write stages a write of payload to key
+func (b *KVBatch) Write(key string, payload []byte) *KVBatch {
+	b.ops = append(b.ops, KVBatchOp{Type: KVBatchWrite, Key: key, Value: payload})
+	return b
+}
+
+// Delete stages deleting key
+func (b *KVBatch) Delete(key string) *KVBatch {
+	b.ops = append(b.ops, KVBatchOp{Type: KVBatchDelete, Key: key})
+	return b
+}
+
+// Ops returns the staged operations in the order they will be applied
+func (b *KVBatch) Ops() []KVBatchOp {
+	return append([]KVBatchOp{}, b.ops...)
+}
+
+// Commit applies the staged operations in order. The current values of the keys and the batch are
+// first written to a journal key, if any operation fails the keys are restored from the journal and
+// the error is returned. If the rollback also fails the journal is kept so RecoverBatches can retry it.
+// Once all operations succeed a KVBatchEvent is written to BatchEventKey.
+//
+// Other clients can see the keys part way through a batch, the all or nothing guarantee is about the
+// state left once Commit returns.
+func (b *KVBatch) Commit() error {
+
+	if len(b.ops) == 0 {
+		return nil
+	}
+	for _, op := range b.ops {
+		if op.Key == "" || strings.HasPrefix(op.Key, internalKeyPrefix) {
+			return errors.New("Invalid key '" + op.Key + "' in batch")
+		}
+		if op.Type == KVBatchWrite && b.kv.contentType == ContentTypeJSON && !json.Valid(op.Value) {
+			return errors.New("Invalid json for key " + op.Key + " in batch")
+		}
+	}
+
+	journal, err := b.journal()
+	if err != nil {
+		return err
+	}
+
+	journalKey := BatchJournalKeyPrefix + journal.ID
+	err = b.kv.writeJSON(b.dataSourceID, journalKey, journal)
+	if err != nil {
+		return errors.New("Error writing batch journal: " + err.Error())
+	}
+
+	for _, op := range b.ops {
+		err = b.kv.applyBatchOp(b.dataSourceID, op)
+		if err != nil {
+			applyErr := errors.New("Error applying batch " + journal.ID + " to " + op.Key + ": " + err.Error())
+			rollbackErr := b.kv.rollbackBatch(b.dataSourceID, journal)
+			if rollbackErr != nil {
+				return errors.New(applyErr.Error() + "; rollback failed, journal kept at " + journalKey + ": " + rollbackErr.Error())
+			}
+			return applyErr
+		}
+	}
+
+	event := KVBatchEvent{
+		ID:           journal.ID,
+		DataSourceID: b.dataSourceID,
+		TimestampMS:  time.Now().UnixNano() / int64(time.Millisecond),
+		Ops:          b.ops,
+	}
+	err = b.kv.writeJSON(b.dataSourceID, BatchEventKey, event)
+	if err != nil {
+		Warn("[KVBatch] batch " + journal.ID + " committed but the event was not written: " + err.Error())
+	}
+
+	err = b.kv.Delete(b.dataSourceID, journalKey)
+	if err != nil {
+		Warn("[KVBatch] batch " + journal.ID + " committed but the journal was not deleted: " + err.Error())
+	}
+
+	return nil
+}
+
+// journal reads the current values of the keys in the batch
+func (b *KVBatch) journal() (kvBatchJournal, error) {
+
+	keys := []string{}
+	seen := map[string]bool{}
+	for _, op := range b.ops {
+		if !seen[op.Key] {
+			seen[op.Key] = true
+			keys = append(keys, op.Key)
+		}
+	}
+
+	pairs, failed := readConcurrently(keys, DefaultKVReadConcurrency, func(key string) ([]byte, error) {
+		return b.kv.readRaw(b.dataSourceID, key)
+	})
+	if len(failed) > 0 {
+		return kvBatchJournal{}, errors.New("Error reading keys for batch: " + strings.Join(failed, ", "))
+	}
+
+	journal := kvBatchJournal{
+		ID:       uuid.New().String(),
+		Ops:      b.ops,
+		Previous: make([]kvBatchPrevious, len(pairs)),
+	}
+	for i, pair := range pairs {
+		journal.Previous[i] = kvBatchPrevious{Key: pair.Key, Value: pair.Value, Existed: len(pair.Value) > 0}
+	}
+
+	return journal, nil
+}
+
+func (kvj *KVStore) applyBatchOp(dataSourceID string, op KVBatchOp) error {
+
+	switch op.Type {
+	case KVBatchWrite:
+		return kvj.Write(dataSourceID, op.Key, op.Value)
+	case KVBatchDelete:
+		return kvj.Delete(dataSourceID, op.Key)
+	}
+
+	return errors.New("Unknown batch operation " + string(op.Type))
+}
+
+// rollbackOps returns the operations that restore the previous values in a journal
+func rollbackOps(journal kvBatchJournal) []KVBatchOp {
+
+	ops := make([]KVBatchOp, 0, len(journal.Previous))
+	for i := len(journal.Previous) - 1; i >= 0; i-- {
+		prev := journal.Previous[i]
+		if prev.Existed {
+			ops = append(ops, KVBatchOp{Type: KVBatchWrite, Key: prev.Key, Value: prev.Value})
+		} else {
+			ops = append(ops, KVBatchOp{Type: KVBatchDelete, Key: prev.Key})
+		}
+	}
+
+	return ops
+}
+
+// rollbackBatch restores the keys in journal and deletes the journal, the journal is kept if any key can not be restored
+func (kvj *KVStore) rollbackBatch(dataSourceID string, journal kvBatchJournal) error {
+
+	failed := []string{}
+	for _, op := range rollbackOps(journal) {
+		err := kvj.applyBatchOp(dataSourceID, op)
+		if err != nil {
+			failed = append(failed, op.Key+" ("+err.Error()+")")
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New("Error restoring " + strings.Join(failed, ", "))
+	}
+
+	return kvj.Delete(dataSourceID, BatchJournalKeyPrefix+journal.ID)
+}
+
+// RecoverBatches rolls back batches left part way through by clients that stopped during Commit and
+// returns how many were rolled back. Only call it when no other client is committing a batch to the
+// datasource, for example when a driver starts.
+func (kvj *KVStore) RecoverBatches(dataSourceID string) (int, error) {
+
+	keys, err := kvj.listAllKeys(dataSourceID)
+	if err != nil {
+		return 0, err
+	}
+
+	recovered := 0
+	failed := []string{}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, BatchJournalKeyPrefix) {
+			continue
+		}
+		raw, err := kvj.readRaw(dataSourceID, key)
+		if err != nil {
+			failed = append(failed, key+" ("+err.Error()+")")
+			continue
+		}
+		journal := kvBatchJournal{}
+		err = json.Unmarshal(raw, &journal)
+		if err != nil {
+			failed = append(failed, key+" ("+err.Error()+")")
+			continue
+		}
+		err = kvj.rollbackBatch(dataSourceID, journal)
+		if err != nil {
+			failed = append(failed, key+" ("+err.Error()+")")
+			continue
+		}
+		recovered++
+	}
+
+	if len(failed) > 0 {
+		return recovered, errors.New("Error recovering batches in " + dataSourceID + ": " + strings.Join(failed, ", "))
+	}
+
+	return recovered, nil
+}
+
+// ObserveBatches returns a KVBatchEvent for each batch committed to dataSourceID
+func (kvj *KVStore) ObserveBatches(dataSourceID string) (<-chan KVBatchEvent, error) {
+
+	path := "/kv/" + dataSourceID + "/" + BatchEventKey
+
+	//ObserveKey hides internal keys so observe the event key directly
+	observeChan, _, err := kvj.csc.observeFiltered(path, kvj.contentType, zest.ObserveModeData, isBatchEvent)
+	if err != nil {
+		return nil, err
+	}
+
+	return batchEvents(observeChan), nil
+}
+
+func isBatchEvent(resp ObserveResponse) bool {
+	return resp.Key == BatchEventKey
+}
+
+// batchEvents decodes the batch events received on observeChan
+func batchEvents(observeChan <-chan ObserveResponse) <-chan KVBatchEvent {
+
+	eventChan := make(chan KVBatchEvent)
+	go func() {
+		defer close(eventChan)
+		for resp := range observeChan {
+			event := KVBatchEvent{}
+			err := json.Unmarshal(resp.Data, &event)
+			if err != nil {
+				Warn("[ObserveBatches] Error decoding batch event: " + err.Error())
+				continue
+			}
+			eventChan <- event
+		}
+	}()
+
+	return eventChan
+}
+
+func (kvj *KVStore) writeJSON(dataSourceID string, key string, v interface{}) error {
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return kvj.Write(dataSourceID, key, data)
+}
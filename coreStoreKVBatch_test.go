@@ -0,0 +1,155 @@
+package libDatabox
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestKVBatchOps(t *testing.T) {
+
+	batch := StoreClient.KVJSON.Batch(dsID).
+		Write("a", []byte(`{"v":1}`)).
+		Delete("b").
+		Write("c", []byte(`{"v":3}`))
+
+	expected := []KVBatchOp{
+		{Type: KVBatchWrite, Key: "a", Value: []byte(`{"v":1}`)},
+		{Type: KVBatchDelete, Key: "b"},
+		{Type: KVBatchWrite, Key: "c", Value: []byte(`{"v":3}`)},
+	}
+	if !reflect.DeepEqual(batch.Ops(), expected) {
+		t.Errorf("Batch expected ops %v got %v", expected, batch.Ops())
+	}
+}
+
+func TestKVBatchCommitInvalid(t *testing.T) {
+
+	if err := StoreClient.KVJSON.Batch(dsID).Commit(); err != nil {
+		t.Errorf("Commit of an empty batch expected err to be nil got %s", err.Error())
+	}
+
+	invalid := []*KVBatch{
+		StoreClient.KVJSON.Batch(dsID).Write("", []byte("{}")),
+		StoreClient.KVJSON.Batch(dsID).Delete(BatchEventKey),
+		StoreClient.KVJSON.Batch(dsID).Write("a", []byte("not json")),
+	}
+	for _, batch := range invalid {
+		if err := batch.Commit(); err == nil {
+			t.Errorf("Commit expected an error for %v", batch.Ops())
+		}
+	}
+}
+
+func TestRollbackOps(t *testing.T) {
+
+	journal := kvBatchJournal{
+		ID: "id",
+		Previous: []kvBatchPrevious{
+			{Key: "a", Value: []byte("old"), Existed: true},
+			{Key: "b"},
+		},
+	}
+
+	expected := []KVBatchOp{
+		{Type: KVBatchDelete, Key: "b"},
+		{Type: KVBatchWrite, Key: "a", Value: []byte("old")},
+	}
+	if ops := rollbackOps(journal); !reflect.DeepEqual(ops, expected) {
+		t.Errorf("rollbackOps expected %v got %v", expected, ops)
+	}
+}
+
+func TestKVBatchEventJSON(t *testing.T) {
+
+	event := KVBatchEvent{
+		ID:           "id",
+		DataSourceID: dsID,
+		TimestampMS:  1,
+		Ops:          []KVBatchOp{{Type: KVBatchWrite, Key: "a", Value: []byte{0, 1}}, {Type: KVBatchDelete, Key: "b"}},
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("Marshal failed expected err to be nil got %s", err.Error())
+	}
+	decoded := KVBatchEvent{}
+	err = json.Unmarshal(data, &decoded)
+	if err != nil || !reflect.DeepEqual(decoded, event) {
+		t.Errorf("KVBatchEvent expected %+v after round trip got %+v %v", event, decoded, err)
+	}
+}
+
+func TestBatchEventsFilter(t *testing.T) {
+
+	responses := []ObserveResponse{
+		{Key: "a", Data: []byte(`{"v":1}`)},
+		{Key: BatchEventKey, Data: []byte(`{"id":"batch-1","ops":[{"type":"delete","key":"a"}]}`)},
+		{Key: BatchEventKey, Data: []byte(`not json`)},
+	}
+
+	observeChan := make(chan ObserveResponse, len(responses))
+	for _, resp := range responses {
+		if isBatchEvent(resp) {
+			observeChan <- resp
+		}
+	}
+	close(observeChan)
+
+	events := []KVBatchEvent{}
+	for event := range batchEvents(observeChan) {
+		events = append(events, event)
+	}
+	if len(events) != 1 || events[0].ID != "batch-1" || events[0].Ops[0].Key != "a" {
+		t.Errorf("batchEvents expected only batch-1 got %+v", events)
+	}
+	if isUserKey(responses[1]) {
+		t.Error("isUserKey expected batch events to be hidden from Observe")
+	}
+}
+
+func TestLiveKeysHidesInternalKeys(t *testing.T) {
+
+	keys, err := StoreClient.KVJSON.liveKeys(dsID, []string{"a", BatchEventKey, BatchJournalKeyPrefix + "id", "b"})
+	if err != nil {
+		t.Fatalf("liveKeys failed expected err to be nil got %s", err.Error())
+	}
+	if !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Errorf("liveKeys expected [a b] got %v", keys)
+	}
+}
+
+func TestKVJSONBatchCommit(t *testing.T) {
+
+	prefix := "batch" + strconv.FormatInt(time.Now().UnixNano(), 10) + "-"
+	err := StoreClient.KVJSON.Write(dsID, prefix+"b", []byte(`{"v":0}`))
+	if err != nil {
+		t.Fatalf("Write to %s failed expected err to be nil got %s", dsID, err.Error())
+	}
+
+	events, err := StoreClient.KVJSON.ObserveBatches(dsID)
+	if err != nil {
+		t.Fatalf("ObserveBatches failed expected err to be nil got %s", err.Error())
+	}
+
+	err = StoreClient.KVJSON.Batch(dsID).Write(prefix+"a", []byte(`{"v":1}`)).Delete(prefix + "b").Commit()
+	if err != nil {
+		t.Fatalf("Commit failed expected err to be nil got %s", err.Error())
+	}
+
+	value, _ := StoreClient.KVJSON.Read(dsID, prefix+"a")
+	if string(value) != `{"v":1}` {
+		t.Errorf("Read after Commit expected {\"v\":1} got %s", value)
+	}
+
+	select {
+	case event := <-events:
+		if len(event.Ops) != 2 || event.Ops[0].Key != prefix+"a" || event.Ops[1].Type != KVBatchDelete {
+			t.Errorf("ObserveBatches expected the committed batch got %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("ObserveBatches timed out waiting for the batch event")
+	}
+}
@@ -0,0 +1,239 @@
+package libDatabox
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	zest "github.com/me-box/goZestClient"
+)
+
+// DeleteEventKey is written by Delete and DeleteAll so Changes feeds can see deletes, the store
+// does not send observe events for them. Events are only written for datasources with a Changes
+// feed running on this client or after PublishDeletes.
+const DeleteEventKey = internalKeyPrefix + "deleted"
+
+// KVOp is the kind of change in a KVChange
+type KVOp string
+
+const (
+	KVOpWrite     KVOp = "write"
+	KVOpDelete    KVOp = "delete"
+	KVOpDeleteAll KVOp = "delete-all"
+)
+
+// KVChange is a change to a key received from Changes. Previous is only set if the feed tracks
+// previous values and the key had a known value, HasPrevious tells the two cases apart.
+type KVChange struct {
+	Op           KVOp
+	DataSourceID string
+	Key          string //empty for KVOpDeleteAll
+	TimestampMS  int64
+	Value        []byte //nil for deletes
+	Previous     []byte
+	HasPrevious  bool
+}
+
+// Apply makes the same change to an in memory copy of the datasource
+func (c KVChange) Apply(mirror map[string][]byte) {
+	switch c.Op {
+	case KVOpWrite:
+		mirror[c.Key] = c.Value
+	case KVOpDelete:
+		delete(mirror, c.Key)
+	case KVOpDeleteAll:
+		for key := range mirror {
+			delete(mirror, key)
+		}
+	}
+}
+
+type kvDeleteEvent struct {
+	Keys []string `json:"keys,omitempty"`
+	All  bool     `json:"all,omitempty"`
+}
+
+// deleteEvents counts the Changes feeds and PublishDeletes calls per datasource, it is shared with
+// the clients returned by WithSpan
+type deleteEvents struct {
+	lock   *sync.Mutex
+	counts map[string]int
+}
+
+func newDeleteEvents() *deleteEvents {
+	return &deleteEvents{
+		lock:   &sync.Mutex{},
+		counts: make(map[string]int),
+	}
+}
+
+// add registers a publisher of delete events for dataSourceID and returns the function that removes it
+func (d *deleteEvents) add(dataSourceID string) func() {
+
+	d.lock.Lock()
+	d.counts[dataSourceID]++
+	d.lock.Unlock()
+
+	once := &sync.Once{}
+	return func() {
+		once.Do(func() {
+			d.lock.Lock()
+			defer d.lock.Unlock()
+			d.counts[dataSourceID]--
+			if d.counts[dataSourceID] <= 0 {
+				delete(d.counts, dataSourceID)
+			}
+		})
+	}
+}
+
+func (d *deleteEvents) enabled(dataSourceID string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.counts[dataSourceID] > 0
+}
+
+// PublishDeletes makes Delete and DeleteAll write delete events for dataSourceID until the returned
+// function is called. Use it when the Changes feeds of a datasource run in another app, feeds started
+// with this client publish the deletes of this client without it.
+func (kvj *KVStore) PublishDeletes(dataSourceID string) func() {
+	return kvj.csc.deleteEvents.add(dataSourceID)
+}
+
+// writeDeleteEvent records a delete for Changes feeds, nothing is written if no feed needs it
+func (kvj *KVStore) writeDeleteEvent(dataSourceID string, event kvDeleteEvent) error {
+
+	if !kvj.csc.deleteEvents.enabled(dataSourceID) {
+		return nil
+	}
+
+	err := kvj.writeJSON(dataSourceID, DeleteEventKey, event)
+	if err != nil {
+		return errors.New("Error writing delete event for " + dataSourceID + ": " + err.Error())
+	}
+
+	return nil
+}
+
+// Changes observes dataSourceID and returns a KVChange for every write and delete. If trackPrevious
+// is true the current values are read first and kept in memory so each change carries the value it
+// replaced. Only deletes made by this client, or by clients that called PublishDeletes, are seen.
+// Call the returned function to stop the feed, the channel is then closed.
+func (kvj *KVStore) Changes(dataSourceID string, trackPrevious bool) (<-chan KVChange, func(), error) {
+
+	path := "/kv/" + dataSourceID + "/*"
+
+	observeChan, stopObserve, err := kvj.csc.observeWithCancel(path, kvj.contentType, zest.ObserveModeData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	feed := &kvChangeFeed{
+		kv:           kvj,
+		dataSourceID: dataSourceID,
+	}
+	if trackPrevious {
+		pairs, err := kvj.Scan(dataSourceID, "")
+		if err != nil {
+			stopObserve()
+			return nil, nil, err
+		}
+		feed.values = make(map[string][]byte, len(pairs))
+		for _, pair := range pairs {
+			feed.values[pair.Key] = pair.Value
+		}
+	}
+
+	unpublish := kvj.PublishDeletes(dataSourceID)
+
+	changeChan := make(chan KVChange)
+	stop := make(chan struct{})
+	stopOnce := &sync.Once{}
+	cancel := func() {
+		stopOnce.Do(func() {
+			close(stop)
+			stopObserve()
+			unpublish()
+		})
+	}
+
+	go func() {
+		defer close(changeChan)
+		for resp := range observeChan {
+			for _, change := range feed.changes(resp) {
+				select {
+				case changeChan <- change:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+
+	return changeChan, cancel, nil
+}
+
+// kvChangeFeed turns observe responses into changes, values is nil if previous values are not tracked
+type kvChangeFeed struct {
+	kv           *KVStore
+	dataSourceID string
+	values       map[string][]byte
+}
+
+func (f *kvChangeFeed) changes(resp ObserveResponse) []KVChange {
+
+	timestamp := resp.TimestampMS
+	if timestamp == 0 {
+		timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+
+	if resp.Key == DeleteEventKey {
+		event := kvDeleteEvent{}
+		err := json.Unmarshal(resp.Data, &event)
+		if err != nil {
+			Warn("[Changes] Error decoding delete event: " + err.Error())
+			return nil
+		}
+		if event.All {
+			if f.values != nil {
+				f.values = make(map[string][]byte)
+			}
+			return []KVChange{{Op: KVOpDeleteAll, DataSourceID: f.dataSourceID, TimestampMS: timestamp}}
+		}
+		changes := []KVChange{}
+		for _, key := range event.Keys {
+			changes = append(changes, f.change(KVOpDelete, key, nil, timestamp))
+		}
+		return changes
+	}
+
+	if strings.HasPrefix(resp.Key, internalKeyPrefix) {
+		return nil
+	}
+
+	return []KVChange{f.change(KVOpWrite, resp.Key, resp.Data, timestamp)}
+}
+
+func (f *kvChangeFeed) change(op KVOp, key string, value []byte, timestamp int64) KVChange {
+
+	change := KVChange{
+		Op:           op,
+		DataSourceID: f.dataSourceID,
+		Key:          key,
+		TimestampMS:  timestamp,
+		Value:        value,
+	}
+
+	if f.values != nil {
+		change.Previous, change.HasPrevious = f.values[key]
+		if op == KVOpWrite {
+			f.values[key] = value
+		} else {
+			delete(f.values, key)
+		}
+	}
+
+	return change
+}
@@ -0,0 +1,121 @@
+package libDatabox
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestKVChangeFeed(t *testing.T) {
+
+	feed := &kvChangeFeed{kv: StoreClient.KVJSON, dataSourceID: dsID, values: map[string][]byte{"a": []byte(`{"v":0}`)}}
+
+	changes := feed.changes(ObserveResponse{TimestampMS: 1, DataSourceID: dsID, Key: "a", Data: []byte(`{"v":1}`)})
+	expected := []KVChange{{Op: KVOpWrite, DataSourceID: dsID, Key: "a", TimestampMS: 1, Value: []byte(`{"v":1}`), Previous: []byte(`{"v":0}`), HasPrevious: true}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("changes for a write expected %+v got %+v", expected, changes)
+	}
+
+	changes = feed.changes(ObserveResponse{TimestampMS: 2, DataSourceID: dsID, Key: "b", Data: []byte(`{"v":2}`)})
+	if len(changes) != 1 || changes[0].HasPrevious {
+		t.Errorf("changes for a new key expected no previous value got %+v", changes)
+	}
+
+	changes = feed.changes(ObserveResponse{TimestampMS: 3, DataSourceID: dsID, Key: DeleteEventKey, Data: []byte(`{"keys":["a"]}`)})
+	expected = []KVChange{{Op: KVOpDelete, DataSourceID: dsID, Key: "a", TimestampMS: 3, Previous: []byte(`{"v":1}`), HasPrevious: true}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("changes for a delete expected %+v got %+v", expected, changes)
+	}
+
+	changes = feed.changes(ObserveResponse{TimestampMS: 4, DataSourceID: dsID, Key: BatchEventKey, Data: []byte(`{}`)})
+	if len(changes) != 0 {
+		t.Errorf("changes for internal keys expected none got %+v", changes)
+	}
+
+	changes = feed.changes(ObserveResponse{TimestampMS: 5, DataSourceID: dsID, Key: DeleteEventKey, Data: []byte(`{"all":true}`)})
+	if len(changes) != 1 || changes[0].Op != KVOpDeleteAll || len(feed.values) != 0 {
+		t.Errorf("changes for delete all expected one KVOpDeleteAll got %+v", changes)
+	}
+}
+
+func TestKVChangeFeedWithoutPrevious(t *testing.T) {
+
+	feed := &kvChangeFeed{kv: StoreClient.KVJSON, dataSourceID: dsID}
+
+	changes := feed.changes(ObserveResponse{TimestampMS: 1, Key: "a", Data: []byte(`{"v":1}`)})
+	if len(changes) != 1 || changes[0].HasPrevious || changes[0].Previous != nil {
+		t.Errorf("changes expected no previous values got %+v", changes)
+	}
+
+	changes = feed.changes(ObserveResponse{TimestampMS: 1, Key: "a", Data: []byte{}})
+	if len(changes) != 1 || changes[0].Op != KVOpWrite || len(changes[0].Value) != 0 {
+		t.Errorf("changes for empty data expected a write got %+v", changes)
+	}
+}
+
+func TestDeleteEvents(t *testing.T) {
+
+	events := newDeleteEvents()
+	if events.enabled(dsID) {
+		t.Error("delete events expected to be off without a feed")
+	}
+
+	stopFirst := events.add(dsID)
+	stopSecond := events.add(dsID)
+	if !events.enabled(dsID) || events.enabled("other") {
+		t.Errorf("delete events expected only for %s", dsID)
+	}
+
+	stopFirst()
+	stopFirst()
+	if !events.enabled(dsID) {
+		t.Error("delete events expected while the second feed runs")
+	}
+
+	stopSecond()
+	if events.enabled(dsID) || len(events.counts) != 0 {
+		t.Errorf("delete events expected to be off once all feeds stop got %v", events.counts)
+	}
+}
+
+func TestKVChangeApply(t *testing.T) {
+
+	mirror := map[string][]byte{}
+	KVChange{Op: KVOpWrite, Key: "a", Value: []byte("1")}.Apply(mirror)
+	KVChange{Op: KVOpWrite, Key: "b", Value: []byte("2")}.Apply(mirror)
+	KVChange{Op: KVOpDelete, Key: "a"}.Apply(mirror)
+	if !reflect.DeepEqual(mirror, map[string][]byte{"b": []byte("2")}) {
+		t.Errorf("Apply expected only b got %v", mirror)
+	}
+
+	KVChange{Op: KVOpDeleteAll}.Apply(mirror)
+	if len(mirror) != 0 {
+		t.Errorf("Apply of delete all expected an empty mirror got %v", mirror)
+	}
+}
+
+func TestKVJSONChanges(t *testing.T) {
+
+	key := "changes" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	changes, stop, err := StoreClient.KVJSON.Changes(dsID, true)
+	if err != nil {
+		t.Fatalf("Changes failed expected err to be nil got %s", err.Error())
+	}
+	defer stop()
+
+	StoreClient.KVJSON.Write(dsID, key, []byte(`{"v":1}`))
+	StoreClient.KVJSON.Delete(dsID, key)
+
+	for _, op := range []KVOp{KVOpWrite, KVOpDelete} {
+		select {
+		case change := <-changes:
+			if change.Op != op || change.Key != key {
+				t.Errorf("Changes expected %s of %s got %+v", op, key, change)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Changes timed out waiting for %s", op)
+		}
+	}
+}
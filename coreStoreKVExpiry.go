@@ -0,0 +1,345 @@
+package libDatabox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// internalKeyPrefix starts all keys used internally by this library, they are hidden from ListKeys
+const internalKeyPrefix = "_databox_"
+
+// ExpiryKeyPrefix is prepended to a key to name the record holding its expiry time
+const ExpiryKeyPrefix = internalKeyPrefix + "expires."
+
+// DefaultExpirySweepInterval is how often a KVExpiryManager deletes expired keys if no interval is given
+const DefaultExpirySweepInterval = time.Minute
+
+// expiryRecord is stored under ExpiryKeyPrefix+key, the value itself is stored unchanged. Hash is the
+// sha256 of the value the record applies to so a record left behind by a later Write is ignored.
+type expiryRecord struct {
+	Expires int64  `json:"expires"` //unix time in ms
+	Hash    string `json:"sha256"`
+}
+
+// expiringDataSources are the datasources known to hold keys written with WriteWithTTL, it is shared
+// with the clients returned by WithSpan
+type expiringDataSources struct {
+	lock *sync.Mutex
+	ids  map[string]bool
+}
+
+func newExpiringDataSources() *expiringDataSources {
+	return &expiringDataSources{
+		lock: &sync.Mutex{},
+		ids:  make(map[string]bool),
+	}
+}
+
+func (e *expiringDataSources) add(dataSourceID string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.ids[dataSourceID] = true
+}
+
+func (e *expiringDataSources) has(dataSourceID string) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.ids[dataSourceID]
+}
+
+// TrackExpiry makes Read hide expired keys of dataSourceID and Write remove the expiry of the keys it
+// writes. WriteWithTTL and NewKVExpiryManager do this for their datasources, call it when the keys
+// of a datasource are written with a ttl by another app. Reads of other datasources do not check expiry.
+func (kvj *KVStore) TrackExpiry(dataSourceID string) {
+	kvj.csc.expiring.add(dataSourceID)
+}
+
+// WriteWithTTL writes payload under key and marks it to expire after ttl. Expired keys are hidden
+// from Read and ListKeys straight away and deleted by a KVExpiryManager. Writing the key again with
+// Write removes the expiry.
+//
+// The expiry time is stored in a record under ExpiryKeyPrefix+key and the value is stored unchanged
+// so other readers of the datasource see it as written.
+func (kvj *KVStore) WriteWithTTL(dataSourceID string, key string, payload []byte, ttl time.Duration) error {
+
+	if ttl <= 0 {
+		return errors.New("Invalid ttl " + ttl.String() + " for " + key)
+	}
+	if strings.HasPrefix(key, internalKeyPrefix) {
+		return errors.New("Invalid key " + key + " keys can not start with " + internalKeyPrefix)
+	}
+
+	record, err := json.Marshal(expiryRecord{
+		Expires: time.Now().Add(ttl).UnixNano() / int64(time.Millisecond),
+		Hash:    valueHash(payload),
+	})
+	if err != nil {
+		return err
+	}
+
+	kvj.TrackExpiry(dataSourceID)
+
+	//the expiry record is written first so a value is never left without one
+	err = kvj.write(dataSourceID, ExpiryKeyPrefix+key, record)
+	if err != nil {
+		return errors.New("Error writing expiry for " + key + ": " + err.Error())
+	}
+
+	return kvj.write(dataSourceID, key, payload)
+}
+
+func valueHash(value []byte) string {
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:])
+}
+
+// decodeExpiryRecord decodes an expiry record, ok is false if raw is not one
+func decodeExpiryRecord(raw []byte) (record expiryRecord, ok bool) {
+
+	err := json.Unmarshal(raw, &record)
+
+	return record, err == nil && record.Expires != 0 && record.Hash != ""
+}
+
+// expired is true if the record applies to value and has expired at nowMs
+func (r expiryRecord) expired(value []byte, nowMs int64) bool {
+	return r.Expires <= nowMs && r.Hash == valueHash(value)
+}
+
+// checkExpiry returns value or an empty response if value has expired at now according to the expiry
+// record of key. If the record can not be read the value is returned.
+func (kvj *KVStore) checkExpiry(dataSourceID string, key string, value []byte, now time.Time) []byte {
+
+	raw, err := kvj.cachedRead(dataSourceID, ExpiryKeyPrefix+key)
+	if err != nil {
+		Warn("[KVStore] not checking expiry of " + dataSourceID + "/" + key + ": " + err.Error())
+		return value
+	}
+
+	record, ok := decodeExpiryRecord(raw)
+	if !ok || !record.expired(value, now.UnixNano()/int64(time.Millisecond)) {
+		return value
+	}
+
+	return []byte{}
+}
+
+// liveKeys removes keys used internally by this library and expired keys from keys
+func (kvj *KVStore) liveKeys(dataSourceID string, keys []string) ([]string, error) {
+
+	live := []string{}
+	expiring := []string{}
+	for _, key := range keys {
+		switch {
+		case strings.HasPrefix(key, ExpiryKeyPrefix):
+			expiring = append(expiring, strings.TrimPrefix(key, ExpiryKeyPrefix))
+		case !strings.HasPrefix(key, internalKeyPrefix):
+			live = append(live, key)
+		}
+	}
+	if len(expiring) == 0 {
+		return live, nil
+	}
+
+	expired, _, err := kvj.expiredKeys(dataSourceID, expiring, time.Now())
+	if err != nil {
+		return []string{}, err
+	}
+	hidden := make(map[string]bool, len(expired))
+	for _, key := range expired {
+		hidden[key] = true
+	}
+
+	unexpired := []string{}
+	for _, key := range live {
+		if !hidden[key] {
+			unexpired = append(unexpired, key)
+		}
+	}
+
+	return unexpired, nil
+}
+
+// expiredKeys checks the expiry records of keys and returns the keys that have expired at now and
+// the keys whose record is stale because the key was deleted or written again without a ttl.
+func (kvj *KVStore) expiredKeys(dataSourceID string, keys []string, now time.Time) ([]string, []string, error) {
+
+	recordKeys := make([]string, len(keys))
+	for i, key := range keys {
+		recordKeys[i] = ExpiryKeyPrefix + key
+	}
+
+	readRaw := func(key string) ([]byte, error) {
+		return kvj.readRaw(dataSourceID, key)
+	}
+
+	records, failed := readConcurrently(recordKeys, DefaultKVReadConcurrency, readRaw)
+	if len(failed) > 0 {
+		return nil, nil, errors.New("Error reading expiry records from " + dataSourceID + ": " + strings.Join(failed, ", "))
+	}
+
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+	due := []string{}
+	dueRecords := make(map[string]expiryRecord)
+	stale := []string{}
+	for _, pair := range records {
+		key := strings.TrimPrefix(pair.Key, ExpiryKeyPrefix)
+		record, ok := decodeExpiryRecord(pair.Value)
+		switch {
+		case !ok:
+			stale = append(stale, key)
+		case record.Expires <= nowMs:
+			due = append(due, key)
+			dueRecords[key] = record
+		}
+	}
+
+	//the record may be out of date so check it still applies to the value
+	values, failed := readConcurrently(due, DefaultKVReadConcurrency, readRaw)
+	if len(failed) > 0 {
+		return nil, nil, errors.New("Error reading expiring keys from " + dataSourceID + ": " + strings.Join(failed, ", "))
+	}
+
+	expired := []string{}
+	for _, pair := range values {
+		if dueRecords[pair.Key].expired(pair.Value, nowMs) {
+			expired = append(expired, pair.Key)
+		} else {
+			stale = append(stale, pair.Key)
+		}
+	}
+
+	return expired, stale, nil
+}
+
+// Sweep deletes the expired keys and stale expiry records in dataSourceID and returns the number
+// of expired keys deleted
+func (kvj *KVStore) Sweep(dataSourceID string) (int, error) {
+
+	keys, err := kvj.listAllKeys(dataSourceID)
+	if err != nil {
+		return 0, err
+	}
+
+	expiring := []string{}
+	for _, key := range keys {
+		if strings.HasPrefix(key, ExpiryKeyPrefix) {
+			expiring = append(expiring, strings.TrimPrefix(key, ExpiryKeyPrefix))
+		}
+	}
+	if len(expiring) == 0 {
+		return 0, nil
+	}
+
+	expired, stale, err := kvj.expiredKeys(dataSourceID, expiring, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	failed := []string{}
+	deleted := 0
+	for _, key := range expired {
+		err := kvj.Delete(dataSourceID, key)
+		if err != nil {
+			failed = append(failed, key)
+			continue
+		}
+		//Delete also removes the expiry record
+		deleted++
+	}
+	for _, key := range stale {
+		err := kvj.Delete(dataSourceID, ExpiryKeyPrefix+key)
+		if err != nil {
+			failed = append(failed, ExpiryKeyPrefix+key)
+		}
+	}
+
+	if len(failed) > 0 {
+		return deleted, errors.New("Error deleting expired keys from " + dataSourceID + ": " + strings.Join(failed, ", "))
+	}
+
+	return deleted, nil
+}
+
+// KVExpiryManager periodically deletes expired keys written with WriteWithTTL
+type KVExpiryManager struct {
+	kv            *KVStore
+	dataSourceIDs []string
+	interval      time.Duration
+	done          chan struct{}
+	closeOnce     *sync.Once
+}
+
+// NewKVExpiryManager starts deleting expired keys from the datasources every interval
+func NewKVExpiryManager(kv *KVStore, dataSourceIDs []string, interval time.Duration) *KVExpiryManager {
+
+	if interval <= 0 {
+		interval = DefaultExpirySweepInterval
+	}
+
+	for _, dataSourceID := range dataSourceIDs {
+		kv.TrackExpiry(dataSourceID)
+	}
+
+	em := &KVExpiryManager{
+		kv:            kv,
+		dataSourceIDs: dataSourceIDs,
+		interval:      interval,
+		done:          make(chan struct{}),
+		closeOnce:     &sync.Once{},
+	}
+
+	go em.sweepLoop()
+
+	return em
+}
+
+// Sweep deletes expired keys from all the managers datasources now
+func (em *KVExpiryManager) Sweep() (int, error) {
+
+	total := 0
+	failed := []string{}
+	for _, dataSourceID := range em.dataSourceIDs {
+		deleted, err := em.kv.Sweep(dataSourceID)
+		total += deleted
+		if err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return total, errors.New(strings.Join(failed, "; "))
+	}
+
+	return total, nil
+}
+
+// Close stops the manager, keys are no longer deleted but expired keys are still hidden
+func (em *KVExpiryManager) Close() {
+	em.closeOnce.Do(func() {
+		close(em.done)
+	})
+}
+
+func (em *KVExpiryManager) sweepLoop() {
+
+	ticker := time.NewTicker(em.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-em.done:
+			return
+		case <-ticker.C:
+			_, err := em.Sweep()
+			if err != nil {
+				Warn("[KVExpiryManager] " + err.Error())
+			}
+		}
+	}
+}
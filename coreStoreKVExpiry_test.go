@@ -0,0 +1,132 @@
+package libDatabox
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestExpiryRecord(t *testing.T) {
+
+	nowMs := time.Now().UnixNano() / int64(time.Millisecond)
+	value := []byte(`{"token":"abc"}`)
+
+	record, ok := decodeExpiryRecord([]byte(`{"expires":` + strconv.FormatInt(nowMs-1000, 10) + `,"sha256":"` + valueHash(value) + `"}`))
+	if !ok {
+		t.Fatal("decodeExpiryRecord expected a valid record")
+	}
+	if !record.expired(value, nowMs) {
+		t.Error("expired expected true for a past expiry of the same value")
+	}
+	if record.expired([]byte(`{"token":"def"}`), nowMs) {
+		t.Error("expired expected false for a value written after the record")
+	}
+	if record.expired(value, nowMs-2000) {
+		t.Error("expired expected false before the expiry time")
+	}
+
+	//values that look like records are not mistaken for them
+	for _, raw := range []string{"", `{"value":1}`, `{"databoxExpires":1,"data":{}}`, `{"expires":1}`} {
+		if _, ok := decodeExpiryRecord([]byte(raw)); ok {
+			t.Errorf("decodeExpiryRecord(%q) expected false", raw)
+		}
+	}
+}
+
+func TestExpiringDataSources(t *testing.T) {
+
+	csc := NewCoreStoreClient(Arbiter, "", StoreURL, false)
+	defer csc.Close()
+
+	if csc.expiring.has(dsID) {
+		t.Errorf("expiry expected to be untracked for %s", dsID)
+	}
+
+	NewKVExpiryManager(csc.KVJSON, []string{dsID}, time.Hour).Close()
+	if !csc.expiring.has(dsID) || !csc.WithSpan(SpanContext{}).expiring.has(dsID) {
+		t.Errorf("expiry expected to be tracked for %s after starting a KVExpiryManager", dsID)
+	}
+}
+
+func TestCheckExpiryReadError(t *testing.T) {
+
+	csc := NewCoreStoreClient(Arbiter, "", StoreURL, false)
+	csc.Close()
+
+	//the value has already been read so a failure to read its expiry record returns it
+	value := csc.KVJSON.checkExpiry(dsID, "key", []byte(`{"value":1}`), time.Now())
+	if string(value) != `{"value":1}` {
+		t.Errorf("checkExpiry expected the value when the record can not be read got %s", value)
+	}
+}
+
+func TestWriteWithTTLInvalid(t *testing.T) {
+
+	err := StoreClient.KVJSON.WriteWithTTL(dsID, "key", []byte("{}"), 0)
+	if err == nil {
+		t.Error("WriteWithTTL expected an error for a zero ttl")
+	}
+
+	err = StoreClient.KVJSON.WriteWithTTL(dsID, ExpiryKeyPrefix+"key", []byte("{}"), time.Second)
+	if err == nil {
+		t.Error("WriteWithTTL expected an error for a key starting with ExpiryKeyPrefix")
+	}
+}
+
+func TestKVJSONWriteWithTTL(t *testing.T) {
+
+	key := "ttl" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	err := StoreClient.KVJSON.WriteWithTTL(dsID, key, []byte(`{"value":1}`), 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("WriteWithTTL failed expected err to be nil got %s", err.Error())
+	}
+
+	value, err := StoreClient.KVJSON.Read(dsID, key)
+	if err != nil || string(value) != `{"value":1}` {
+		t.Errorf("Read before expiry expected value got %s %v", value, err)
+	}
+
+	keys, err := StoreClient.KVJSON.ListKeys(dsID)
+	if err != nil {
+		t.Fatalf("ListKeys failed expected err to be nil got %s", err.Error())
+	}
+	if !contains(keys, key) || contains(keys, ExpiryKeyPrefix+key) {
+		t.Errorf("ListKeys expected %s and no expiry record got %v", key, keys)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	value, err = StoreClient.KVJSON.Read(dsID, key)
+	if err != nil || len(value) != 0 {
+		t.Errorf("Read after expiry expected an empty value got %s %v", value, err)
+	}
+
+	keys, _ = StoreClient.KVJSON.ListKeys(dsID)
+	if contains(keys, key) {
+		t.Errorf("ListKeys after expiry expected %s to be hidden got %v", key, keys)
+	}
+
+	deleted, err := StoreClient.KVJSON.Sweep(dsID)
+	if err != nil || deleted < 1 {
+		t.Errorf("Sweep expected to delete expired keys got %d %v", deleted, err)
+	}
+
+	//writing the same value again without a ttl removes the expiry
+	StoreClient.KVJSON.WriteWithTTL(dsID, key, []byte(`{"value":1}`), 100*time.Millisecond)
+	StoreClient.KVJSON.Write(dsID, key, []byte(`{"value":1}`))
+	time.Sleep(200 * time.Millisecond)
+	value, err = StoreClient.KVJSON.Read(dsID, key)
+	if err != nil || string(value) != `{"value":1}` {
+		t.Errorf("Read after Write expected the value to no longer expire got %s %v", value, err)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
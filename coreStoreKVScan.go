@@ -0,0 +1,131 @@
+package libDatabox
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultKVReadConcurrency is the number of reads GetMany and Scan run at the same time
+const DefaultKVReadConcurrency = 8
+
+// KVPair is a key and the value stored under it
+type KVPair struct {
+	Key   string
+	Value []byte
+}
+
+// KVPage is one page of a ScanPage. Pass NextKey as after to get the next page, it is empty
+// when there are no more keys.
+type KVPage struct {
+	Pairs   []KVPair
+	NextKey string
+}
+
+// GetMany reads keys in parallel, at most DefaultKVReadConcurrency at a time, and returns the pairs
+// in the same order as keys. If some reads fail the pairs that were read are returned along with an
+// error listing the keys that failed.
+func (kvj *KVStore) GetMany(dataSourceID string, keys []string) ([]KVPair, error) {
+	return kvj.GetManyWithConcurrency(dataSourceID, keys, DefaultKVReadConcurrency)
+}
+
+// GetManyWithConcurrency is GetMany with at most concurrency reads running at the same time
+func (kvj *KVStore) GetManyWithConcurrency(dataSourceID string, keys []string, concurrency int) ([]KVPair, error) {
+
+	pairs, failed := readConcurrently(keys, concurrency, func(key string) ([]byte, error) {
+		return kvj.Read(dataSourceID, key)
+	})
+
+	if len(failed) > 0 {
+		return pairs, errors.New("Error reading keys from " + dataSourceID + ": " + strings.Join(failed, ", "))
+	}
+
+	return pairs, nil
+}
+
+// Scan returns all keys starting with prefix and their values sorted by key. The store has no
+// prefix query so all keys are listed and the matching ones read with GetMany, use ScanPage to
+// read the values of a large key set a page at a time.
+func (kvj *KVStore) Scan(dataSourceID string, prefix string) ([]KVPair, error) {
+
+	keys, err := kvj.ListKeys(dataSourceID)
+	if err != nil {
+		return []KVPair{}, err
+	}
+
+	matching, _ := pageKeys(keys, prefix, "", 0)
+
+	return kvj.GetMany(dataSourceID, matching)
+}
+
+// ScanPage returns up to limit keys starting with prefix that sort after the key after, with
+// their values. Use an empty after for the first page. limit <= 0 returns all remaining keys.
+func (kvj *KVStore) ScanPage(dataSourceID string, prefix string, after string, limit int) (KVPage, error) {
+
+	keys, err := kvj.ListKeys(dataSourceID)
+	if err != nil {
+		return KVPage{Pairs: []KVPair{}}, err
+	}
+
+	page, next := pageKeys(keys, prefix, after, limit)
+	pairs, err := kvj.GetMany(dataSourceID, page)
+
+	return KVPage{Pairs: pairs, NextKey: next}, err
+}
+
+// pageKeys sorts the keys starting with prefix and returns up to limit of those after the key after
+// and the key to continue from if there are more
+func pageKeys(keys []string, prefix string, after string, limit int) ([]string, string) {
+
+	matching := []string{}
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) && (after == "" || key > after) {
+			matching = append(matching, key)
+		}
+	}
+	sort.Strings(matching)
+
+	if limit <= 0 || len(matching) <= limit {
+		return matching, ""
+	}
+
+	return matching[:limit], matching[limit-1]
+}
+
+// readConcurrently calls read for each key with at most concurrency calls running at the same time.
+// The pairs that were read are returned in the order of keys followed by the keys that failed.
+func readConcurrently(keys []string, concurrency int, read func(key string) ([]byte, error)) ([]KVPair, []string) {
+
+	if concurrency <= 0 {
+		concurrency = DefaultKVReadConcurrency
+	}
+
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, key string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			values[i], errs[i] = read(key)
+		}(i, key)
+	}
+	wg.Wait()
+
+	pairs := []KVPair{}
+	failed := []string{}
+	for i, key := range keys {
+		if errs[i] != nil {
+			failed = append(failed, key+" ("+errs[i].Error()+")")
+			continue
+		}
+		pairs = append(pairs, KVPair{Key: key, Value: values[i]})
+	}
+
+	return pairs, failed
+}
@@ -0,0 +1,102 @@
+package libDatabox
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPageKeys(t *testing.T) {
+
+	keys := []string{"device/2/state", "config", "device/1/state", "device/1/name", "device/3/state"}
+
+	page, next := pageKeys(keys, "device/", "", 2)
+	if !reflect.DeepEqual(page, []string{"device/1/name", "device/1/state"}) || next != "device/1/state" {
+		t.Errorf("pageKeys first page got %v next %s", page, next)
+	}
+
+	page, next = pageKeys(keys, "device/", next, 2)
+	if !reflect.DeepEqual(page, []string{"device/2/state", "device/3/state"}) || next != "" {
+		t.Errorf("pageKeys last page got %v next %s", page, next)
+	}
+
+	page, next = pageKeys(keys, "", "", 0)
+	if len(page) != len(keys) || next != "" {
+		t.Errorf("pageKeys with no limit expected all keys got %v next %s", page, next)
+	}
+
+	page, _ = pageKeys(keys, "missing/", "", 10)
+	if len(page) != 0 {
+		t.Errorf("pageKeys expected no keys got %v", page)
+	}
+}
+
+func TestReadConcurrently(t *testing.T) {
+
+	keys := []string{}
+	for i := 0; i < 20; i++ {
+		keys = append(keys, "k"+strconv.Itoa(i))
+	}
+
+	lock := &sync.Mutex{}
+	running, maxRunning := 0, 0
+	pairs, failed := readConcurrently(keys, 3, func(key string) ([]byte, error) {
+		lock.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		lock.Unlock()
+
+		time.Sleep(2 * time.Millisecond)
+
+		lock.Lock()
+		running--
+		lock.Unlock()
+
+		if key == "k5" {
+			return nil, errors.New("missing")
+		}
+		return []byte("v" + key), nil
+	})
+
+	if maxRunning > 3 {
+		t.Errorf("readConcurrently expected at most 3 reads at once got %d", maxRunning)
+	}
+	if len(failed) != 1 || failed[0] != "k5 (missing)" {
+		t.Errorf("readConcurrently expected k5 to fail got %v", failed)
+	}
+	if len(pairs) != 19 || pairs[0].Key != "k0" || string(pairs[0].Value) != "vk0" || pairs[5].Key != "k6" {
+		t.Errorf("readConcurrently expected pairs in key order got %v", pairs)
+	}
+}
+
+func TestKVJSONScan(t *testing.T) {
+
+	prefix := "scan" + strconv.FormatInt(time.Now().UnixNano(), 10) + "-"
+	for i := 0; i < 3; i++ {
+		err := StoreClient.KVJSON.Write(dsID, prefix+strconv.Itoa(i), []byte(`{"value":`+strconv.Itoa(i)+`}`))
+		if err != nil {
+			t.Fatalf("Write to %s failed expected err to be nil got %s", dsID, err.Error())
+		}
+	}
+
+	pairs, err := StoreClient.KVJSON.Scan(dsID, prefix)
+	if err != nil {
+		t.Fatalf("Scan failed expected err to be nil got %s", err.Error())
+	}
+	if len(pairs) != 3 || pairs[2].Key != prefix+"2" || string(pairs[2].Value) != `{"value":2}` {
+		t.Errorf("Scan expected 3 pairs got %v", pairs)
+	}
+
+	page, err := StoreClient.KVJSON.ScanPage(dsID, prefix, "", 2)
+	if err != nil {
+		t.Fatalf("ScanPage failed expected err to be nil got %s", err.Error())
+	}
+	if len(page.Pairs) != 2 || page.NextKey != prefix+"1" {
+		t.Errorf("ScanPage expected 2 pairs and a next key got %+v", page)
+	}
+}
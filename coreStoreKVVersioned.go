@@ -0,0 +1,190 @@
+package libDatabox
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// VersionKeyPrefix is prepended to a key to name the record holding its version
+const VersionKeyPrefix = internalKeyPrefix + "version."
+
+// VersionedValue is a value read with ReadVersioned. Keys that have never been written with
+// WriteIfUnchanged or WriteIfVersion have Version 0.
+type VersionedValue struct {
+	Data    []byte
+	Version int64
+	Writer  string //id of the client that wrote this version
+	Exists  bool
+}
+
+// ConflictError is returned by WriteIfUnchanged and WriteIfVersion when the stored value is not
+// the expected one or another client wrote the key at the same time. ExpectedVersion is
+// UnknownVersion when WriteIfUnchanged found a different value than the one expected.
+type ConflictError struct {
+	DataSourceID    string
+	Key             string
+	ExpectedVersion int64
+	ActualVersion   int64
+}
+
+// UnknownVersion is the ExpectedVersion of a ConflictError for a write that expected a value rather than a version
+const UnknownVersion int64 = -1
+
+func (e *ConflictError) Error() string {
+	if e.ExpectedVersion == UnknownVersion {
+		return "Conflict writing " + e.DataSourceID + "/" + e.Key + ": value changed, found version " +
+			strconv.FormatInt(e.ActualVersion, 10)
+	}
+	return "Conflict writing " + e.DataSourceID + "/" + e.Key + ": expected version " +
+		strconv.FormatInt(e.ExpectedVersion, 10) + " found " + strconv.FormatInt(e.ActualVersion, 10)
+}
+
+// IsConflict returns true if err is a *ConflictError
+func IsConflict(err error) bool {
+	_, ok := err.(*ConflictError)
+	return ok
+}
+
+// versionRecord is stored under VersionKeyPrefix+key, the value itself is stored unchanged
+type versionRecord struct {
+	Version int64  `json:"version"`
+	Writer  string `json:"writer"`
+}
+
+// ReadVersioned reads key and its version record
+func (kvj *KVStore) ReadVersioned(dataSourceID string, key string) (VersionedValue, error) {
+
+	data, err := kvj.Read(dataSourceID, key)
+	if err != nil {
+		return VersionedValue{}, err
+	}
+
+	record, err := kvj.readVersionRecord(dataSourceID, key)
+	if err != nil {
+		return VersionedValue{}, err
+	}
+
+	return VersionedValue{
+		Data:    data,
+		Version: record.Version,
+		Writer:  record.Writer,
+		Exists:  len(data) > 0,
+	}, nil
+}
+
+// WriteIfUnchanged writes value to key if the data currently stored is expected, a nil expected
+// means the key must not exist yet. The version of the key is incremented and returned.
+//
+// This is optimistic and best effort, the store has no atomic operations. The next version is
+// claimed by writing the version record of the key and reading it back, a *ConflictError is returned
+// if the value or version was not the expected one or another writer claimed the version first.
+// It stops most lost updates from stale reads but it is not a lock, two writers whose claims do not
+// overlap in time can both succeed. All writers of the key must use WriteIfUnchanged or WriteIfVersion.
+func (kvj *KVStore) WriteIfUnchanged(dataSourceID string, key string, expected []byte, value []byte) (int64, error) {
+
+	current, err := kvj.ReadVersioned(dataSourceID, key)
+	if err != nil {
+		return 0, err
+	}
+
+	if expected == nil && current.Exists {
+		//the key should not exist so it should not have a version either
+		return 0, &ConflictError{DataSourceID: dataSourceID, Key: key, ExpectedVersion: 0, ActualVersion: current.Version}
+	}
+	if expected != nil && !kvj.valuesEqual(current.Data, expected) {
+		return 0, &ConflictError{DataSourceID: dataSourceID, Key: key, ExpectedVersion: UnknownVersion, ActualVersion: current.Version}
+	}
+
+	return kvj.writeVersion(dataSourceID, key, current.Version, value)
+}
+
+// WriteIfVersion writes value to key if its version is expectedVersion, use 0 for keys that have
+// never been written with WriteIfUnchanged or WriteIfVersion. It is best effort in the same way as
+// WriteIfUnchanged. The new version is returned.
+func (kvj *KVStore) WriteIfVersion(dataSourceID string, key string, expectedVersion int64, value []byte) (int64, error) {
+
+	record, err := kvj.readVersionRecord(dataSourceID, key)
+	if err != nil {
+		return 0, err
+	}
+
+	if record.Version != expectedVersion {
+		return 0, &ConflictError{DataSourceID: dataSourceID, Key: key, ExpectedVersion: expectedVersion, ActualVersion: record.Version}
+	}
+
+	return kvj.writeVersion(dataSourceID, key, expectedVersion, value)
+}
+
+// valuesEqual compares values ignoring json formatting for json stores
+func (kvj *KVStore) valuesEqual(a []byte, b []byte) bool {
+
+	if kvj.contentType == ContentTypeJSON {
+		var compactA, compactB bytes.Buffer
+		if json.Compact(&compactA, a) == nil && json.Compact(&compactB, b) == nil {
+			return bytes.Equal(compactA.Bytes(), compactB.Bytes())
+		}
+	}
+
+	return bytes.Equal(a, b)
+}
+
+// writeVersion claims version from+1 and writes value if no one else claimed it in between
+func (kvj *KVStore) writeVersion(dataSourceID string, key string, from int64, value []byte) (int64, error) {
+
+	if strings.HasPrefix(key, internalKeyPrefix) {
+		return 0, errors.New("Invalid key " + key + " keys can not start with " + internalKeyPrefix)
+	}
+
+	claim := versionRecord{Version: from + 1, Writer: uuid.New().String()}
+	payload, err := json.Marshal(claim)
+	if err != nil {
+		return 0, err
+	}
+
+	err = kvj.Write(dataSourceID, VersionKeyPrefix+key, payload)
+	if err != nil {
+		return 0, errors.New("Error writing version of " + dataSourceID + "/" + key + ": " + err.Error())
+	}
+
+	stored, err := kvj.readVersionRecord(dataSourceID, key)
+	if err != nil {
+		return 0, errors.New("Error checking version of " + dataSourceID + "/" + key + ": " + err.Error())
+	}
+	if stored != claim {
+		return 0, &ConflictError{DataSourceID: dataSourceID, Key: key, ExpectedVersion: claim.Version, ActualVersion: stored.Version}
+	}
+
+	err = kvj.Write(dataSourceID, key, value)
+	if err != nil {
+		return 0, err
+	}
+
+	return claim.Version, nil
+}
+
+// readVersionRecord reads the version record of key, keys without one are version 0
+func (kvj *KVStore) readVersionRecord(dataSourceID string, key string) (versionRecord, error) {
+
+	raw, err := kvj.readRaw(dataSourceID, VersionKeyPrefix+key)
+	if err != nil {
+		return versionRecord{}, err
+	}
+
+	return decodeVersionRecord(raw), nil
+}
+
+// decodeVersionRecord decodes a version record, anything else is version 0
+func decodeVersionRecord(raw []byte) versionRecord {
+
+	record := versionRecord{}
+	if len(bytes.TrimSpace(raw)) == 0 || json.Unmarshal(raw, &record) != nil {
+		return versionRecord{}
+	}
+
+	return record
+}
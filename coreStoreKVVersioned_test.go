@@ -0,0 +1,91 @@
+package libDatabox
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDecodeVersionRecord(t *testing.T) {
+
+	record := decodeVersionRecord([]byte(`{"version":3,"writer":"writer-1"}`))
+	if record.Version != 3 || record.Writer != "writer-1" {
+		t.Errorf("decodeVersionRecord expected version 3 from writer-1 got %+v", record)
+	}
+
+	for _, raw := range []string{"", "not json"} {
+		if record := decodeVersionRecord([]byte(raw)); record.Version != 0 {
+			t.Errorf("decodeVersionRecord(%q) expected version 0 got %+v", raw, record)
+		}
+	}
+}
+
+func TestValuesEqual(t *testing.T) {
+	if !StoreClient.KVJSON.valuesEqual([]byte(`{"a": 1}`), []byte(`{"a":1}`)) {
+		t.Error("valuesEqual expected json values to be equal ignoring spaces")
+	}
+	if StoreClient.KVText.valuesEqual([]byte(`{"a": 1}`), []byte(`{"a":1}`)) {
+		t.Error("valuesEqual expected text values to be compared exactly")
+	}
+}
+
+func TestConflictError(t *testing.T) {
+
+	var err error = &ConflictError{DataSourceID: "ds", Key: "k", ExpectedVersion: 1, ActualVersion: 2}
+	if !IsConflict(err) {
+		t.Error("IsConflict expected true for a ConflictError")
+	}
+	if err.Error() != "Conflict writing ds/k: expected version 1 found 2" {
+		t.Errorf("ConflictError got message %s", err.Error())
+	}
+	if IsConflict(errClientClosed) {
+		t.Error("IsConflict expected false for other errors")
+	}
+
+	err = &ConflictError{DataSourceID: "ds", Key: "k", ExpectedVersion: UnknownVersion, ActualVersion: 2}
+	if err.Error() != "Conflict writing ds/k: value changed, found version 2" {
+		t.Errorf("ConflictError for a changed value got message %s", err.Error())
+	}
+}
+
+func TestKVJSONWriteIfUnchanged(t *testing.T) {
+
+	key := "cas" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	version, err := StoreClient.KVJSON.WriteIfUnchanged(dsID, key, nil, []byte(`{"count":1}`))
+	if err != nil {
+		t.Fatalf("WriteIfUnchanged failed expected err to be nil got %s", err.Error())
+	}
+	if version != 1 {
+		t.Errorf("WriteIfUnchanged expected version 1 got %d", version)
+	}
+
+	_, err = StoreClient.KVJSON.WriteIfUnchanged(dsID, key, []byte(`{"count":0}`), []byte(`{"count":2}`))
+	if conflict, ok := err.(*ConflictError); !ok || conflict.ExpectedVersion != UnknownVersion || conflict.ActualVersion != 1 {
+		t.Errorf("WriteIfUnchanged with stale value expected a conflict with version 1 got %v", err)
+	}
+
+	version, err = StoreClient.KVJSON.WriteIfVersion(dsID, key, 1, []byte(`{"count":2}`))
+	if err != nil || version != 2 {
+		t.Errorf("WriteIfVersion expected version 2 got %d %v", version, err)
+	}
+
+	value, err := StoreClient.KVJSON.ReadVersioned(dsID, key)
+	if err != nil {
+		t.Fatalf("ReadVersioned failed expected err to be nil got %s", err.Error())
+	}
+	if value.Version != 2 || string(value.Data) != `{"count":2}` {
+		t.Errorf("ReadVersioned expected version 2 got %+v", value)
+	}
+
+	//the value is stored unchanged for plain reads
+	data, err := StoreClient.KVJSON.Read(dsID, key)
+	if err != nil || string(data) != `{"count":2}` {
+		t.Errorf("Read expected the plain value got %s %v", data, err)
+	}
+
+	keys, _ := StoreClient.KVJSON.ListKeys(dsID)
+	if contains(keys, VersionKeyPrefix+key) {
+		t.Errorf("ListKeys expected the version record to be hidden got %v", keys)
+	}
+}
@@ -101,6 +101,18 @@ func TestListKeysKVJSON(t *testing.T) {
 
 }
 
+func TestIsUserKey(t *testing.T) {
+
+	if !isUserKey(ObserveResponse{Key: "key1"}) {
+		t.Error("isUserKey expected true for key1")
+	}
+	for _, key := range []string{ExpiryKeyPrefix + "key1", VersionKeyPrefix + "key1", DeleteEventKey} {
+		if isUserKey(ObserveResponse{Key: key}) {
+			t.Errorf("isUserKey expected false for %s", key)
+		}
+	}
+}
+
 func TestKVJSONObserveKey(t *testing.T) {
 
 	doneChanWrite := make(chan int)
@@ -6,6 +6,8 @@ import (
 	"errors"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	zest "github.com/me-box/goZestClient"
@@ -14,10 +16,16 @@ import (
 //Func the databox function call, drivers can regiter functions with the Register method. Apps can request access to these in their manifests and call them using the call method Call.
 type Func struct {
 	csc                   *CoreStoreClient
-	funcRequestChan       chan FuncRequest
+	listener              *funcListener //shared with the copies made by WithSpan
+	lock                  *sync.Mutex
 	registeredFuncHandler map[string]FuncHandler
 }
 
+// funcListener holds the observe request receiving function requests, guarded by Func.lock
+type funcListener struct {
+	stop func()
+}
+
 //FuncStatus is an int representing the status of a returned function
 type FuncStatus int
 
@@ -46,7 +54,8 @@ type FuncHandler = func(contentType StoreContentType, payload []byte) ([]byte, e
 func newFunc(csc *CoreStoreClient) *Func {
 	return &Func{
 		csc:                   csc,
-		funcRequestChan:       nil,
+		listener:              &funcListener{},
+		lock:                  &sync.Mutex{},
 		registeredFuncHandler: make(map[string]FuncHandler),
 	}
 }
@@ -81,26 +90,68 @@ func (f *Func) Register(vendor string, functionName string, contentType StoreCon
 	//register the FuncHandler
 	f.registeredFuncHandler[functionName] = handler
 
-	//create FuncRequestChan by observing /notification/request/functionName/*
-	//start go routine to process events, if we have not started one already.
-	if f.funcRequestChan == nil {
-		rawRequestChan, err := f.csc.observe("/notification/request/*", ContentTypeJSON, zest.ObserveModeNotification)
+	//observe /notification/request/* and start go routine to process events, if we have not started one already.
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.listener.stop == nil {
+		rawRequestChan, stop, err := f.csc.observeWithCancel("/notification/request/*", ContentTypeJSON, zest.ObserveModeNotification)
 		if err != nil {
 			return errors.New("Could not observe /notification/request/* you will not receive any requests")
 		}
+		err = f.csc.track(f.csc.calls)
+		if err != nil {
+			stop()
+			return errors.New("Unable to register function. " + err.Error())
+		}
+		f.listener.stop = stop
 		Debug("[Notifications] Setting up Observe on /notification/request/*")
-		go f.parseRawFuncRequest(rawRequestChan)
+		go func() {
+			defer f.csc.calls.Done()
+			f.parseRawFuncRequest(rawRequestChan)
+		}()
 	}
 	return nil
 }
 
+// stopListening stops receiving function requests, requests already received are still processed.
+func (f *Func) stopListening() {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.listener.stop != nil {
+		f.listener.stop()
+	}
+}
+
 // Call is used by clients to invoke functions by name. The
 // result of the function call is returned via the FuncResponse chan
 // only one result will be retuned then the channel will be closed.
 func (f Func) Call(functionName string, payload []byte, contentType StoreContentType) (<-chan FuncResponse, error) {
 
-	responseChan := make(chan FuncResponse)
-	go f.parseRawFuncResponse(functionName, payload, contentType, responseChan)
+	err := f.csc.track(f.csc.calls)
+	if err != nil {
+		return nil, err
+	}
+
+	//the span context is only sent to the function in an envelope around the payload when the call is part
+	//of a trace started with WithSpan so functions that do not expect it see the payload unchanged
+	span := startSpan(f.csc.exporter.get(), "func.call", f.csc.parentSpan, map[string]string{"function": functionName, "store": f.csc.ZEndpoint})
+	if span != nil {
+		if f.csc.parentSpan.IsValid() {
+			payload = wrapFuncPayload(span.context(), payload)
+		}
+		f.csc = f.csc.WithSpan(span.context())
+	}
+
+	responseChan := make(chan FuncResponse, 1)
+	go func() {
+		defer f.csc.calls.Done()
+		defer close(responseChan)
+		start := time.Now()
+		resp := f.parseRawFuncResponse(functionName, payload, contentType)
+		f.recordFunc(MetricFuncCalls, MetricFuncCallDuration, functionName, resp, start, span)
+		responseChan <- resp
+	}()
 	return responseChan, nil
 
 }
@@ -143,6 +194,10 @@ func (f *Func) parseRawFuncRequest(rawRequest <-chan ObserveResponse) {
 
 			//we have a registered function call it ;-)
 			Debug("[Notifications] Calling registered function " + functionName)
+			start := time.Now()
+			var caller SpanContext
+			payload, caller = unwrapFuncPayload(payload)
+			span := startSpan(f.csc.exporter.get(), "func.handle", caller, map[string]string{"function": functionName, "store": f.csc.ZEndpoint})
 			responseData, funcErr := f.registeredFuncHandler[functionName](contentType, payload)
 
 			//Send response to caller
@@ -154,9 +209,14 @@ func (f *Func) parseRawFuncRequest(rawRequest <-chan ObserveResponse) {
 				resp.Status = FuncStatusError
 				resp.Response = []byte(funcErr.Error())
 			}
+			f.recordFunc(MetricFuncRequestsHandled, MetricFuncHandleDuration, functionName, resp, start, span)
 			respJson, _ := json.Marshal(resp)
 			Debug("[Notifications] Sending response to caller on " + responsePath + " data: " + string(respJson))
-			err := f.csc.write(responsePath, respJson, contentType)
+			csc := f.csc
+			if span != nil {
+				csc = csc.WithSpan(span.context())
+			}
+			err := csc.write(responsePath, respJson, contentType)
 			if err != nil {
 				Err("Writing request to " + responsePath)
 			}
@@ -168,32 +228,47 @@ func (f *Func) parseRawFuncRequest(rawRequest <-chan ObserveResponse) {
 
 }
 
-func (f *Func) parseRawFuncResponse(functionName string, payload []byte, contentType StoreContentType, responseChan chan FuncResponse) {
+// recordFunc records a function call or handled request that started at start and ends its span
+func (f *Func) recordFunc(counter string, histogram string, functionName string, resp FuncResponse, start time.Time, span *activeSpan) {
+
+	statusLabel := "ok"
+	var err error
+	if resp.Status != FuncStatusOK {
+		statusLabel = "error"
+		err = errors.New(string(resp.Response))
+	}
+
+	metrics := f.csc.metrics.get()
+	metrics.AddCounter(counter, MetricLabels{"function": functionName, "status": statusLabel}, 1)
+	metrics.ObserveDuration(histogram, MetricLabels{"function": functionName}, time.Since(start))
+
+	span.end(err)
+}
+
+// parseRawFuncResponse calls functionName and waits for its response
+func (f *Func) parseRawFuncResponse(functionName string, payload []byte, contentType StoreContentType) FuncResponse {
 
 	jobID := uuid.New().String()
 
 	//set up a channel to receive the result
-	NotifyResponseChan, doneChan, err := f.csc.notify("/notification/response/"+functionName+"/"+jobID, contentType)
+	NotifyResponseChan, stopNotify, err := f.csc.notify("/notification/response/"+functionName+"/"+jobID, contentType)
 	if err != nil {
-		responseChan <- FuncResponse{
+		return FuncResponse{
 			Status:   FuncStatusError,
 			Response: []byte(`[Error] failed setup notification functionName for /notification/response/` + functionName + `/` + jobID + `. ` + err.Error()),
 		}
-		close(doneChan)
-		return
 	}
+	defer stopNotify()
 	Debug("[Notifications] Setting up notify on /notification/response/" + functionName + "/" + jobID)
 
 	//call the function
 	Debug("[Notifications] Calling /notification/request/" + functionName + "/" + jobID + " with payload: " + string(payload))
 	err = f.csc.write("/notification/request/"+functionName+"/"+jobID, payload, contentType)
 	if err != nil {
-		responseChan <- FuncResponse{
+		return FuncResponse{
 			Status:   FuncStatusError,
 			Response: []byte(`[Error] failed to call to ` + functionName + " " + err.Error()),
 		}
-		close(doneChan)
-		return
 	}
 
 	//block and await the response
@@ -203,18 +278,13 @@ func (f *Func) parseRawFuncResponse(functionName string, payload []byte, content
 	var funcResp FuncResponse
 	err = json.Unmarshal(response.Data, &funcResp)
 	if err != nil {
-		responseChan <- FuncResponse{
+		return FuncResponse{
 			Status:   FuncStatusError,
 			Response: []byte(`[Error] failed to decode response from ` + functionName + " " + err.Error()),
 		}
-		close(doneChan)
-		return
 	}
 
 	Debug("funcResp.Response " + string(funcResp.Response))
 
-	//send the result to the caller
-	responseChan <- funcResp
-	close(doneChan)
-	return
+	return funcResp
 }
@@ -55,7 +55,12 @@ func (tsc TSStore) Write(dataSourceID string, payload []byte) error {
 
 	path := "/ts/" + dataSourceID
 
-	return tsc.csc.write(path, payload, ContentTypeJSON)
+	err := tsc.csc.write(path, payload, ContentTypeJSON)
+	if err == nil {
+		tsc.csc.cache.get().invalidate(tsc.cacheWatch(dataSourceID), "")
+	}
+
+	return err
 
 }
 
@@ -74,6 +79,7 @@ func (tsc TSStore) WriteAt(dataSourceID string, timstamp int64, payload []byte)
 		tsc.csc.Arbiter.InvalidateCache(tsc.csc.ZEndpoint+path+"*", "POST", "")
 		return errors.New("Error writing: " + err.Error())
 	}
+	tsc.csc.cache.get().invalidate(tsc.cacheWatch(dataSourceID), "")
 
 	return nil
 
@@ -81,11 +87,14 @@ func (tsc TSStore) WriteAt(dataSourceID string, timstamp int64, payload []byte)
 
 //Latest will retrieve the last entry stored at the requested datasource ID
 // return data is a JSON object of the format {"timestamp":213123123,"data":[data-written-by-driver]}
+// If the read cache is enabled the value is served from it when possible.
 func (tsc TSStore) Latest(dataSourceID string) ([]byte, error) {
 
 	path := "/ts/" + dataSourceID + "/latest"
 
-	return tsc.csc.read(path, ContentTypeJSON)
+	return tsc.csc.cache.get().read(tsc.cacheWatch(dataSourceID), path, func() ([]byte, error) {
+		return tsc.csc.read(path, ContentTypeJSON)
+	})
 
 }
 
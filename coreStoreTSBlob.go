@@ -25,7 +25,12 @@ func (tbs *TSBlobStore) Write(dataSourceID string, payload []byte) error {
 
 	path := "/ts/blob/" + dataSourceID
 
-	return tbs.csc.write(path, payload, tbs.contentType)
+	err := tbs.csc.write(path, payload, tbs.contentType)
+	if err == nil {
+		tbs.csc.cache.get().invalidate(tbs.cacheWatch(dataSourceID), "")
+	}
+
+	return err
 
 }
 
@@ -47,6 +52,7 @@ func (tbs *TSBlobStore) WriteAt(dataSourceID string, timstamp int64, payload []b
 		tbs.csc.Arbiter.InvalidateCache(tbs.csc.ZEndpoint+path+"*", "POST", "")
 		return errors.New("Error writing: " + err.Error())
 	}
+	tbs.csc.cache.get().invalidate(tbs.cacheWatch(dataSourceID), "")
 
 	return nil
 
@@ -55,11 +61,14 @@ func (tbs *TSBlobStore) WriteAt(dataSourceID string, timstamp int64, payload []b
 //TSBlobLatest will retrieve the last entry stored at the requested datasource ID
 // return data is a byte array contingin  of the format
 // {"timestamp":213123123,"data":[data-written-by-driver]}
+// If the read cache is enabled the value is served from it when possible.
 func (tbs *TSBlobStore) Latest(dataSourceID string) ([]byte, error) {
 
 	path := "/ts/blob/" + dataSourceID + "/latest"
 
-	return tbs.csc.read(path, tbs.contentType)
+	return tbs.csc.cache.get().read(tbs.cacheWatch(dataSourceID), path, func() ([]byte, error) {
+		return tbs.csc.read(path, tbs.contentType)
+	})
 
 }
 
@@ -0,0 +1,206 @@
+package libDatabox
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultTSIteratorWindow is the time range read by each request of a TSIterator if no window is given
+const DefaultTSIteratorWindow = time.Hour
+
+// TSIteratorOptions sets how a TSIterator splits its time range into requests. Each request reads
+// Window of data. If PageSize > 0 the window is resized after each request to aim for about PageSize
+// records per request, use it when the rate the data was written at is not known.
+//
+// PageSize is only a target, the store has no limit on range requests so all the records in a window
+// are returned together. A burst of records inside one window comes back as one large page, only the
+// requests after it are shrunk. Use a small Window as well if bursts must not be read at once.
+type TSIteratorOptions struct {
+	Window   time.Duration
+	PageSize int
+}
+
+// TSRecord is a single record read by a TSIterator, Data is left encoded until Decode is called
+type TSRecord struct {
+	TimestampMS int64           `json:"timestamp"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// Decode unmarshals the data of the record into v
+func (r TSRecord) Decode(v interface{}) error {
+	return json.Unmarshal(r.Data, v)
+}
+
+// TSIterator reads a time range a window at a time and returns its records oldest first. Only one
+// window of records is held in memory. Use it like
+//
+//	it := StoreClient.TSBlobJSON.RangeIterator(dsID, from, to, TSIteratorOptions{})
+//	defer it.Close()
+//	for it.Next() {
+//		record := it.Record()
+//	}
+//	if it.Err() != nil {
+//		...
+//	}
+type TSIterator struct {
+	fetch    func(from int64, to int64) ([]byte, error)
+	from     int64 //start of the next window
+	to       int64 //end of the range, inclusive
+	window   int64 //ms
+	pageSize int
+	page     []TSRecord
+	record   TSRecord
+	err      error
+
+	done      chan struct{}
+	closeOnce *sync.Once
+}
+
+func newTSIterator(from int64, to int64, opts TSIteratorOptions, fetch func(from int64, to int64) ([]byte, error)) *TSIterator {
+
+	window := int64(opts.Window / time.Millisecond)
+	if window <= 0 {
+		window = int64(DefaultTSIteratorWindow / time.Millisecond)
+	}
+
+	return &TSIterator{
+		fetch:     fetch,
+		from:      from,
+		to:        to,
+		window:    window,
+		pageSize:  opts.PageSize,
+		page:      []TSRecord{},
+		done:      make(chan struct{}),
+		closeOnce: &sync.Once{},
+	}
+}
+
+// RangeIterator returns an iterator over the records between fromTimeStamp and toTimeStamp in ms since unix epoch
+func (tbs *TSBlobStore) RangeIterator(dataSourceID string, fromTimeStamp int64, toTimeStamp int64, opts TSIteratorOptions) *TSIterator {
+	return newTSIterator(fromTimeStamp, toTimeStamp, opts, func(from int64, to int64) ([]byte, error) {
+		return tbs.Range(dataSourceID, from, to)
+	})
+}
+
+// SinceIterator returns an iterator over the records since sinceTimeStamp, records written after the
+// iterator is created are not included
+func (tbs *TSBlobStore) SinceIterator(dataSourceID string, sinceTimeStamp int64, opts TSIteratorOptions) *TSIterator {
+	return tbs.RangeIterator(dataSourceID, sinceTimeStamp, time.Now().UnixNano()/int64(time.Millisecond), opts)
+}
+
+// RangeIterator returns an iterator over the records between fromTimeStamp and toTimeStamp in ms since unix epoch.
+// opt can filter the records, aggregations are not supported.
+func (tsc TSStore) RangeIterator(dataSourceID string, fromTimeStamp int64, toTimeStamp int64, opt TimeSeriesQueryOptions, opts TSIteratorOptions) *TSIterator {
+
+	it := newTSIterator(fromTimeStamp, toTimeStamp, opts, func(from int64, to int64) ([]byte, error) {
+		return tsc.Range(dataSourceID, from, to, opt)
+	})
+	if opt.AggregationFunction != "" {
+		it.err = errors.New("Aggregation " + string(opt.AggregationFunction) + " can not be used with an iterator")
+	}
+
+	return it
+}
+
+// SinceIterator returns an iterator over the records since sinceTimeStamp, records written after the
+// iterator is created are not included. opt can filter the records, aggregations are not supported.
+func (tsc TSStore) SinceIterator(dataSourceID string, sinceTimeStamp int64, opt TimeSeriesQueryOptions, opts TSIteratorOptions) *TSIterator {
+	return tsc.RangeIterator(dataSourceID, sinceTimeStamp, time.Now().UnixNano()/int64(time.Millisecond), opt, opts)
+}
+
+// Next moves to the next record reading the next window from the store if needed. It returns false
+// when there are no more records, an error occurred or the iterator was closed.
+func (it *TSIterator) Next() bool {
+
+	for {
+		if it.err != nil || it.isClosed() {
+			return false
+		}
+		if len(it.page) > 0 {
+			it.record = it.page[0]
+			it.page = it.page[1:]
+			return true
+		}
+		if it.from > it.to {
+			return false
+		}
+		it.err = it.readWindow()
+	}
+}
+
+// Record returns the current record
+func (it *TSIterator) Record() TSRecord {
+	return it.record
+}
+
+// Err returns the error that stopped the iterator, if any
+func (it *TSIterator) Err() error {
+	return it.err
+}
+
+// Close stops the iterator, Next returns false once the current request has finished. It is safe
+// to call from another goroutine.
+func (it *TSIterator) Close() {
+	it.closeOnce.Do(func() {
+		close(it.done)
+	})
+}
+
+func (it *TSIterator) isClosed() bool {
+	select {
+	case <-it.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// readWindow reads the next window into page and moves from past it
+func (it *TSIterator) readWindow() error {
+
+	end := it.from + it.window - 1
+	if end > it.to || end < it.from {
+		end = it.to
+	}
+
+	data, err := it.fetch(it.from, end)
+	if err != nil {
+		return err
+	}
+
+	page := []TSRecord{}
+	err = json.Unmarshal(data, &page)
+	if err != nil {
+		return errors.New("Error decoding records: " + err.Error())
+	}
+	sort.SliceStable(page, func(i, j int) bool {
+		return page[i].TimestampMS < page[j].TimestampMS
+	})
+
+	it.page = page
+	it.from = end + 1
+	it.resize(len(page))
+
+	return nil
+}
+
+// resize changes the window to aim for pageSize records in the next request, the page just read is not split
+func (it *TSIterator) resize(records int) {
+
+	if it.pageSize <= 0 {
+		return
+	}
+
+	switch {
+	case records > it.pageSize:
+		it.window = it.window * int64(it.pageSize) / int64(records)
+		if it.window < 1 {
+			it.window = 1
+		}
+	case records*2 < it.pageSize && it.window <= it.to-it.from:
+		it.window *= 2
+	}
+}
@@ -0,0 +1,143 @@
+package libDatabox
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRange returns a record every ms between from and to newest first like the store
+func fakeRange(requests *[][2]int64) func(from int64, to int64) ([]byte, error) {
+	return func(from int64, to int64) ([]byte, error) {
+		*requests = append(*requests, [2]int64{from, to})
+		records := []string{}
+		for ts := to; ts >= from; ts-- {
+			records = append(records, `{"timestamp":`+strconv.FormatInt(ts, 10)+`,"data":{"value":`+strconv.FormatInt(ts, 10)+`}}`)
+		}
+		return []byte("[" + strings.Join(records, ",") + "]"), nil
+	}
+}
+
+func TestTSIteratorWindows(t *testing.T) {
+
+	requests := [][2]int64{}
+	it := newTSIterator(100, 124, TSIteratorOptions{Window: 10 * time.Millisecond}, fakeRange(&requests))
+	defer it.Close()
+
+	expected := int64(100)
+	for it.Next() {
+		record := it.Record()
+		value := struct {
+			Value int64 `json:"value"`
+		}{}
+		err := record.Decode(&value)
+		if err != nil || record.TimestampMS != expected || value.Value != expected {
+			t.Fatalf("Next expected record %d got %+v %v", expected, record, err)
+		}
+		expected++
+	}
+
+	if it.Err() != nil {
+		t.Errorf("Err expected nil got %s", it.Err().Error())
+	}
+	if expected != 125 {
+		t.Errorf("Next expected records up to 124 got %d", expected-1)
+	}
+	if len(requests) != 3 || requests[0] != [2]int64{100, 109} || requests[2] != [2]int64{120, 124} {
+		t.Errorf("iterator expected 3 windows got %v", requests)
+	}
+}
+
+func TestTSIteratorPageSize(t *testing.T) {
+
+	requests := [][2]int64{}
+	it := newTSIterator(0, 999, TSIteratorOptions{Window: 100 * time.Millisecond, PageSize: 10}, fakeRange(&requests))
+	defer it.Close()
+
+	it.Next()
+	it.Next()
+	if it.window != 10 {
+		t.Errorf("iterator expected the window to shrink to 10ms got %d", it.window)
+	}
+
+	count := 2
+	for it.Next() {
+		count++
+	}
+	if count != 1000 || len(requests) != 91 {
+		t.Errorf("iterator expected 1000 records in 91 requests got %d in %d", count, len(requests))
+	}
+
+	sparse := newTSIterator(0, 999, TSIteratorOptions{Window: time.Millisecond, PageSize: 10}, func(from int64, to int64) ([]byte, error) {
+		return []byte("[]"), nil
+	})
+	sparse.Next()
+	if sparse.window != 512 {
+		t.Errorf("iterator expected the window to grow while records are sparse got %d", sparse.window)
+	}
+}
+
+func TestTSIteratorClose(t *testing.T) {
+
+	requests := [][2]int64{}
+	it := newTSIterator(0, 99, TSIteratorOptions{Window: 10 * time.Millisecond}, fakeRange(&requests))
+
+	it.Next()
+	it.Close()
+	it.Close()
+
+	if it.Next() {
+		t.Error("Next after Close expected false")
+	}
+	if len(requests) != 1 {
+		t.Errorf("iterator expected no requests after Close got %d", len(requests))
+	}
+}
+
+func TestTSIteratorErrors(t *testing.T) {
+
+	it := newTSIterator(0, 99, TSIteratorOptions{}, func(from int64, to int64) ([]byte, error) {
+		return nil, errors.New("no store")
+	})
+	if it.Next() || it.Err() == nil || it.Err().Error() != "no store" {
+		t.Errorf("Next expected the fetch error got %v", it.Err())
+	}
+
+	it = newTSIterator(0, 99, TSIteratorOptions{}, func(from int64, to int64) ([]byte, error) {
+		return []byte("not json"), nil
+	})
+	if it.Next() || it.Err() == nil {
+		t.Error("Next expected a decoding error")
+	}
+
+	it = StoreClient.TSJSON.RangeIterator(dsID, 0, 99, TimeSeriesQueryOptions{AggregationFunction: Mean}, TSIteratorOptions{})
+	if it.Next() || it.Err() == nil {
+		t.Error("RangeIterator with an aggregation expected an error")
+	}
+}
+
+func TestTSBlobRangeIterator(t *testing.T) {
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	_dsID := dsID + "TestTSBlobRangeIterator"
+
+	for i := 1; i <= 20; i++ {
+		err := StoreClient.TSBlobJSON.WriteAt(_dsID, now+int64(50*i), []byte(`{"value":`+strconv.Itoa(i)+`}`))
+		if err != nil {
+			t.Fatalf("WriteAt to %s failed expected err to be nil got %s", _dsID, err.Error())
+		}
+	}
+
+	it := StoreClient.TSBlobJSON.RangeIterator(_dsID, now, now+1000, TSIteratorOptions{Window: 200 * time.Millisecond})
+	defer it.Close()
+
+	count := 0
+	for it.Next() {
+		count++
+	}
+	if it.Err() != nil || count != 20 {
+		t.Errorf("RangeIterator expected 20 records got %d %v", count, it.Err())
+	}
+}
@@ -0,0 +1,241 @@
+package libDatabox
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRetentionInterval is how often a RetentionManager enforces its policies if no interval is given
+const DefaultRetentionInterval = time.Hour
+
+// retentionPageSize is the number of records read per request when counting records for MaxLength
+const retentionPageSize = 1000
+
+// DeleteRange deletes the records between fromTimeStamp and toTimeStamp in ms since unix epoch inclusive.
+// Stores that do not support deleting time series data return an error.
+func (tbs *TSBlobStore) DeleteRange(dataSourceID string, fromTimeStamp int64, toTimeStamp int64) error {
+
+	path := "/ts/blob/" + dataSourceID + "/range/" + strconv.FormatInt(fromTimeStamp, 10) + "/" + strconv.FormatInt(toTimeStamp, 10)
+
+	err := tbs.csc.delete(path, tbs.contentType)
+	if err == nil {
+		tbs.csc.cache.get().invalidate(tbs.cacheWatch(dataSourceID), "")
+	}
+
+	return err
+}
+
+// DeleteBefore deletes the records older than timeStamp in ms since unix epoch
+func (tbs *TSBlobStore) DeleteBefore(dataSourceID string, timeStamp int64) error {
+	return tbs.DeleteRange(dataSourceID, 0, timeStamp-1)
+}
+
+// DeleteRange deletes the records between fromTimeStamp and toTimeStamp in ms since unix epoch inclusive.
+// Stores that do not support deleting time series data return an error.
+func (tsc TSStore) DeleteRange(dataSourceID string, fromTimeStamp int64, toTimeStamp int64) error {
+
+	path := "/ts/" + dataSourceID + "/range/" + strconv.FormatInt(fromTimeStamp, 10) + "/" + strconv.FormatInt(toTimeStamp, 10)
+
+	err := tsc.csc.delete(path, ContentTypeJSON)
+	if err == nil {
+		tsc.csc.cache.get().invalidate(tsc.cacheWatch(dataSourceID), "")
+	}
+
+	return err
+}
+
+// DeleteBefore deletes the records older than timeStamp in ms since unix epoch
+func (tsc TSStore) DeleteBefore(dataSourceID string, timeStamp int64) error {
+	return tsc.DeleteRange(dataSourceID, 0, timeStamp-1)
+}
+
+// RetentionStore is a time series store a RetentionManager can delete from, *TSBlobStore and TSStore implement it
+type RetentionStore interface {
+	Length(dataSourceID string) (int, error)
+	Earliest(dataSourceID string) ([]byte, error)
+	DeleteRange(dataSourceID string, fromTimeStamp int64, toTimeStamp int64) error
+	retentionIterator(dataSourceID string, fromTimeStamp int64, toTimeStamp int64) *TSIterator
+}
+
+func (tbs *TSBlobStore) retentionIterator(dataSourceID string, fromTimeStamp int64, toTimeStamp int64) *TSIterator {
+	return tbs.RangeIterator(dataSourceID, fromTimeStamp, toTimeStamp, TSIteratorOptions{PageSize: retentionPageSize})
+}
+
+func (tsc TSStore) retentionIterator(dataSourceID string, fromTimeStamp int64, toTimeStamp int64) *TSIterator {
+	return tsc.RangeIterator(dataSourceID, fromTimeStamp, toTimeStamp, TimeSeriesQueryOptions{}, TSIteratorOptions{PageSize: retentionPageSize})
+}
+
+// RetentionPolicy limits how much data is kept in a datasource. Records older than MaxAge are deleted
+// and the oldest records are deleted to keep at most MaxLength, a zero value does not limit that.
+// Records are deleted by time so a few more than MaxLength may be kept if several share a timestamp.
+type RetentionPolicy struct {
+	DataSourceID string
+	MaxAge       time.Duration
+	MaxLength    int
+}
+
+// RetentionManager periodically deletes the records of time series datasources that are outside their policies
+type RetentionManager struct {
+	store     RetentionStore
+	policies  []RetentionPolicy
+	interval  time.Duration
+	done      chan struct{}
+	closeOnce *sync.Once
+}
+
+// NewRetentionManager starts enforcing policies on store every interval
+func NewRetentionManager(store RetentionStore, policies []RetentionPolicy, interval time.Duration) *RetentionManager {
+
+	if interval <= 0 {
+		interval = DefaultRetentionInterval
+	}
+
+	rm := &RetentionManager{
+		store:     store,
+		policies:  policies,
+		interval:  interval,
+		done:      make(chan struct{}),
+		closeOnce: &sync.Once{},
+	}
+
+	go rm.enforceLoop()
+
+	return rm
+}
+
+// Enforce applies all the policies now
+func (rm *RetentionManager) Enforce() error {
+
+	failed := []string{}
+	for _, policy := range rm.policies {
+		err := rm.enforce(policy, time.Now())
+		if err != nil {
+			failed = append(failed, policy.DataSourceID+" ("+err.Error()+")")
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New("Error enforcing retention policies: " + strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// Close stops the manager
+func (rm *RetentionManager) Close() {
+	rm.closeOnce.Do(func() {
+		close(rm.done)
+	})
+}
+
+func (rm *RetentionManager) enforceLoop() {
+
+	ticker := time.NewTicker(rm.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rm.done:
+			return
+		case <-ticker.C:
+			err := rm.Enforce()
+			if err != nil {
+				Warn("[RetentionManager] " + err.Error())
+			}
+		}
+	}
+}
+
+// enforce deletes the records of policy.DataSourceID older than the first record it should keep
+func (rm *RetentionManager) enforce(policy RetentionPolicy, now time.Time) error {
+
+	data, err := rm.store.Earliest(policy.DataSourceID)
+	if err != nil {
+		return err
+	}
+	earliest, ok, err := parseRecordTimestamp(data)
+	if err != nil || !ok {
+		return err
+	}
+
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+	keepFrom := int64(0)
+	if policy.MaxAge > 0 {
+		keepFrom = nowMs - int64(policy.MaxAge/time.Millisecond)
+	}
+
+	if policy.MaxLength > 0 {
+		length, err := rm.store.Length(policy.DataSourceID)
+		if err != nil {
+			return err
+		}
+		if length > policy.MaxLength {
+			ts, err := rm.nthTimestamp(policy.DataSourceID, earliest, nowMs, length-policy.MaxLength)
+			if err != nil {
+				return err
+			}
+			if ts > keepFrom {
+				keepFrom = ts
+			}
+		}
+	}
+
+	if keepFrom <= earliest {
+		return nil
+	}
+
+	return rm.store.DeleteRange(policy.DataSourceID, earliest, keepFrom-1)
+}
+
+// nthTimestamp returns the timestamp of the record after the first n records from earliest, 0 is
+// returned if there are not that many records
+func (rm *RetentionManager) nthTimestamp(dataSourceID string, earliest int64, nowMs int64, n int) (int64, error) {
+
+	it := rm.store.retentionIterator(dataSourceID, earliest, nowMs)
+	defer it.Close()
+
+	count := 0
+	for it.Next() {
+		if count == n {
+			return it.Record().TimestampMS, nil
+		}
+		count++
+	}
+
+	return 0, it.Err()
+}
+
+// parseRecordTimestamp returns the timestamp of the record returned by Earliest or Latest, ok is false if the datasource is empty
+func parseRecordTimestamp(data []byte) (int64, bool, error) {
+
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return 0, false, nil
+	}
+
+	records := []TSRecord{}
+	if data[0] == '[' {
+		err := json.Unmarshal(data, &records)
+		if err != nil {
+			return 0, false, errors.New("Error decoding record: " + err.Error())
+		}
+	} else {
+		record := TSRecord{}
+		err := json.Unmarshal(data, &record)
+		if err != nil {
+			return 0, false, errors.New("Error decoding record: " + err.Error())
+		}
+		records = append(records, record)
+	}
+
+	if len(records) == 0 || records[0].TimestampMS == 0 {
+		return 0, false, nil
+	}
+
+	return records[0].TimestampMS, true, nil
+}
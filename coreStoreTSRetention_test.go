@@ -0,0 +1,125 @@
+package libDatabox
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRetentionStore keeps the timestamps of its records oldest first
+type fakeRetentionStore struct {
+	records []int64
+	deletes [][2]int64
+}
+
+func (s *fakeRetentionStore) Length(dataSourceID string) (int, error) {
+	return len(s.records), nil
+}
+
+func (s *fakeRetentionStore) Earliest(dataSourceID string) ([]byte, error) {
+	if len(s.records) == 0 {
+		return []byte("[]"), nil
+	}
+	return []byte(`{"timestamp":` + strconv.FormatInt(s.records[0], 10) + `,"data":{}}`), nil
+}
+
+func (s *fakeRetentionStore) DeleteRange(dataSourceID string, from int64, to int64) error {
+	s.deletes = append(s.deletes, [2]int64{from, to})
+	kept := []int64{}
+	for _, ts := range s.records {
+		if ts < from || ts > to {
+			kept = append(kept, ts)
+		}
+	}
+	s.records = kept
+	return nil
+}
+
+func (s *fakeRetentionStore) retentionIterator(dataSourceID string, from int64, to int64) *TSIterator {
+	return newTSIterator(from, to, TSIteratorOptions{PageSize: 2}, func(from int64, to int64) ([]byte, error) {
+		records := []string{}
+		for _, ts := range s.records {
+			if ts >= from && ts <= to {
+				records = append(records, `{"timestamp":`+strconv.FormatInt(ts, 10)+`,"data":{}}`)
+			}
+		}
+		return []byte("[" + strings.Join(records, ",") + "]"), nil
+	})
+}
+
+func TestRetentionMaxAge(t *testing.T) {
+
+	now := time.Unix(1000, 0)
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+	store := &fakeRetentionStore{records: []int64{nowMs - 5000, nowMs - 3000, nowMs - 1000}}
+	rm := &RetentionManager{store: store}
+
+	err := rm.enforce(RetentionPolicy{DataSourceID: "ds", MaxAge: 2 * time.Second}, now)
+	if err != nil {
+		t.Fatalf("enforce failed expected err to be nil got %s", err.Error())
+	}
+	if len(store.records) != 1 || store.records[0] != nowMs-1000 {
+		t.Errorf("enforce expected only the newest record to be kept got %v", store.records)
+	}
+
+	err = rm.enforce(RetentionPolicy{DataSourceID: "ds", MaxAge: 2 * time.Second}, now)
+	if err != nil || len(store.deletes) != 1 {
+		t.Errorf("enforce expected no delete when nothing is too old got %v %v", store.deletes, err)
+	}
+}
+
+func TestRetentionMaxLength(t *testing.T) {
+
+	store := &fakeRetentionStore{records: []int64{10, 20, 30, 40, 50, 60, 70}}
+	rm := &RetentionManager{store: store}
+
+	err := rm.enforce(RetentionPolicy{DataSourceID: "ds", MaxLength: 3}, time.Unix(1000, 0))
+	if err != nil {
+		t.Fatalf("enforce failed expected err to be nil got %s", err.Error())
+	}
+	if len(store.deletes) != 1 || store.deletes[0] != [2]int64{10, 49} {
+		t.Errorf("enforce expected records 10 to 49 to be deleted got %v", store.deletes)
+	}
+	if len(store.records) != 3 {
+		t.Errorf("enforce expected 3 records to be kept got %v", store.records)
+	}
+}
+
+func TestRetentionEmpty(t *testing.T) {
+
+	store := &fakeRetentionStore{}
+	rm := NewRetentionManager(store, []RetentionPolicy{{DataSourceID: "ds", MaxAge: time.Second, MaxLength: 1}}, time.Hour)
+	defer rm.Close()
+
+	err := rm.Enforce()
+	if err != nil || len(store.deletes) != 0 {
+		t.Errorf("Enforce on an empty datasource expected no deletes got %v %v", store.deletes, err)
+	}
+}
+
+func TestParseRecordTimestamp(t *testing.T) {
+
+	tests := []struct {
+		data string
+		ts   int64
+		ok   bool
+	}{
+		{`{"timestamp":12,"data":{"v":1}}`, 12, true},
+		{`[{"timestamp":13,"data":{"v":1}}]`, 13, true},
+		{`[]`, 0, false},
+		{`{}`, 0, false},
+		{``, 0, false},
+	}
+	for _, test := range tests {
+		ts, ok, err := parseRecordTimestamp([]byte(test.data))
+		if err != nil || ts != test.ts || ok != test.ok {
+			t.Errorf("parseRecordTimestamp(%s) expected %d %t got %d %t %v", test.data, test.ts, test.ok, ts, ok, err)
+		}
+	}
+
+	_, _, err := parseRecordTimestamp([]byte("not json"))
+	if err == nil {
+		t.Error("parseRecordTimestamp expected an error for invalid json")
+	}
+}
@@ -0,0 +1,446 @@
+package libDatabox
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	zest "github.com/me-box/goZestClient"
+)
+
+// rollupPageSize is the number of records read per request while catching up
+const rollupPageSize = 1000
+
+// RollupAggregations are the aggregations computed by a rollup if none are given
+var RollupAggregations = []AggregationType{Sum, Count, Min, Max, Mean, Median, StandardDeviation}
+
+// RollupSpec describes the aggregates to compute from a TSStore datasource. The records in each
+// Interval, aligned to the unix epoch, are aggregated and written to one derived datasource per
+// aggregation named by RollupDataSourceID. Each aggregate is written as {"value":x} timestamped
+// with the start of its interval.
+//
+// Metadata is used to register the derived datasources, its DataSourceID, StoreType and ContentType
+// are set by the engine and the aggregation is added to DataSourceType and Description.
+//
+// On start the intervals since the last aggregate written are computed from the stored records. If
+// nothing was written before catch up starts at the earliest record or MaxCatchUp ago if that is later.
+// The records already written in the current interval are read back so its aggregates include them.
+//
+// Each interval is written Lateness after it ends so records that arrive a little late are included,
+// records that arrive after that are dropped.
+type RollupSpec struct {
+	SourceID     string
+	Interval     time.Duration
+	Aggregations []AggregationType
+	Metadata     DataSourceMetadata
+	MaxCatchUp   time.Duration
+	Lateness     time.Duration
+}
+
+// RollupDataSourceID returns the id of the datasource holding the agg aggregates of sourceID
+func RollupDataSourceID(sourceID string, agg AggregationType, interval time.Duration) string {
+
+	suffix := strconv.FormatInt(int64(interval/time.Millisecond), 10) + "ms"
+	if interval%time.Second == 0 {
+		suffix = strconv.FormatInt(int64(interval/time.Second), 10) + "s"
+	}
+
+	return sourceID + "-" + string(agg) + "-" + suffix
+}
+
+// RollupEngine computes and stores rollups of TSStore datasources
+type RollupEngine struct {
+	csc       *CoreStoreClient
+	done      chan struct{}
+	closeOnce *sync.Once
+	running   *sync.WaitGroup
+}
+
+// NewRollupEngine returns an engine that reads and writes datasources with csc
+func NewRollupEngine(csc *CoreStoreClient) *RollupEngine {
+	return &RollupEngine{
+		csc:       csc,
+		done:      make(chan struct{}),
+		closeOnce: &sync.Once{},
+		running:   &sync.WaitGroup{},
+	}
+}
+
+// Start registers the derived datasources of spec, observes the source and catches up in the background
+func (re *RollupEngine) Start(spec RollupSpec) error {
+
+	if spec.SourceID == "" {
+		return errors.New("Invalid rollup: SourceID is required")
+	}
+	if spec.Interval < time.Millisecond {
+		return errors.New("Invalid rollup interval " + spec.Interval.String() + " for " + spec.SourceID)
+	}
+	if spec.Lateness < 0 {
+		return errors.New("Invalid rollup lateness " + spec.Lateness.String() + " for " + spec.SourceID)
+	}
+	if len(spec.Aggregations) == 0 {
+		spec.Aggregations = RollupAggregations
+	}
+	for _, agg := range spec.Aggregations {
+		if _, err := aggregate(agg, []float64{0}); err != nil {
+			return err
+		}
+	}
+
+	for _, agg := range spec.Aggregations {
+		metadata := spec.Metadata
+		metadata.DataSourceID = RollupDataSourceID(spec.SourceID, agg, spec.Interval)
+		metadata.DataSourceType = spec.Metadata.DataSourceType + "-" + string(agg)
+		metadata.Description = spec.Metadata.Description + " (" + string(agg) + " per " + spec.Interval.String() + ")"
+		metadata.StoreType = StoreTypeTS
+		metadata.ContentType = ContentTypeJSON
+		err := re.csc.RegisterDatasource(metadata)
+		if err != nil {
+			return errors.New("Error registering rollup " + metadata.DataSourceID + ": " + err.Error())
+		}
+	}
+
+	//observe before catching up so no records are missed between the two
+	observeChan, stop, err := re.csc.observeWithCancel("/ts/"+spec.SourceID, ContentTypeJSON, zest.ObserveModeData)
+	if err != nil {
+		return err
+	}
+
+	r := &rollup{csc: re.csc, spec: spec}
+
+	re.running.Add(1)
+	go func() {
+		defer re.running.Done()
+		defer stop()
+		r.run(observeChan, re.done)
+	}()
+
+	return nil
+}
+
+// Close stops all rollups and waits for them to finish, the aggregates of the intervals still open are not written
+func (re *RollupEngine) Close() {
+	re.closeOnce.Do(func() {
+		close(re.done)
+	})
+	re.running.Wait()
+}
+
+// rollup is a single running RollupSpec
+type rollup struct {
+	csc  *CoreStoreClient
+	spec RollupSpec
+}
+
+func (r *rollup) run(observeChan <-chan ObserveResponse, done <-chan struct{}) {
+
+	interval := int64(r.spec.Interval / time.Millisecond)
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	current := windowStart(now, interval)
+
+	err := r.catchUp(current, done)
+	if err != nil {
+		Warn("[RollupEngine] Error catching up " + r.spec.SourceID + ": " + err.Error())
+	}
+
+	acc := newRollupAccumulator(interval, int64(r.spec.Lateness/time.Millisecond), r.write)
+	acc.start = current
+
+	//the records of the current interval written before the observe started are only in the store,
+	//live events up to the last replayed record were already added
+	replayed, err := r.replay(acc, current, now, done)
+	if err != nil {
+		Warn("[RollupEngine] Error reading the current interval of " + r.spec.SourceID + ": " + err.Error())
+	}
+
+	timer := time.NewTimer(r.untilWindowEnd(acc))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case resp, ok := <-observeChan:
+			if !ok {
+				return
+			}
+			if resp.TimestampMS <= replayed {
+				continue
+			}
+			value, ok := rollupValue(resp.Data)
+			if ok {
+				acc.add(resp.TimestampMS, value)
+			}
+		case <-timer.C:
+			acc.advance(time.Now().UnixNano() / int64(time.Millisecond))
+			timer.Reset(r.untilWindowEnd(acc))
+		}
+	}
+}
+
+// untilWindowEnd returns how long until the oldest open interval is written
+func (r *rollup) untilWindowEnd(acc *rollupAccumulator) time.Duration {
+	end := time.Unix(0, (acc.start+acc.interval+acc.lateness)*int64(time.Millisecond))
+	return time.Until(end)
+}
+
+// catchUp computes the intervals from the last one written up to the interval starting at until,
+// it stops without writing the interval it was reading once done is closed
+func (r *rollup) catchUp(until int64, done <-chan struct{}) error {
+
+	interval := int64(r.spec.Interval / time.Millisecond)
+
+	from, err := r.catchUpFrom(interval)
+	if err != nil || from >= until {
+		return err
+	}
+
+	acc := newRollupAccumulator(interval, 0, r.write)
+	acc.start = windowStart(from, interval)
+
+	_, err = r.replay(acc, from, until-1, done)
+	if err != nil || isDone(done) {
+		return err
+	}
+	acc.advance(until)
+
+	return nil
+}
+
+// replay adds the stored records between from and to inclusive to acc
+func (r *rollup) replay(acc *rollupAccumulator, from int64, to int64, done <-chan struct{}) (int64, error) {
+
+	it := r.csc.TSJSON.RangeIterator(r.spec.SourceID, from, to, TimeSeriesQueryOptions{}, TSIteratorOptions{PageSize: rollupPageSize})
+	defer it.Close()
+
+	return replayRecords(acc, it, done)
+}
+
+// replayRecords adds the records of it to acc and returns the timestamp of the last one, 0 if there
+// were none. The iterator is closed if done is closed first.
+func replayRecords(acc *rollupAccumulator, it *TSIterator, done <-chan struct{}) (int64, error) {
+
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-done:
+			it.Close()
+		case <-finished:
+		}
+	}()
+
+	last := int64(0)
+	for it.Next() {
+		last = it.Record().TimestampMS
+		value, ok := rollupValue(it.Record().Data)
+		if ok {
+			acc.add(last, value)
+		}
+	}
+
+	return last, it.Err()
+}
+
+func isDone(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// catchUpFrom returns the start of the first interval that has not been written
+func (r *rollup) catchUpFrom(interval int64) (int64, error) {
+
+	latest, err := r.csc.TSJSON.Latest(RollupDataSourceID(r.spec.SourceID, r.spec.Aggregations[0], r.spec.Interval))
+	if err != nil {
+		return 0, err
+	}
+	last, ok, err := parseRecordTimestamp(latest)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		return last + interval, nil
+	}
+
+	earliest, err := r.csc.TSJSON.Earliest(r.spec.SourceID)
+	if err != nil {
+		return 0, err
+	}
+	from, ok, err := parseRecordTimestamp(earliest)
+	if err != nil || !ok {
+		return math.MaxInt64, err
+	}
+
+	if r.spec.MaxCatchUp > 0 {
+		limit := time.Now().Add(-r.spec.MaxCatchUp).UnixNano() / int64(time.Millisecond)
+		if from < limit {
+			from = limit
+		}
+	}
+
+	return windowStart(from, interval), nil
+}
+
+// write stores the aggregates of the interval starting at start
+func (r *rollup) write(start int64, values []float64) {
+
+	for _, agg := range r.spec.Aggregations {
+		result, err := aggregate(agg, values)
+		if err != nil {
+			Warn("[RollupEngine] " + err.Error())
+			continue
+		}
+		payload, err := json.Marshal(map[string]float64{"value": result})
+		if err != nil {
+			Warn("[RollupEngine] Error encoding " + string(agg) + " of " + r.spec.SourceID + ": " + err.Error())
+			continue
+		}
+		dataSourceID := RollupDataSourceID(r.spec.SourceID, agg, r.spec.Interval)
+		err = r.csc.TSJSON.WriteAt(dataSourceID, start, payload)
+		if err != nil {
+			Warn("[RollupEngine] Error writing " + dataSourceID + ": " + err.Error())
+		}
+	}
+}
+
+// rollupAccumulator collects the values of the open intervals and emits each one lateness after it
+// ends, start is the oldest open interval
+type rollupAccumulator struct {
+	interval int64
+	lateness int64
+	start    int64
+	windows  map[int64][]float64
+	emit     func(start int64, values []float64)
+}
+
+func newRollupAccumulator(interval int64, lateness int64, emit func(start int64, values []float64)) *rollupAccumulator {
+	return &rollupAccumulator{
+		interval: interval,
+		lateness: lateness,
+		windows:  make(map[int64][]float64),
+		emit:     emit,
+	}
+}
+
+// add adds a value, values from before the oldest open interval arrived too late and are dropped
+func (acc *rollupAccumulator) add(timestamp int64, value float64) {
+
+	if timestamp < acc.start {
+		return
+	}
+	acc.advance(timestamp)
+	start := windowStart(timestamp, acc.interval)
+	acc.windows[start] = append(acc.windows[start], value)
+}
+
+// advance emits the intervals that ended lateness before now oldest first, intervals without values are not emitted
+func (acc *rollupAccumulator) advance(now int64) {
+
+	cutoff := now - acc.lateness
+	if cutoff < acc.start+acc.interval {
+		return
+	}
+
+	starts := []int64{}
+	for start := range acc.windows {
+		if start+acc.interval <= cutoff {
+			starts = append(starts, start)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	for _, start := range starts {
+		acc.emit(start, acc.windows[start])
+		delete(acc.windows, start)
+	}
+
+	acc.start = windowStart(cutoff, acc.interval)
+}
+
+// windowStart returns the start of the interval containing timestamp
+func windowStart(timestamp int64, interval int64) int64 {
+
+	start := timestamp - timestamp%interval
+	if timestamp < 0 && start != timestamp {
+		start -= interval
+	}
+
+	return start
+}
+
+// rollupValue returns the numeric value field of a TSStore record
+func rollupValue(data []byte) (float64, bool) {
+
+	record := struct {
+		Value *float64 `json:"value"`
+	}{}
+	err := json.Unmarshal(data, &record)
+	if err != nil || record.Value == nil {
+		Debug("[RollupEngine] ignoring record without a numeric value: " + string(data))
+		return 0, false
+	}
+
+	return *record.Value, true
+}
+
+// aggregate computes agg over values, values must not be empty
+func aggregate(agg AggregationType, values []float64) (float64, error) {
+
+	n := float64(len(values))
+
+	switch agg {
+	case Sum:
+		return sumValues(values), nil
+	case Count:
+		return n, nil
+	case Min:
+		min := values[0]
+		for _, v := range values[1:] {
+			min = math.Min(min, v)
+		}
+		return min, nil
+	case Max:
+		max := values[0]
+		for _, v := range values[1:] {
+			max = math.Max(max, v)
+		}
+		return max, nil
+	case Mean:
+		return sumValues(values) / n, nil
+	case Median:
+		sorted := append([]float64{}, values...)
+		sort.Float64s(sorted)
+		mid := len(sorted) / 2
+		if len(sorted)%2 == 0 {
+			return (sorted[mid-1] + sorted[mid]) / 2, nil
+		}
+		return sorted[mid], nil
+	case StandardDeviation:
+		if len(values) < 2 {
+			return 0, nil
+		}
+		mean := sumValues(values) / n
+		variance := 0.0
+		for _, v := range values {
+			variance += (v - mean) * (v - mean)
+		}
+		return math.Sqrt(variance / (n - 1)), nil
+	}
+
+	return 0, errors.New("Unknown aggregation " + string(agg))
+}
+
+func sumValues(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
@@ -0,0 +1,239 @@
+package libDatabox
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAggregate(t *testing.T) {
+
+	values := []float64{4, 1, 3, 2, 5}
+	expected := map[AggregationType]float64{
+		Sum:               15,
+		Count:             5,
+		Min:               1,
+		Max:               5,
+		Mean:              3,
+		Median:            3,
+		StandardDeviation: math.Sqrt(2.5),
+	}
+
+	for agg, want := range expected {
+		got, err := aggregate(agg, values)
+		if err != nil || math.Abs(got-want) > 1e-9 {
+			t.Errorf("aggregate %s expected %f got %f %v", agg, want, got, err)
+		}
+	}
+
+	median, _ := aggregate(Median, []float64{4, 1, 3, 2})
+	if median != 2.5 {
+		t.Errorf("aggregate median of an even number of values expected 2.5 got %f", median)
+	}
+
+	sd, _ := aggregate(StandardDeviation, []float64{7})
+	if sd != 0 {
+		t.Errorf("aggregate sd of one value expected 0 got %f", sd)
+	}
+
+	_, err := aggregate("mode", values)
+	if err == nil {
+		t.Error("aggregate expected an error for an unknown aggregation")
+	}
+}
+
+func TestWindowStart(t *testing.T) {
+
+	tests := [][3]int64{{0, 10, 0}, {9, 10, 0}, {10, 10, 10}, {125, 60, 120}, {-1, 10, -10}, {-10, 10, -10}}
+	for _, test := range tests {
+		if got := windowStart(test[0], test[1]); got != test[2] {
+			t.Errorf("windowStart(%d, %d) expected %d got %d", test[0], test[1], test[2], got)
+		}
+	}
+}
+
+func TestRollupAccumulator(t *testing.T) {
+
+	type window struct {
+		start  int64
+		values []float64
+	}
+	emitted := []window{}
+	acc := newRollupAccumulator(10, 0, func(start int64, values []float64) {
+		emitted = append(emitted, window{start, values})
+	})
+	acc.start = 100
+
+	acc.add(95, 1) //too late
+	acc.add(101, 2)
+	acc.add(109, 3)
+	acc.add(131, 4) //skips the empty interval at 110
+	acc.advance(135)
+	acc.advance(140)
+
+	expected := []window{{100, []float64{2, 3}}, {130, []float64{4}}}
+	if !reflect.DeepEqual(emitted, expected) {
+		t.Errorf("rollupAccumulator expected %v got %v", expected, emitted)
+	}
+	if acc.start != 140 {
+		t.Errorf("rollupAccumulator expected the current interval to start at 140 got %d", acc.start)
+	}
+}
+
+func TestReplayRecords(t *testing.T) {
+
+	acc := newRollupAccumulator(10, 0, func(start int64, values []float64) {
+		t.Errorf("replayRecords expected the current interval to stay open got %d %v", start, values)
+	})
+	acc.start = 100
+
+	it := newTSIterator(100, 109, TSIteratorOptions{}, func(from int64, to int64) ([]byte, error) {
+		return []byte(`[{"timestamp":104,"data":{"value":2}},{"timestamp":101,"data":{"value":1}},{"timestamp":106,"data":{"temp":3}}]`), nil
+	})
+	last, err := replayRecords(acc, it, make(chan struct{}))
+	if err != nil {
+		t.Fatalf("replayRecords expected err to be nil got %s", err.Error())
+	}
+	if last != 106 {
+		t.Errorf("replayRecords expected the last timestamp to be 106 got %d", last)
+	}
+	if !reflect.DeepEqual(acc.windows[100], []float64{1, 2}) {
+		t.Errorf("replayRecords expected values [1 2] got %v", acc.windows[100])
+	}
+
+	empty := newTSIterator(100, 109, TSIteratorOptions{}, func(from int64, to int64) ([]byte, error) {
+		return []byte(`[]`), nil
+	})
+	if last, _ := replayRecords(acc, empty, make(chan struct{})); last != 0 {
+		t.Errorf("replayRecords of no records expected 0 got %d", last)
+	}
+
+	//a long replay stops once done is closed
+	done := make(chan struct{})
+	fetches := 0
+	endless := newTSIterator(0, 1<<40, TSIteratorOptions{Window: time.Millisecond}, func(from int64, to int64) ([]byte, error) {
+		fetches++
+		if fetches == 3 {
+			close(done)
+		}
+		return []byte(`[]`), nil
+	})
+	finished := make(chan struct{})
+	go func() {
+		replayRecords(acc, endless, done)
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatal("replayRecords expected to stop once done is closed")
+	}
+}
+
+func TestRollupAccumulatorLateness(t *testing.T) {
+
+	emitted := []int64{}
+	acc := newRollupAccumulator(10, 5, func(start int64, values []float64) {
+		emitted = append(emitted, start)
+		if start == 100 && !reflect.DeepEqual(values, []float64{1, 2}) {
+			t.Errorf("rollupAccumulator expected the late value in interval 100 got %v", values)
+		}
+	})
+	acc.start = 100
+
+	acc.add(101, 1)
+	acc.add(111, 3) //next interval, 100 is still open for late records
+	acc.add(109, 2) //late but within the allowance
+	acc.advance(114)
+	if len(emitted) != 0 {
+		t.Errorf("rollupAccumulator expected nothing before the lateness has passed got %v", emitted)
+	}
+
+	acc.advance(115)
+	acc.add(108, 4) //too late
+	acc.advance(125)
+	if !reflect.DeepEqual(emitted, []int64{100, 110}) {
+		t.Errorf("rollupAccumulator expected intervals 100 and 110 got %v", emitted)
+	}
+}
+
+func TestRollupDataSourceID(t *testing.T) {
+
+	if id := RollupDataSourceID("temp", Mean, time.Minute); id != "temp-mean-60s" {
+		t.Errorf("RollupDataSourceID expected temp-mean-60s got %s", id)
+	}
+	if id := RollupDataSourceID("temp", Max, 1500*time.Millisecond); id != "temp-max-1500ms" {
+		t.Errorf("RollupDataSourceID expected temp-max-1500ms got %s", id)
+	}
+}
+
+func TestRollupValue(t *testing.T) {
+
+	value, ok := rollupValue([]byte(`{"value":2.5,"tag":"a"}`))
+	if !ok || value != 2.5 {
+		t.Errorf("rollupValue expected 2.5 got %f %t", value, ok)
+	}
+
+	for _, data := range []string{`{"value":"high"}`, `{"temp":1}`, `not json`} {
+		if _, ok := rollupValue([]byte(data)); ok {
+			t.Errorf("rollupValue of %s expected no value", data)
+		}
+	}
+}
+
+func TestRollupEngineInvalidSpec(t *testing.T) {
+
+	re := NewRollupEngine(StoreClient)
+	defer re.Close()
+
+	specs := []RollupSpec{
+		{Interval: time.Minute},
+		{SourceID: "temp"},
+		{SourceID: "temp", Interval: time.Minute, Aggregations: []AggregationType{"mode"}},
+		{SourceID: "temp", Interval: time.Minute, Lateness: -time.Second},
+	}
+	for _, spec := range specs {
+		if err := re.Start(spec); err == nil {
+			t.Errorf("Start expected an error for %+v", spec)
+		}
+	}
+}
+
+func TestRollupEngineCatchUp(t *testing.T) {
+
+	sourceID := dsID + "Rollup" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	start := windowStart(time.Now().Add(-time.Minute).UnixNano()/int64(time.Millisecond), 1000)
+
+	for i := 0; i < 4; i++ {
+		err := StoreClient.TSJSON.WriteAt(sourceID, start+int64(i*500), []byte(`{"value":`+strconv.Itoa(i)+`}`))
+		if err != nil {
+			t.Fatalf("WriteAt to %s failed expected err to be nil got %s", sourceID, err.Error())
+		}
+	}
+
+	re := NewRollupEngine(StoreClient)
+	err := re.Start(RollupSpec{
+		SourceID:     sourceID,
+		Interval:     time.Second,
+		Aggregations: []AggregationType{Sum},
+		Metadata:     DataSourceMetadata{Description: "rollup test", Vendor: "test", DataSourceType: "testdata"},
+	})
+	if err != nil {
+		t.Fatalf("Start failed expected err to be nil got %s", err.Error())
+	}
+	time.Sleep(500 * time.Millisecond)
+	re.Close()
+
+	result, err := StoreClient.TSJSON.Range(RollupDataSourceID(sourceID, Sum, time.Second), start, start+1000, TimeSeriesQueryOptions{})
+	if err != nil {
+		t.Fatalf("Range failed expected err to be nil got %s", err.Error())
+	}
+	records := []TSRecord{}
+	decodeErr := json.Unmarshal(result, &records)
+	if decodeErr != nil || len(records) != 2 {
+		t.Errorf("rollup expected 2 intervals got %s", result)
+	}
+}
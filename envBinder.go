@@ -0,0 +1,181 @@
+package libDatabox
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Environment variables set by the container manager for apps and drivers
+const (
+	EnvStoreEndpoint       = "DATABOX_ZMQ_ENDPOINT"
+	EnvStoreDealerEndpoint = "DATABOX_ZMQ_DEALER_ENDPOINT"
+	EnvArbiterEndpoint     = "DATABOX_ARBITER_ENDPOINT"
+	EnvLocalName           = "DATABOX_LOCAL_NAME"
+	EnvExportEndpoint      = "DATABOX_EXPORT_SERVICE_ENDPOINT"
+	EnvDataSourcePrefix    = "DATASOURCE_"
+)
+
+// AppEnvironment holds the values passed to an app or driver in its environment
+type AppEnvironment struct {
+	StoreEndpoint       string
+	StoreDealerEndpoint string
+	ArbiterEndpoint     string
+	LocalName           string
+	ExportEndpoint      string
+	DataSources         map[string]HypercatItem //keyed by the manifest clientid
+	InvalidDataSources  map[string]error        //datasources that could not be decoded keyed by the manifest clientid
+}
+
+// DataSourceBindings are the datasources granted to an app keyed by the manifest clientid
+type DataSourceBindings struct {
+	Handles         map[string]DataSourceHandle
+	MissingRequired []string
+	MissingOptional []string
+}
+
+// ReadAppEnvironment reads the databox environment variables of the current process
+func ReadAppEnvironment() (AppEnvironment, error) {
+	return parseAppEnvironment(os.Environ())
+}
+
+func parseAppEnvironment(environ []string) (AppEnvironment, error) {
+
+	env := AppEnvironment{
+		DataSources:        make(map[string]HypercatItem),
+		InvalidDataSources: make(map[string]error),
+	}
+	failed := []string{}
+
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name, value := parts[0], parts[1]
+
+		switch name {
+		case EnvStoreEndpoint:
+			env.StoreEndpoint = value
+		case EnvStoreDealerEndpoint:
+			env.StoreDealerEndpoint = value
+		case EnvArbiterEndpoint:
+			env.ArbiterEndpoint = value
+		case EnvLocalName:
+			env.LocalName = value
+		case EnvExportEndpoint:
+			env.ExportEndpoint = value
+		default:
+			if !strings.HasPrefix(name, EnvDataSourcePrefix) || value == "" {
+				continue
+			}
+			clientid := strings.TrimPrefix(name, EnvDataSourcePrefix)
+			item := HypercatItem{}
+			err := json.Unmarshal([]byte(value), &item)
+			if err != nil {
+				failed = append(failed, name+" ("+err.Error()+")")
+				env.InvalidDataSources[clientid] = err
+				continue
+			}
+			env.DataSources[clientid] = item
+		}
+	}
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return env, errors.New("Error decoding datasources: " + strings.Join(failed, ", "))
+	}
+
+	return env, nil
+}
+
+// Bind resolves the datasources in the environment into handles using pool. datasources should be the
+// datasources from the app manifest, any that are not in the environment are reported in MissingRequired
+// or MissingOptional and an error is returned if a required datasource is missing. Datasources in the
+// environment that are not listed in datasources are still bound. Hrefs without a store are resolved
+// against StoreEndpoint. A datasource that can not be decoded or bound is reported in the error and
+// as missing, the others are still bound.
+func (env AppEnvironment) Bind(datasources []DataSource, pool *StorePool) (DataSourceBindings, error) {
+
+	bindings := DataSourceBindings{
+		Handles: make(map[string]DataSourceHandle),
+	}
+	failed := []string{}
+
+	for clientid, err := range env.InvalidDataSources {
+		failed = append(failed, clientid+" ("+err.Error()+")")
+	}
+
+	for clientid, item := range env.DataSources {
+		item, err := env.resolveHref(item)
+		if err != nil {
+			failed = append(failed, clientid+" ("+err.Error()+")")
+			continue
+		}
+		handle, err := pool.ForDataSource(item)
+		if err != nil {
+			failed = append(failed, clientid+" ("+err.Error()+")")
+			continue
+		}
+		bindings.Handles[clientid] = handle
+	}
+
+	for _, ds := range datasources {
+		if _, ok := bindings.Handles[ds.Clientid]; ok {
+			continue
+		}
+		if ds.Required {
+			bindings.MissingRequired = append(bindings.MissingRequired, ds.Clientid)
+		} else {
+			bindings.MissingOptional = append(bindings.MissingOptional, ds.Clientid)
+		}
+	}
+
+	sort.Strings(failed)
+	sort.Strings(bindings.MissingRequired)
+	sort.Strings(bindings.MissingOptional)
+
+	msgs := []string{}
+	if len(failed) > 0 {
+		msgs = append(msgs, "failed to bind "+strings.Join(failed, ", "))
+	}
+	if len(bindings.MissingRequired) > 0 {
+		msgs = append(msgs, "missing required datasources "+strings.Join(bindings.MissingRequired, ", "))
+	}
+	if len(msgs) > 0 {
+		return bindings, errors.New("Error binding datasources: " + strings.Join(msgs, "; "))
+	}
+
+	return bindings, nil
+}
+
+// resolveHref prefixes hrefs that only hold the datasource path with StoreEndpoint
+func (env AppEnvironment) resolveHref(item HypercatItem) (HypercatItem, error) {
+
+	u, err := url.Parse(item.Href)
+	if err != nil {
+		return item, errors.New("Invalid href " + item.Href + ": " + err.Error())
+	}
+	if u.Host != "" {
+		return item, nil
+	}
+	if env.StoreEndpoint == "" {
+		return item, errors.New("No store for href " + item.Href + ", " + EnvStoreEndpoint + " is not set")
+	}
+
+	item.Href = strings.TrimSuffix(env.StoreEndpoint, "/") + "/" + strings.TrimPrefix(item.Href, "/")
+
+	return item, nil
+}
+
+// BindDataSourcesFromEnv reads the current environment and binds the granted datasources using pool.
+// Datasources that can not be decoded are reported by Bind so the others are still bound.
+func BindDataSourcesFromEnv(datasources []DataSource, pool *StorePool) (DataSourceBindings, error) {
+
+	env, _ := ReadAppEnvironment()
+
+	return env.Bind(datasources, pool)
+}
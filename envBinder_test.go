@@ -0,0 +1,93 @@
+package libDatabox
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBindDataSources(t *testing.T) {
+
+	item, err := DataSourceMetadataToHypercat(DataSourceMetadata{
+		Description:    "test",
+		ContentType:    ContentTypeJSON,
+		Vendor:         "test",
+		DataSourceType: "test",
+		DataSourceID:   "sensor",
+		StoreType:      StoreTypeTSBlob,
+	}, "tcp://driver-test-core-store:5555")
+	if err != nil {
+		t.Fatalf("DataSourceMetadataToHypercat failed expected err to be nil got %s", err.Error())
+	}
+	itemJSON, _ := json.Marshal(item)
+
+	env, err := parseAppEnvironment([]string{
+		"PATH=/bin",
+		EnvStoreEndpoint + "=tcp://app-test-core-store:5555",
+		EnvArbiterEndpoint + "=tcp://arbiter:4444",
+		EnvDataSourcePrefix + "SENSOR=" + string(itemJSON),
+	})
+	if err != nil {
+		t.Fatalf("parseAppEnvironment failed expected err to be nil got %s", err.Error())
+	}
+
+	if env.StoreEndpoint != "tcp://app-test-core-store:5555" || env.ArbiterEndpoint != "tcp://arbiter:4444" {
+		t.Errorf("parseAppEnvironment got wrong endpoints %+v", env)
+	}
+
+	sp := NewStorePool(Arbiter, "", false, 0)
+	defer sp.Close()
+
+	bindings, err := env.Bind([]DataSource{
+		{Clientid: "SENSOR", Required: true},
+		{Clientid: "OPTIONAL", Required: false},
+	}, sp)
+	if err != nil {
+		t.Fatalf("Bind failed expected err to be nil got %s", err.Error())
+	}
+
+	handle, ok := bindings.Handles["SENSOR"]
+	if !ok || handle.TSBlob == nil || handle.DataSourceID != "sensor" {
+		t.Errorf("Bind expected a TSBlob handle for SENSOR got %+v", handle)
+	}
+
+	if len(bindings.MissingOptional) != 1 || bindings.MissingOptional[0] != "OPTIONAL" {
+		t.Errorf("Bind expected OPTIONAL to be missing got %v", bindings.MissingOptional)
+	}
+
+	_, err = env.Bind([]DataSource{{Clientid: "REQUIRED", Required: true}}, sp)
+	if err == nil {
+		t.Errorf("Bind expected an error for a missing required datasource")
+	}
+}
+
+func TestParseAppEnvironmentInvalid(t *testing.T) {
+
+	env, err := parseAppEnvironment([]string{
+		EnvStoreEndpoint + "=tcp://app-test-core-store:5555",
+		EnvDataSourcePrefix + "BROKEN={not json",
+		EnvDataSourcePrefix + `LOCAL={"item-metadata":[{"rel":"urn:X-databox:rels:hasDatasourceid","val":"local"},{"rel":"urn:X-databox:rels:hasStoreType","val":"kv"},{"rel":"urn:X-hypercat:rels:isContentType","val":"application/json"}],"href":"/kv/local"}`,
+	})
+	if err == nil {
+		t.Errorf("parseAppEnvironment expected an error for invalid hypercat json")
+	}
+
+	sp := NewStorePool(Arbiter, "", false, 0)
+	defer sp.Close()
+
+	bindings, err := env.Bind([]DataSource{
+		{Clientid: "BROKEN", Required: true},
+		{Clientid: "LOCAL", Required: true},
+	}, sp)
+	if err == nil {
+		t.Errorf("Bind expected an error for a datasource that could not be decoded")
+	}
+
+	if len(bindings.MissingRequired) != 1 || bindings.MissingRequired[0] != "BROKEN" {
+		t.Errorf("Bind expected BROKEN to be missing got %v", bindings.MissingRequired)
+	}
+
+	handle, ok := bindings.Handles["LOCAL"]
+	if !ok || handle.KV == nil || handle.StoreURL != "tcp://app-test-core-store:5555" {
+		t.Errorf("Bind expected LOCAL to be bound to the app store got %+v", handle)
+	}
+}
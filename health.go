@@ -0,0 +1,185 @@
+package libDatabox
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultHealthTimeout is how long a health check waits for a ping before reporting it as failed
+const DefaultHealthTimeout = 5 * time.Second
+
+// Health status values reported in a HealthReport
+const (
+	HealthStatusOK          = "ok"
+	HealthStatusUnavailable = "unavailable"
+)
+
+// HealthCheck is the result of pinging the arbiter or a store
+type HealthCheck struct {
+	Name      string        `json:"name"`
+	URL       string        `json:"url"`
+	Healthy   bool          `json:"healthy"`
+	Latency   time.Duration `json:"-"`
+	LatencyMs float64       `json:"latencyMs"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// HealthReport combines the checks of a client, Status is HealthStatusOK only if all checks are healthy
+type HealthReport struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// Healthy returns true if all checks in the report passed
+func (r HealthReport) Healthy() bool {
+	return r.Status == HealthStatusOK
+}
+
+// Ping reads the arbiter root catalogue using the arbiter token and returns how long it took.
+// An error means the arbiter is unreachable or the token was rejected.
+func (arb *ArbiterClient) Ping() (time.Duration, error) {
+
+	if arb.isClosed() {
+		return 0, errArbiterClientClosed
+	}
+
+	if arb.arbiterZMQURI == "" {
+		return 0, errors.New("No arbiter URI set")
+	}
+
+	start := time.Now()
+	_, err := arb.ZestC.Get(arb.ArbiterToken, "/cat", string(ContentTypeJSON))
+	latency := time.Since(start)
+	if err != nil {
+		return latency, errors.New("Error pinging arbiter: " + err.Error())
+	}
+
+	return latency, nil
+}
+
+// Health pings the arbiter waiting at most timeout for a reply
+func (arb *ArbiterClient) Health(timeout time.Duration) HealthCheck {
+	return checkHealth("arbiter", arb.arbiterZMQURI, arb.Ping, timeout)
+}
+
+// Ping reads the store catalogue and returns how long it took including getting a token from the arbiter.
+// An error means the store or arbiter is unreachable or the store rejected the token.
+func (csc *CoreStoreClient) Ping() (time.Duration, error) {
+
+	start := time.Now()
+	_, err := csc.read("/cat", ContentTypeJSON)
+	latency := time.Since(start)
+	if err != nil {
+		return latency, errors.New("Error pinging store: " + err.Error())
+	}
+
+	return latency, nil
+}
+
+// Health pings the store and its arbiter concurrently waiting at most timeout for each
+func (csc *CoreStoreClient) Health(timeout time.Duration) HealthReport {
+
+	checks := make([]HealthCheck, 2)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		checks[0] = csc.Arbiter.Health(timeout)
+	}()
+	go func() {
+		defer wg.Done()
+		checks[1] = checkHealth("store", csc.ZEndpoint, csc.Ping, timeout)
+	}()
+	wg.Wait()
+
+	return newHealthReport(checks)
+}
+
+// checkHealth runs ping giving up after timeout, the ping is left to finish in the background
+func checkHealth(name string, url string, ping func() (time.Duration, error), timeout time.Duration) HealthCheck {
+
+	if timeout <= 0 {
+		timeout = DefaultHealthTimeout
+	}
+
+	check := HealthCheck{
+		Name: name,
+		URL:  url,
+	}
+
+	type pingResult struct {
+		latency time.Duration
+		err     error
+	}
+	result := make(chan pingResult, 1)
+	go func() {
+		latency, err := ping()
+		result <- pingResult{latency, err}
+	}()
+
+	select {
+	case r := <-result:
+		check.Latency = r.latency
+		if r.err != nil {
+			check.Error = r.err.Error()
+		} else {
+			check.Healthy = true
+		}
+	case <-time.After(timeout):
+		check.Latency = timeout
+		check.Error = "Timeout after " + timeout.String()
+	}
+	check.LatencyMs = float64(check.Latency) / float64(time.Millisecond)
+
+	return check
+}
+
+func newHealthReport(checks []HealthCheck) HealthReport {
+
+	report := HealthReport{
+		Status: HealthStatusOK,
+		Checks: checks,
+	}
+	for _, check := range checks {
+		if !check.Healthy {
+			report.Status = HealthStatusUnavailable
+		}
+	}
+
+	return report
+}
+
+// LivenessHandler returns an http handler for a liveness probe. It does not contact the store or arbiter
+// and only fails once csc has been closed.
+func LivenessHandler(csc *CoreStoreClient) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := HealthReport{Status: HealthStatusOK, Checks: []HealthCheck{}}
+		if csc.isClosing() {
+			report.Status = HealthStatusUnavailable
+		}
+		writeHealthReport(w, report)
+	})
+}
+
+// ReadinessHandler returns an http handler for a readiness probe that pings the store and arbiter
+// on each request. It responds 200 if both are reachable and 503 otherwise with the HealthReport as json.
+func ReadinessHandler(csc *CoreStoreClient, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealthReport(w, csc.Health(timeout))
+	})
+}
+
+func writeHealthReport(w http.ResponseWriter, report HealthReport) {
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Healthy() {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,104 @@
+package libDatabox
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckHealth(t *testing.T) {
+
+	check := checkHealth("store", StoreURL, func() (time.Duration, error) {
+		return 3 * time.Millisecond, nil
+	}, time.Second)
+	if !check.Healthy || check.Error != "" || check.LatencyMs != 3 {
+		t.Errorf("checkHealth expected healthy with 3ms latency got %+v", check)
+	}
+
+	check = checkHealth("store", StoreURL, func() (time.Duration, error) {
+		return 0, errors.New("refused")
+	}, time.Second)
+	if check.Healthy || check.Error != "refused" {
+		t.Errorf("checkHealth expected error refused got %+v", check)
+	}
+
+	block := make(chan struct{})
+	defer close(block)
+	check = checkHealth("store", StoreURL, func() (time.Duration, error) {
+		<-block
+		return 0, nil
+	}, 20*time.Millisecond)
+	if check.Healthy || check.Latency != 20*time.Millisecond {
+		t.Errorf("checkHealth expected timeout got %+v", check)
+	}
+}
+
+func TestNewHealthReport(t *testing.T) {
+
+	report := newHealthReport([]HealthCheck{{Name: "arbiter", Healthy: true}, {Name: "store", Healthy: true}})
+	if !report.Healthy() {
+		t.Errorf("newHealthReport expected ok got %s", report.Status)
+	}
+
+	report = newHealthReport([]HealthCheck{{Name: "arbiter", Healthy: true}, {Name: "store", Healthy: false}})
+	if report.Healthy() || report.Status != HealthStatusUnavailable {
+		t.Errorf("newHealthReport expected unavailable got %s", report.Status)
+	}
+}
+
+func TestLivenessHandler(t *testing.T) {
+
+	csc := NewCoreStoreClient(Arbiter, "", StoreURL, false)
+	handler := LivenessHandler(csc)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("LivenessHandler expected 200 got %d", rec.Code)
+	}
+
+	csc.Close()
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("LivenessHandler after Close expected 503 got %d", rec.Code)
+	}
+}
+
+func TestReadinessHandlerClosed(t *testing.T) {
+
+	csc := NewCoreStoreClient(Arbiter, "", StoreURL, false)
+	csc.Close()
+
+	rec := httptest.NewRecorder()
+	ReadinessHandler(csc, time.Second).ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("ReadinessHandler expected 503 got %d", rec.Code)
+	}
+
+	report := HealthReport{}
+	err := json.Unmarshal(rec.Body.Bytes(), &report)
+	if err != nil {
+		t.Fatalf("ReadinessHandler returned invalid json %s", err.Error())
+	}
+	if len(report.Checks) != 2 || report.Checks[1].Name != "store" || report.Checks[1].Healthy {
+		t.Errorf("ReadinessHandler expected failed store check got %+v", report.Checks)
+	}
+}
+
+func TestPing(t *testing.T) {
+
+	_, err := Arbiter.Ping()
+	if err != nil {
+		t.Errorf("Arbiter Ping failed expected err to be nil got %s", err.Error())
+	}
+
+	_, err = StoreClient.Ping()
+	if err != nil {
+		t.Errorf("StoreClient Ping failed expected err to be nil got %s", err.Error())
+	}
+}
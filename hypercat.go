@@ -0,0 +1,314 @@
+package libDatabox
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Hypercat rels used by databox to describe datasources
+const (
+	RelHasDescription  = "urn:X-hypercat:rels:hasDescription:en"
+	RelIsContentType   = "urn:X-hypercat:rels:isContentType"
+	RelHasVendor       = "urn:X-databox:rels:hasVendor"
+	RelHasType         = "urn:X-databox:rels:hasType"
+	RelHasDatasourceID = "urn:X-databox:rels:hasDatasourceid"
+	RelHasStoreType    = "urn:X-databox:rels:hasStoreType"
+	RelIsActuator      = "urn:X-databox:rels:isActuator"
+	RelIsFunc          = "urn:X-databox:rels:isFunc"
+	RelHasLocation     = "urn:X-databox:rels:hasLocation"
+	RelHasUnit         = "urn:X-databox:rels:hasUnit"
+)
+
+// RelVal is a single hypercat metadata entry. Val holds the decoded json value, usually a string or bool.
+type RelVal struct {
+	Rel string      `json:"rel"`
+	Val interface{} `json:"val"`
+}
+
+// HypercatMetadata holds the item-metadata of a HypercatItem. Entries can be RelVal, RelValPair,
+// RelValPairBool or maps using rel/val or Rel/Val keys. When decoded from json every entry is a RelVal.
+type HypercatMetadata []interface{}
+
+// UnmarshalJSON decodes item-metadata normalising all entries to RelVal. Entries that are
+// not objects with a rel are kept as they are.
+func (hm *HypercatMetadata) UnmarshalJSON(data []byte) error {
+
+	var raw []interface{}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	metadata := make(HypercatMetadata, 0, len(raw))
+	for _, item := range raw {
+		if rv, ok := toRelVal(item); ok {
+			metadata = append(metadata, rv)
+		} else {
+			metadata = append(metadata, item)
+		}
+	}
+
+	*hm = metadata
+	return nil
+}
+
+// MarshalJSON encodes item-metadata always using the lower case rel/val keys
+func (hm HypercatMetadata) MarshalJSON() ([]byte, error) {
+
+	if hm == nil {
+		return []byte("null"), nil
+	}
+
+	out := make([]interface{}, 0, len(hm))
+	for _, item := range hm {
+		if rv, ok := toRelVal(item); ok {
+			out = append(out, rv)
+		} else {
+			out = append(out, item)
+		}
+	}
+
+	return json.Marshal(out)
+}
+
+// RelVals returns all entries that have a rel as RelVal
+func (hm HypercatMetadata) RelVals() []RelVal {
+
+	rvs := []RelVal{}
+	for _, item := range hm {
+		if rv, ok := toRelVal(item); ok {
+			rvs = append(rvs, rv)
+		}
+	}
+
+	return rvs
+}
+
+// Get returns the value of the first entry for rel
+func (hm HypercatMetadata) Get(rel string) (interface{}, bool) {
+
+	for _, item := range hm {
+		rv, ok := toRelVal(item)
+		if ok && rv.Rel == rel {
+			return rv.Val, true
+		}
+	}
+
+	return nil, false
+}
+
+// String returns the value of rel as a string, non string values are formatted. Missing rels return "".
+func (hm HypercatMetadata) String(rel string) string {
+
+	val, ok := hm.Get(rel)
+	if !ok {
+		return ""
+	}
+
+	return valueString(val)
+}
+
+// Bool returns true if rel is set to true or "true" (any case)
+func (hm HypercatMetadata) Bool(rel string) bool {
+
+	val, ok := hm.Get(rel)
+	if !ok {
+		return false
+	}
+
+	return valueBool(val)
+}
+
+// Set replaces the value of rel or adds it if it does not exist
+func (hm *HypercatMetadata) Set(rel string, val interface{}) {
+
+	for i, item := range *hm {
+		rv, ok := toRelVal(item)
+		if ok && rv.Rel == rel {
+			(*hm)[i] = RelVal{Rel: rel, Val: val}
+			return
+		}
+	}
+
+	*hm = append(*hm, RelVal{Rel: rel, Val: val})
+}
+
+// toRelVal converts any of the supported item-metadata shapes to a RelVal
+func toRelVal(item interface{}) (RelVal, bool) {
+
+	switch v := item.(type) {
+	case RelVal:
+		return v, true
+	case *RelVal:
+		if v != nil {
+			return *v, true
+		}
+	case RelValPair:
+		return RelVal{Rel: v.Rel, Val: v.Val}, true
+	case *RelValPair:
+		if v != nil {
+			return RelVal{Rel: v.Rel, Val: v.Val}, true
+		}
+	case RelValPairBool:
+		return RelVal{Rel: v.Rel, Val: v.Val}, true
+	case *RelValPairBool:
+		if v != nil {
+			return RelVal{Rel: v.Rel, Val: v.Val}, true
+		}
+	case map[string]interface{}:
+		if rel, ok := v["rel"].(string); ok {
+			return RelVal{Rel: rel, Val: v["val"]}, true
+		}
+		if rel, ok := v["Rel"].(string); ok {
+			return RelVal{Rel: rel, Val: v["Val"]}, true
+		}
+	}
+
+	return RelVal{}, false
+}
+
+func valueString(val interface{}) string {
+
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	b, err := json.Marshal(val)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func valueBool(val interface{}) bool {
+
+	switch v := val.(type) {
+	case bool:
+		return v
+	case string:
+		return strings.ToLower(v) == "true"
+	}
+
+	return false
+}
+
+// knownRels are the rels decoded into the fields of DataSourceMetadata
+var knownRels = map[string]bool{
+	RelHasDescription:  true,
+	RelIsContentType:   true,
+	RelHasVendor:       true,
+	RelHasType:         true,
+	RelHasDatasourceID: true,
+	RelHasStoreType:    true,
+	RelIsActuator:      true,
+	RelIsFunc:          true,
+	RelHasLocation:     true,
+	RelHasUnit:         true,
+}
+
+// DataSourceMetadataToHypercat converts a DataSourceMetadata instance to the hypercat item used to register
+// the datasource in the store at endPoint. It is the inverse of HypercatItemToDataSourceMetadata.
+func DataSourceMetadataToHypercat(metadata DataSourceMetadata, endPoint string) (HypercatItem, error) {
+
+	if metadata.Description == "" ||
+		metadata.ContentType == "" ||
+		metadata.Vendor == "" ||
+		metadata.DataSourceType == "" ||
+		metadata.DataSourceID == "" ||
+		metadata.StoreType == "" {
+
+		return HypercatItem{}, errors.New("Missing required metadata")
+	}
+
+	cat := HypercatItem{}
+	cat.ItemMetadata = append(cat.ItemMetadata, RelValPair{Rel: RelHasDescription, Val: metadata.Description})
+	cat.ItemMetadata = append(cat.ItemMetadata, RelValPair{Rel: RelIsContentType, Val: string(metadata.ContentType)})
+	cat.ItemMetadata = append(cat.ItemMetadata, RelValPair{Rel: RelHasVendor, Val: metadata.Vendor})
+	cat.ItemMetadata = append(cat.ItemMetadata, RelValPair{Rel: RelHasType, Val: metadata.DataSourceType})
+	cat.ItemMetadata = append(cat.ItemMetadata, RelValPair{Rel: RelHasDatasourceID, Val: metadata.DataSourceID})
+	cat.ItemMetadata = append(cat.ItemMetadata, RelValPair{Rel: RelHasStoreType, Val: string(metadata.StoreType)})
+
+	if metadata.IsActuator {
+		cat.ItemMetadata = append(cat.ItemMetadata, RelValPairBool{Rel: RelIsActuator, Val: true})
+	}
+
+	if metadata.IsFunc {
+		cat.ItemMetadata = append(cat.ItemMetadata, RelValPairBool{Rel: RelIsFunc, Val: true})
+	}
+
+	if metadata.Location != "" {
+		cat.ItemMetadata = append(cat.ItemMetadata, RelValPair{Rel: RelHasLocation, Val: metadata.Location})
+	}
+
+	if metadata.Unit != "" {
+		cat.ItemMetadata = append(cat.ItemMetadata, RelValPair{Rel: RelHasUnit, Val: metadata.Unit})
+	}
+
+	for _, rv := range metadata.CustomRels {
+		if knownRels[rv.Rel] {
+			return HypercatItem{}, errors.New("Custom rel " + rv.Rel + " is already set from DataSourceMetadata")
+		}
+		cat.ItemMetadata = append(cat.ItemMetadata, rv)
+	}
+
+	if metadata.IsFunc {
+		cat.Href = endPoint + "/request/" + metadata.DataSourceID
+	} else {
+		cat.Href = endPoint + "/" + string(metadata.StoreType) + "/" + metadata.DataSourceID
+	}
+
+	return cat, nil
+}
+
+// HypercatItemToDataSourceMetadata converts a hypercat item to a DataSourceMetadata instance and also returns
+// the store url for the datasource. Rels that are not part of DataSourceMetadata are returned in CustomRels.
+func HypercatItemToDataSourceMetadata(item HypercatItem) (DataSourceMetadata, string, error) {
+
+	dm := DataSourceMetadata{}
+
+	for _, pair := range item.ItemMetadata.RelVals() {
+		switch pair.Rel {
+		case RelHasDescription:
+			dm.Description = valueString(pair.Val)
+		case RelIsContentType:
+			dm.ContentType = StoreContentType(valueString(pair.Val))
+		case RelHasVendor:
+			dm.Vendor = valueString(pair.Val)
+		case RelHasType:
+			dm.DataSourceType = valueString(pair.Val)
+		case RelHasDatasourceID:
+			dm.DataSourceID = valueString(pair.Val)
+		case RelHasStoreType:
+			st := valueString(pair.Val)
+			var known bool
+			dm.StoreType, known = NormaliseStoreType(st)
+			if !known {
+				//some old SLAs will not have this most use TSBlob. Use MigrateSLA to upgrade them.
+				Warn("Unknown hasStoreType (" + st + ") for " + item.Href + " using " + string(dm.StoreType))
+			}
+		case RelIsActuator:
+			dm.IsActuator = valueBool(pair.Val)
+		case RelIsFunc:
+			dm.IsFunc = valueBool(pair.Val)
+		case RelHasLocation:
+			dm.Location = valueString(pair.Val)
+		case RelHasUnit:
+			dm.Unit = valueString(pair.Val)
+		default:
+			dm.CustomRels = append(dm.CustomRels, pair)
+		}
+	}
+
+	url, err := GetStoreURLFromDsHref(item.Href)
+
+	return dm, url, err
+}
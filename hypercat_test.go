@@ -0,0 +1,207 @@
+package libDatabox
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+	"testing/quick"
+)
+
+func TestHypercatMetadataUnmarshal(t *testing.T) {
+
+	itemJSON := `{
+		"item-metadata": [
+			{"rel": "urn:X-databox:rels:hasDatasourceid", "val": "test"},
+			{"Rel": "urn:X-databox:rels:isActuator", "Val": "TRUE"},
+			{"rel": "urn:X-databox:rels:isFunc", "val": false},
+			{"rel": "urn:X-test:rels:custom", "val": {"nested": 1}},
+			{"rel": "urn:X-test:rels:number", "val": 42},
+			"not a pair"
+		],
+		"href": "tcp://driver-test-core-store:5555/kv/test"
+	}`
+
+	item := HypercatItem{}
+	err := json.Unmarshal([]byte(itemJSON), &item)
+	if err != nil {
+		t.Fatalf("Unmarshal failed expected err to be nil got %s", err.Error())
+	}
+
+	if item.ItemMetadata.String(RelHasDatasourceID) != "test" {
+		t.Errorf("String expected test got %s", item.ItemMetadata.String(RelHasDatasourceID))
+	}
+
+	if !item.ItemMetadata.Bool(RelIsActuator) {
+		t.Errorf("Bool expected isActuator to be true")
+	}
+
+	if item.ItemMetadata.Bool(RelIsFunc) {
+		t.Errorf("Bool expected isFunc to be false")
+	}
+
+	if item.ItemMetadata.String("urn:X-test:rels:number") != "42" {
+		t.Errorf("String expected 42 got %s", item.ItemMetadata.String("urn:X-test:rels:number"))
+	}
+
+	if _, ok := item.ItemMetadata.Get("urn:X-test:rels:custom"); !ok {
+		t.Errorf("Get expected custom rel to be preserved")
+	}
+
+	if _, ok := item.ItemMetadata.Get("urn:X-test:rels:missing"); ok {
+		t.Errorf("Get expected missing rel to not be found")
+	}
+
+	if len(item.ItemMetadata) != 6 || len(item.ItemMetadata.RelVals()) != 5 {
+		t.Errorf("Unmarshal expected 6 entries and 5 rels got %d and %d", len(item.ItemMetadata), len(item.ItemMetadata.RelVals()))
+	}
+}
+
+func TestHypercatMetadataMarshal(t *testing.T) {
+
+	item := HypercatItem{
+		ItemMetadata: HypercatMetadata{
+			RelValPair{Rel: RelHasVendor, Val: "test"},
+			RelValPairBool{Rel: RelIsActuator, Val: true},
+			map[string]interface{}{"Rel": RelHasUnit, "Val": "C"},
+		},
+		Href: "tcp://driver-test-core-store:5555/kv/test",
+	}
+	item.ItemMetadata.Set(RelHasVendor, "other")
+	item.ItemMetadata.Set(RelHasLocation, "kitchen")
+
+	data, err := json.Marshal(item)
+	if err != nil {
+		t.Fatalf("Marshal failed expected err to be nil got %s", err.Error())
+	}
+
+	expected := `{"item-metadata":[{"rel":"urn:X-databox:rels:hasVendor","val":"other"},{"rel":"urn:X-databox:rels:isActuator","val":true},{"rel":"urn:X-databox:rels:hasUnit","val":"C"},{"rel":"urn:X-databox:rels:hasLocation","val":"kitchen"}],"href":"tcp://driver-test-core-store:5555/kv/test"}`
+	if string(data) != expected {
+		t.Errorf("Marshal expected %s got %s", expected, string(data))
+	}
+}
+
+// randomMetadata implements quick.Generator to create valid DataSourceMetadata for round trip tests
+type randomMetadata struct {
+	DataSourceMetadata
+}
+
+func (randomMetadata) Generate(r *rand.Rand, size int) reflect.Value {
+
+	str := func() string {
+		//avoid empty required values
+		return "v" + strconv.Itoa(r.Intn(1000000)) + string(rune('a'+r.Intn(26)))
+	}
+	optional := func() string {
+		if r.Intn(2) == 0 {
+			return ""
+		}
+		return str()
+	}
+
+	contentTypes := []StoreContentType{ContentTypeJSON, ContentTypeTEXT, ContentTypeBINARY}
+	storeTypes := []StoreType{StoreTypeTS, StoreTypeTSBlob, StoreTypeKV}
+
+	dm := DataSourceMetadata{
+		Description:    str(),
+		ContentType:    contentTypes[r.Intn(len(contentTypes))],
+		Vendor:         str(),
+		DataSourceType: str(),
+		DataSourceID:   str(),
+		StoreType:      storeTypes[r.Intn(len(storeTypes))],
+		IsActuator:     r.Intn(2) == 0,
+		IsFunc:         r.Intn(4) == 0,
+		Unit:           optional(),
+		Location:       optional(),
+	}
+	if dm.IsFunc {
+		dm.StoreType = StoreTypeFunc
+	}
+
+	for i := 0; i < r.Intn(size%5+1); i++ {
+		var val interface{} = str()
+		if r.Intn(2) == 0 {
+			val = r.Intn(2) == 0
+		}
+		dm.CustomRels = append(dm.CustomRels, RelVal{Rel: "urn:X-test:rels:" + str(), Val: val})
+	}
+
+	return reflect.ValueOf(randomMetadata{dm})
+}
+
+func TestDataSourceMetadataHypercatRoundTrip(t *testing.T) {
+
+	const endpoint = "tcp://driver-test-core-store:5555"
+
+	roundTrip := func(rm randomMetadata) bool {
+		item, err := DataSourceMetadataToHypercat(rm.DataSourceMetadata, endpoint)
+		if err != nil {
+			t.Logf("DataSourceMetadataToHypercat error %s", err.Error())
+			return false
+		}
+
+		//go via json like a real catalogue
+		data, err := json.Marshal(item)
+		if err != nil {
+			return false
+		}
+
+		dm, storeURL, err := HypercatToDataSourceMetadata(string(data))
+		if err != nil {
+			t.Logf("HypercatToDataSourceMetadata error %s", err.Error())
+			return false
+		}
+
+		if storeURL != endpoint {
+			t.Logf("expected store url %s got %s", endpoint, storeURL)
+			return false
+		}
+
+		if !reflect.DeepEqual(dm, rm.DataSourceMetadata) {
+			t.Logf("expected %+v got %+v", rm.DataSourceMetadata, dm)
+			return false
+		}
+
+		return true
+	}
+
+	err := quick.Check(roundTrip, &quick.Config{MaxCount: 500})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDataSourceMetadataToHypercatCustomRelClash(t *testing.T) {
+
+	dm := DataSourceMetadata{
+		Description:    "test",
+		ContentType:    ContentTypeJSON,
+		Vendor:         "test",
+		DataSourceType: "test",
+		DataSourceID:   "test",
+		StoreType:      StoreTypeKV,
+		CustomRels:     []RelVal{{Rel: RelHasUnit, Val: "C"}},
+	}
+
+	_, err := DataSourceMetadataToHypercat(dm, "tcp://driver-test-core-store:5555")
+	if err == nil {
+		t.Errorf("DataSourceMetadataToHypercat expected an error for a custom rel that clashes with %s", RelHasUnit)
+	}
+}
+
+func TestHypercatToDataSourceMetadataIsFunc(t *testing.T) {
+
+	dm, _, err := HypercatToDataSourceMetadata(`{"item-metadata":[{"rel":"urn:X-databox:rels:isFunc","val":true},{"rel":"urn:X-databox:rels:hasUnit","val":3}],"href":"tcp://driver-test-core-store:5555/request/test"}`)
+	if err != nil {
+		t.Fatalf("HypercatToDataSourceMetadata failed expected err to be nil got %s", err.Error())
+	}
+
+	if !dm.IsFunc || dm.IsActuator {
+		t.Errorf("HypercatToDataSourceMetadata expected IsFunc true and IsActuator false got %t and %t", dm.IsFunc, dm.IsActuator)
+	}
+
+	if dm.Unit != "3" {
+		t.Errorf("HypercatToDataSourceMetadata expected Unit 3 got %s", dm.Unit)
+	}
+}
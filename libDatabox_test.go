@@ -130,3 +130,38 @@ func TestRegisterDatasource(t *testing.T) {
 		t.Errorf("GetDatasourceCatalogue Error '%s' does not contain  %s", string(catByteArray), string(dsmdByteArray))
 	}
 }
+
+func TestGetStoreDataSourceCatalogues(t *testing.T) {
+
+	cats, err := StoreClient.GetStoreDataSourceCatalogues([]string{StoreURL, StoreURL + "/ts/" + dsID})
+	if err != nil {
+		t.Errorf("GetStoreDataSourceCatalogues failed expected err to be nil got %s", err.Error())
+	}
+
+	if len(cats) != 2 {
+		t.Errorf("GetStoreDataSourceCatalogues expected 2 catalogues got %d", len(cats))
+	}
+}
+
+func TestStoreClientReuse(t *testing.T) {
+
+	const otherStore = "tcp://127.0.0.2:5555"
+
+	zestC, err := StoreClient.storeClient(otherStore)
+	if err != nil {
+		t.Fatalf("storeClient failed expected err to be nil got %s", err.Error())
+	}
+
+	if zestC.Endpoint != otherStore || zestC.DealerEndpoint != "tcp://127.0.0.2:5556" {
+		t.Errorf("storeClient expected endpoints for %s got %s and %s", otherStore, zestC.Endpoint, zestC.DealerEndpoint)
+	}
+
+	if _, ok := StoreClient.storeClients[otherStore]; !ok {
+		t.Errorf("storeClient expected client for %s to be cached", otherStore)
+	}
+
+	own, _ := StoreClient.storeClient(StoreURL)
+	if own.Endpoint != StoreClient.ZestC.Endpoint {
+		t.Errorf("storeClient expected the clients own connection for %s", StoreURL)
+	}
+}
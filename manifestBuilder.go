@@ -0,0 +1,178 @@
+package libDatabox
+
+import (
+	"errors"
+	"strings"
+)
+
+// CurrentManifestVersion is the manifest-version written by ManifestBuilder and expected by this library
+const CurrentManifestVersion = 1
+
+// DefaultStoreType is the store requested in resource-requirements when none is given
+const DefaultStoreType = "core-store"
+
+// ManifestBuilder can be used to create a Manifest without writing the json by hand.
+// Setters can be chained and any problems are reported when Build is called.
+//
+//	manifest, err := libDatabox.NewManifestBuilder("my-cool-app", libDatabox.DataboxTypeApp).
+//		Description("An app that does cool things").
+//		Author("Me <me@example.com>").
+//		DataSource(libDatabox.DataSource{Type: "test-type", Clientid: "TEST", Required: true}).
+//		Build()
+type ManifestBuilder struct {
+	manifest Manifest
+	errs     []string
+}
+
+// NewManifestBuilder returns a ManifestBuilder for a component called name of type databoxType
+func NewManifestBuilder(name string, databoxType DataboxType) *ManifestBuilder {
+	return &ManifestBuilder{
+		manifest: Manifest{
+			ManifestVersion:   CurrentManifestVersion,
+			Name:              name,
+			DataboxType:       databoxType,
+			Tags:              []string{},
+			DataSources:       []DataSource{},
+			ExportWhitelists:  []ExportWhitelist{},
+			ExternalWhitelist: []ExternalWhitelist{},
+			Provides:          []DriverProvides{},
+		},
+	}
+}
+
+// Version sets the databox version the component was built for e.g 0.5.2
+func (b *ManifestBuilder) Version(version string) *ManifestBuilder {
+	b.manifest.Version = version
+	return b
+}
+
+// DisplayName sets the name shown to the user in the UI
+func (b *ManifestBuilder) DisplayName(name string) *ManifestBuilder {
+	b.manifest.DisplayName = name
+	return b
+}
+
+// Description sets the free text description
+func (b *ManifestBuilder) Description(description string) *ManifestBuilder {
+	b.manifest.Description = description
+	return b
+}
+
+// Author sets the author e.g Tosh Brown <Anthony.Brown@nottingham.ac.uk>
+func (b *ManifestBuilder) Author(author string) *ManifestBuilder {
+	b.manifest.Author = author
+	return b
+}
+
+// License sets the software licence
+func (b *ManifestBuilder) License(license string) *ManifestBuilder {
+	b.manifest.License = license
+	return b
+}
+
+// Tags adds search tags
+func (b *ManifestBuilder) Tags(tags ...string) *ManifestBuilder {
+	b.manifest.Tags = append(b.manifest.Tags, tags...)
+	return b
+}
+
+// Homepage sets the homepage url
+func (b *ManifestBuilder) Homepage(homepage string) *ManifestBuilder {
+	b.manifest.Homepage = homepage
+	return b
+}
+
+// Repository sets the repository where the source can be found
+func (b *ManifestBuilder) Repository(repoType string, url string) *ManifestBuilder {
+	b.manifest.Repository = Repository{Type: repoType, Url: url}
+	return b
+}
+
+// DockerImage sets the docker image, registry and tag. Empty values use the databox defaults.
+func (b *ManifestBuilder) DockerImage(registry string, image string, tag string) *ManifestBuilder {
+	b.manifest.DockerRegistry = registry
+	b.manifest.DockerImage = image
+	b.manifest.DockerImageTag = tag
+	return b
+}
+
+// DataSource adds a datasource request to the manifest
+func (b *ManifestBuilder) DataSource(ds DataSource) *ManifestBuilder {
+	if ds.Clientid == "" {
+		b.errs = append(b.errs, "datasource of type "+ds.Type+" has no clientid")
+	}
+	for _, existing := range b.manifest.DataSources {
+		if existing.Clientid == ds.Clientid {
+			b.errs = append(b.errs, "duplicate datasource clientid "+ds.Clientid)
+		}
+	}
+	if ds.Granularities == nil {
+		ds.Granularities = []string{}
+	}
+	b.manifest.DataSources = append(b.manifest.DataSources, ds)
+	return b
+}
+
+// Provides records a datasource type a driver makes available and the store type it is written to
+func (b *ManifestBuilder) Provides(dataSourceType string, description string, storeType StoreType) *ManifestBuilder {
+	b.manifest.Provides = append(b.manifest.Provides, DriverProvides{
+		Type:        dataSourceType,
+		Description: description,
+		StoreType:   string(storeType),
+	})
+	return b
+}
+
+// ExportWhitelist allows the component to export data to url using the export service
+func (b *ManifestBuilder) ExportWhitelist(url string, description string) *ManifestBuilder {
+	if url == "" {
+		b.errs = append(b.errs, "export-whitelist url must not be empty")
+	}
+	b.manifest.ExportWhitelists = append(b.manifest.ExportWhitelists, ExportWhitelist{Url: url, Description: description})
+	return b
+}
+
+// ExternalWhitelist allows the component to access urls outside of databox
+func (b *ManifestBuilder) ExternalWhitelist(description string, urls ...string) *ManifestBuilder {
+	if len(urls) == 0 {
+		b.errs = append(b.errs, "external-whitelist needs at least one url")
+	}
+	b.manifest.ExternalWhitelist = append(b.manifest.ExternalWhitelist, ExternalWhitelist{Urls: urls, Description: description})
+	return b
+}
+
+// Store requests a store in the resource-requirements, "core-store" is the only valid option for now.
+func (b *ManifestBuilder) Store(store string) *ManifestBuilder {
+	b.manifest.ResourceRequirements.Store = store
+	return b
+}
+
+// Build validates and returns the Manifest
+func (b *ManifestBuilder) Build() (Manifest, error) {
+
+	errs := append([]string{}, b.errs...)
+
+	if b.manifest.Name == "" {
+		errs = append(errs, "name is required")
+	}
+
+	switch b.manifest.DataboxType {
+	case DataboxTypeApp, DataboxTypeDriver, DataboxTypeStore:
+	default:
+		errs = append(errs, "invalid databox-type "+string(b.manifest.DataboxType))
+	}
+
+	if b.manifest.DataboxType != DataboxTypeDriver && len(b.manifest.Provides) > 0 {
+		errs = append(errs, "only drivers can provide datasources")
+	}
+
+	if b.manifest.DataboxType == DataboxTypeDriver && b.manifest.ResourceRequirements.Store == "" {
+		b.manifest.ResourceRequirements.Store = DefaultStoreType
+	}
+
+	if len(errs) > 0 {
+		return Manifest{}, errors.New("Invalid manifest: " + strings.Join(errs, ", "))
+	}
+
+	return b.manifest, nil
+}
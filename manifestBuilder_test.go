@@ -0,0 +1,56 @@
+package libDatabox
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestManifestBuilder(t *testing.T) {
+
+	manifest, err := NewManifestBuilder("test-driver", DataboxTypeDriver).
+		Version("0.5.2").
+		Description("A test driver").
+		Author("Test <test@example.com>").
+		Tags("test", "driver").
+		Provides("test-sensor", "readings from a test sensor", StoreTypeTSBlob).
+		ExternalWhitelist("test api", "https://api.example.com/").
+		Build()
+	if err != nil {
+		t.Fatalf("Build failed expected err to be nil got %s", err.Error())
+	}
+
+	if manifest.ManifestVersion != CurrentManifestVersion {
+		t.Errorf("Build expected manifest-version %d got %d", CurrentManifestVersion, manifest.ManifestVersion)
+	}
+
+	if manifest.ResourceRequirements.Store != DefaultStoreType {
+		t.Errorf("Build expected store %s got %s", DefaultStoreType, manifest.ResourceRequirements.Store)
+	}
+
+	data, _ := json.Marshal(manifest)
+	var decoded Manifest
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		t.Fatalf("Unmarshal failed expected err to be nil got %s", err.Error())
+	}
+	if decoded.Provides[0].StoreType != string(StoreTypeTSBlob) {
+		t.Errorf("Build expected store-type %s got %s", StoreTypeTSBlob, decoded.Provides[0].StoreType)
+	}
+}
+
+func TestManifestBuilderInvalid(t *testing.T) {
+
+	_, err := NewManifestBuilder("test-app", DataboxTypeApp).
+		DataSource(DataSource{Type: "test", Clientid: "TEST"}).
+		DataSource(DataSource{Type: "test", Clientid: "TEST"}).
+		Provides("test", "apps can't provide", StoreTypeKV).
+		Build()
+	if err == nil {
+		t.Errorf("Build expected an error for duplicate clientid and app provides")
+	}
+
+	_, err = NewManifestBuilder("", "not-a-type").Build()
+	if err == nil {
+		t.Errorf("Build expected an error for missing name and bad databox-type")
+	}
+}
@@ -0,0 +1,241 @@
+package libDatabox
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// MigrationReport describes what was changed when upgrading a manifest or SLA to CurrentManifestVersion
+type MigrationReport struct {
+	FromVersion int
+	ToVersion   int
+	Changes     []string
+}
+
+// Changed is true if the migration modified the document
+func (r MigrationReport) Changed() bool {
+	return len(r.Changes) > 0
+}
+
+// manifestMigration upgrades a raw manifest or SLA document from version from to version from+1.
+// apply returns a description of each change it made. Fixes that apply to documents of any version
+// belong in normaliseDocument instead.
+type manifestMigration struct {
+	from  int
+	apply func(doc map[string]interface{}) []string
+}
+
+// manifestMigrations must be kept in order, add a new entry here when CurrentManifestVersion is increased
+var manifestMigrations = []manifestMigration{
+	{from: 0, apply: migrateManifestV0},
+}
+
+// MigrateManifest upgrades a json encoded manifest written for an older manifest-version to the
+// current layout and decodes it.
+func MigrateManifest(data []byte) (Manifest, MigrationReport, error) {
+
+	migrated, report, err := migrateDocument(data)
+	if err != nil {
+		return Manifest{}, report, err
+	}
+
+	manifest := Manifest{}
+	err = json.Unmarshal(migrated, &manifest)
+	if err != nil {
+		return Manifest{}, report, errors.New("MigrateManifest: Error decoding manifest. " + err.Error())
+	}
+
+	return manifest, report, nil
+}
+
+// MigrateSLA upgrades a json encoded SLA written for an older manifest-version to the
+// current layout and decodes it.
+func MigrateSLA(data []byte) (SLA, MigrationReport, error) {
+
+	migrated, report, err := migrateDocument(data)
+	if err != nil {
+		return SLA{}, report, err
+	}
+
+	sla := SLA{}
+	err = json.Unmarshal(migrated, &sla)
+	if err != nil {
+		return SLA{}, report, errors.New("MigrateSLA: Error decoding SLA. " + err.Error())
+	}
+
+	return sla, report, nil
+}
+
+func migrateDocument(data []byte) ([]byte, MigrationReport, error) {
+
+	report := MigrationReport{ToVersion: CurrentManifestVersion}
+
+	doc := make(map[string]interface{})
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, report, errors.New("Error decoding document. " + err.Error())
+	}
+
+	version := 0
+	if v, ok := doc["manifest-version"].(float64); ok {
+		version = int(v)
+	}
+	report.FromVersion = version
+
+	if version > CurrentManifestVersion {
+		return nil, report, errors.New("manifest-version " + strconv.Itoa(version) + " is newer than supported version " + strconv.Itoa(CurrentManifestVersion))
+	}
+
+	for _, m := range manifestMigrations {
+		if m.from < version {
+			continue
+		}
+		report.Changes = append(report.Changes, m.apply(doc)...)
+		doc["manifest-version"] = m.from + 1
+		version = m.from + 1
+	}
+
+	if version != CurrentManifestVersion {
+		return nil, report, errors.New("No migration from manifest-version " + strconv.Itoa(version))
+	}
+
+	report.Changes = append(report.Changes, normaliseDocument(doc)...)
+
+	migrated, err := json.Marshal(doc)
+	if err != nil {
+		return nil, report, err
+	}
+
+	return migrated, report, nil
+}
+
+// migrateManifestV0 handles manifests written before manifest-version was used
+func migrateManifestV0(doc map[string]interface{}) []string {
+	return []string{"set manifest-version to 1"}
+}
+
+// normaliseDocument runs on documents of every version after they have been migrated. Datasources
+// and provides may be missing their store type or use the old tsblob name.
+func normaliseDocument(doc map[string]interface{}) []string {
+
+	changes := []string{}
+
+	datasources, _ := doc["datasources"].([]interface{})
+	for _, d := range datasources {
+		ds, ok := d.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		hypercat, ok := ds["hypercat"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		clientid, _ := ds["clientid"].(string)
+		change := migrateHypercatStoreType(hypercat)
+		if change != "" {
+			changes = append(changes, "datasource "+clientid+": "+change)
+		}
+	}
+
+	provides, _ := doc["provides"].([]interface{})
+	for _, p := range provides {
+		prov, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		st, _ := prov["store-type"].(string)
+		storeType, known := NormaliseStoreType(st)
+		if st == string(storeType) {
+			continue
+		}
+		prov["store-type"] = string(storeType)
+		dsType, _ := prov["data-source-type"].(string)
+		if known {
+			changes = append(changes, "provides "+dsType+": store-type "+st+" renamed to "+string(storeType))
+		} else {
+			changes = append(changes, "provides "+dsType+": store-type defaulted to "+string(storeType))
+		}
+	}
+
+	return changes
+}
+
+// migrateHypercatStoreType makes sure a raw hypercat item has a valid hasStoreType rel
+func migrateHypercatStoreType(hypercat map[string]interface{}) string {
+
+	if href, _ := hypercat["href"].(string); href == "" {
+		//not resolved yet nothing to do
+		return ""
+	}
+
+	metadata, _ := hypercat["item-metadata"].([]interface{})
+	if hypercatIsFunc(metadata) {
+		//functions are called through notifications and have no store type
+		return ""
+	}
+	for _, m := range metadata {
+		pair, ok := m.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		relKey, valKey := "rel", "val"
+		if _, ok := pair[relKey]; !ok {
+			relKey, valKey = "Rel", "Val"
+		}
+		if rel, _ := pair[relKey].(string); rel != RelHasStoreType {
+			continue
+		}
+		st, _ := pair[valKey].(string)
+		storeType, known := NormaliseStoreType(st)
+		if st == string(storeType) {
+			return ""
+		}
+		pair[valKey] = string(storeType)
+		if known {
+			return "hasStoreType " + st + " renamed to " + string(storeType)
+		}
+		return "hasStoreType " + st + " defaulted to " + string(storeType)
+	}
+
+	hypercat["item-metadata"] = append(metadata, map[string]interface{}{"rel": RelHasStoreType, "val": string(StoreTypeTSBlob)})
+	return "missing hasStoreType defaulted to " + string(StoreTypeTSBlob)
+}
+
+// hypercatIsFunc returns true if the raw item-metadata has the isFunc rel set to true
+func hypercatIsFunc(metadata []interface{}) bool {
+
+	for _, m := range metadata {
+		pair, ok := m.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		relKey, valKey := "rel", "val"
+		if _, ok := pair[relKey]; !ok {
+			relKey, valKey = "Rel", "Val"
+		}
+		if rel, _ := pair[relKey].(string); rel == RelIsFunc {
+			return valueBool(pair[valKey])
+		}
+	}
+
+	return false
+}
+
+// NormaliseStoreType maps the store type names used by current and older manifests to a StoreType.
+// Unknown or empty values are mapped to StoreTypeTSBlob, which is what most old SLAs used, and known is false.
+func NormaliseStoreType(storeType string) (st StoreType, known bool) {
+
+	switch storeType {
+	case string(StoreTypeKV):
+		return StoreTypeKV, true
+	case string(StoreTypeTS):
+		return StoreTypeTS, true
+	case string(StoreTypeTSBlob), "tsblob", "ts-blob", "blob":
+		return StoreTypeTSBlob, true
+	case string(StoreTypeFunc):
+		return StoreTypeFunc, true
+	}
+
+	return StoreTypeTSBlob, false
+}
@@ -0,0 +1,160 @@
+package libDatabox
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMigrateSLA(t *testing.T) {
+
+	oldSLA := `{
+		"name": "test-app",
+		"databox-type": "app",
+		"datasources": [
+			{
+				"type": "test",
+				"clientid": "MISSING",
+				"hypercat": {
+					"item-metadata": [{"rel": "urn:X-databox:rels:hasDatasourceid", "val": "a"}],
+					"href": "tcp://driver-test-core-store:5555/ts/blob/a"
+				}
+			},
+			{
+				"type": "test",
+				"clientid": "OLDNAME",
+				"hypercat": {
+					"item-metadata": [{"Rel": "urn:X-databox:rels:hasStoreType", "Val": "tsblob"}],
+					"href": "tcp://driver-test-core-store:5555/ts/blob/b"
+				}
+			},
+			{
+				"type": "test",
+				"clientid": "CURRENT",
+				"hypercat": {
+					"item-metadata": [{"rel": "urn:X-databox:rels:hasStoreType", "val": "kv"}],
+					"href": "tcp://driver-test-core-store:5555/kv/c"
+				}
+			}
+		]
+	}`
+
+	sla, report, err := MigrateSLA([]byte(oldSLA))
+	if err != nil {
+		t.Fatalf("MigrateSLA failed expected err to be nil got %s", err.Error())
+	}
+
+	if report.FromVersion != 0 || report.ToVersion != CurrentManifestVersion {
+		t.Errorf("MigrateSLA expected versions 0 -> %d got %d -> %d", CurrentManifestVersion, report.FromVersion, report.ToVersion)
+	}
+
+	if len(report.Changes) != 3 {
+		t.Errorf("MigrateSLA expected 3 changes got %v", report.Changes)
+	}
+
+	if sla.ManifestVersion != CurrentManifestVersion {
+		t.Errorf("MigrateSLA expected manifest-version %d got %d", CurrentManifestVersion, sla.ManifestVersion)
+	}
+
+	hypercatJSON, _ := json.Marshal(sla.Datasources[0].Hypercat)
+	dm, _, err := HypercatToDataSourceMetadata(string(hypercatJSON))
+	if err != nil {
+		t.Fatalf("HypercatToDataSourceMetadata failed expected err to be nil got %s", err.Error())
+	}
+	if dm.StoreType != StoreTypeTSBlob {
+		t.Errorf("MigrateSLA expected store type %s got %s", StoreTypeTSBlob, dm.StoreType)
+	}
+}
+
+func TestMigrateManifestCurrent(t *testing.T) {
+
+	_, report, err := MigrateManifest([]byte(`{"manifest-version": 1, "name": "test", "databox-type": "app"}`))
+	if err != nil {
+		t.Fatalf("MigrateManifest failed expected err to be nil got %s", err.Error())
+	}
+	if report.Changed() {
+		t.Errorf("MigrateManifest expected no changes got %v", report.Changes)
+	}
+
+	_, _, err = MigrateManifest([]byte(`{"manifest-version": 99, "name": "test"}`))
+	if err == nil {
+		t.Errorf("MigrateManifest expected an error for a newer manifest-version")
+	}
+}
+
+func TestMigrateSLANormalisesCurrentVersion(t *testing.T) {
+
+	sla := `{
+		"manifest-version": 1,
+		"name": "test-app",
+		"databox-type": "app",
+		"datasources": [
+			{
+				"type": "test",
+				"clientid": "MISSING",
+				"hypercat": {
+					"item-metadata": [{"rel": "urn:X-databox:rels:hasDatasourceid", "val": "a"}],
+					"href": "tcp://driver-test-core-store:5555/ts/blob/a"
+				}
+			},
+			{
+				"type": "test",
+				"clientid": "FUNC",
+				"hypercat": {
+					"item-metadata": [{"rel": "urn:X-databox:rels:isFunc", "val": true}],
+					"href": "tcp://driver-test-core-store:5555/notification/request/doThing"
+				}
+			},
+			{
+				"type": "test",
+				"clientid": "OLDFUNC",
+				"hypercat": {
+					"item-metadata": [{"rel": "urn:X-databox:rels:isFunc", "val": "true"}],
+					"href": "tcp://driver-test-core-store:5555/notification/request/doOtherThing"
+				}
+			}
+		]
+	}`
+
+	migrated, report, err := MigrateSLA([]byte(sla))
+	if err != nil {
+		t.Fatalf("MigrateSLA failed expected err to be nil got %s", err.Error())
+	}
+
+	if len(report.Changes) != 1 {
+		t.Errorf("MigrateSLA expected 1 change got %v", report.Changes)
+	}
+
+	hypercatJSON, _ := json.Marshal(migrated.Datasources[0].Hypercat)
+	dm, _, err := HypercatToDataSourceMetadata(string(hypercatJSON))
+	if err != nil {
+		t.Fatalf("HypercatToDataSourceMetadata failed expected err to be nil got %s", err.Error())
+	}
+	if dm.StoreType != StoreTypeTSBlob {
+		t.Errorf("MigrateSLA expected store type %s got %s", StoreTypeTSBlob, dm.StoreType)
+	}
+
+	for _, ds := range migrated.Datasources[1:] {
+		if st, ok := ds.Hypercat.ItemMetadata.Get(RelHasStoreType); ok {
+			t.Errorf("MigrateSLA expected no store type for function %s got %v", ds.Clientid, st)
+		}
+	}
+}
+
+func TestNormaliseStoreType(t *testing.T) {
+
+	tests := map[string]StoreType{
+		"kv":                   StoreTypeKV,
+		"ts":                   StoreTypeTS,
+		"ts/blob":              StoreTypeTSBlob,
+		"tsblob":               StoreTypeTSBlob,
+		"notification/request": StoreTypeFunc,
+		"":                     StoreTypeTSBlob,
+	}
+
+	for in, expected := range tests {
+		st, _ := NormaliseStoreType(in)
+		if st != expected {
+			t.Errorf("NormaliseStoreType(%s) expected %s got %s", in, expected, st)
+		}
+	}
+}
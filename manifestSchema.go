@@ -0,0 +1,129 @@
+package libDatabox
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+const jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// schemaEnums lists the allowed values of the string types used in manifests and SLAs
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeOf(DataboxType("")):      {string(DataboxTypeApp), string(DataboxTypeDriver), string(DataboxTypeStore)},
+	reflect.TypeOf(StoreType("")):        {string(StoreTypeTS), string(StoreTypeTSBlob), string(StoreTypeKV), string(StoreTypeFunc)},
+	reflect.TypeOf(StoreContentType("")): {string(ContentTypeJSON), string(ContentTypeTEXT), string(ContentTypeBINARY)},
+}
+
+// schemaRequired lists the json properties that must be present for each type
+var schemaRequired = map[reflect.Type][]string{
+	reflect.TypeOf(Manifest{}):   {"manifest-version", "name", "databox-type"},
+	reflect.TypeOf(SLA{}):        {"manifest-version", "name", "databox-type"},
+	reflect.TypeOf(DataSource{}): {"type", "clientid"},
+}
+
+// ManifestJSONSchema returns a JSON Schema document describing a Manifest
+func ManifestJSONSchema() ([]byte, error) {
+	return GenerateJSONSchema(Manifest{}, "Databox manifest")
+}
+
+// SLAJSONSchema returns a JSON Schema document describing an SLA
+func SLAJSONSchema() ([]byte, error) {
+	return GenerateJSONSchema(SLA{}, "Databox SLA")
+}
+
+// GenerateJSONSchema builds a JSON Schema (draft-07) document from the json tags of v.
+// Objects do not allow additional properties so misspelt keys are reported by validators.
+func GenerateJSONSchema(v interface{}, title string) ([]byte, error) {
+
+	schema := typeSchema(reflect.TypeOf(v))
+	schema["$schema"] = jsonSchemaDraft
+	schema["title"] = title
+
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if enum, ok := schemaEnums[t]; ok {
+		return map[string]interface{}{
+			"type": "string",
+			"enum": enum,
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Array:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": typeSchema(t.Elem()),
+		}
+	case reflect.Slice:
+		//nil slices are encoded as null
+		return map[string]interface{}{
+			"type":  []string{"array", "null"},
+			"items": typeSchema(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 []string{"object", "null"},
+			"additionalProperties": typeSchema(t.Elem()),
+		}
+	case reflect.Struct:
+		return structSchema(t)
+	}
+
+	//interface{} and anything else can hold any value
+	return map[string]interface{}{}
+}
+
+func structSchema(t reflect.Type) map[string]interface{} {
+
+	properties := make(map[string]interface{})
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			//unexported
+			continue
+		}
+
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			tagName := strings.Split(tag, ",")[0]
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+
+		properties[name] = typeSchema(field.Type)
+	}
+
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+
+	if required, ok := schemaRequired[t]; ok {
+		schema["required"] = required
+	}
+
+	return schema
+}
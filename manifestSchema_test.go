@@ -0,0 +1,182 @@
+package libDatabox
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestManifestJSONSchema(t *testing.T) {
+
+	data, err := ManifestJSONSchema()
+	if err != nil {
+		t.Fatalf("ManifestJSONSchema failed expected err to be nil got %s", err.Error())
+	}
+
+	var schema map[string]interface{}
+	err = json.Unmarshal(data, &schema)
+	if err != nil {
+		t.Fatalf("ManifestJSONSchema returned invalid json %s", err.Error())
+	}
+
+	if schema["additionalProperties"] != false {
+		t.Errorf("ManifestJSONSchema expected additionalProperties to be false")
+	}
+
+	properties := schema["properties"].(map[string]interface{})
+	datasources := properties["datasources"].(map[string]interface{})
+	items := datasources["items"].(map[string]interface{})
+	dsProperties := items["properties"].(map[string]interface{})
+	if _, ok := dsProperties["allow-notification-of-new-sources"]; !ok {
+		t.Errorf("ManifestJSONSchema datasource is missing allow-notification-of-new-sources")
+	}
+
+	databoxType := properties["databox-type"].(map[string]interface{})
+	if len(databoxType["enum"].([]interface{})) != 3 {
+		t.Errorf("ManifestJSONSchema expected databox-type enum got %v", databoxType)
+	}
+}
+
+func TestSLAJSONSchema(t *testing.T) {
+
+	data, err := SLAJSONSchema()
+	if err != nil {
+		t.Fatalf("SLAJSONSchema failed expected err to be nil got %s", err.Error())
+	}
+
+	var schema map[string]interface{}
+	err = json.Unmarshal(data, &schema)
+	if err != nil {
+		t.Fatalf("SLAJSONSchema returned invalid json %s", err.Error())
+	}
+
+	if schema["title"] != "Databox SLA" {
+		t.Errorf("SLAJSONSchema expected title got %v", schema["title"])
+	}
+}
+
+func TestBuiltManifestMatchesSchema(t *testing.T) {
+
+	manifest, err := NewManifestBuilder("test-app", DataboxTypeApp).Build()
+	if err != nil {
+		t.Fatalf("Build failed expected err to be nil got %s", err.Error())
+	}
+
+	data, _ := json.Marshal(manifest)
+	var doc interface{}
+	json.Unmarshal(data, &doc)
+
+	schemaData, _ := ManifestJSONSchema()
+	var schema map[string]interface{}
+	json.Unmarshal(schemaData, &schema)
+
+	for _, problem := range schemaProblems(schema, doc, "manifest") {
+		t.Errorf("built manifest does not match ManifestJSONSchema: %s", problem)
+	}
+
+	manifest.Tags = nil
+	data, _ = json.Marshal(manifest)
+	json.Unmarshal(data, &doc)
+	if problems := schemaProblems(schema, doc, "manifest"); len(problems) != 0 {
+		t.Errorf("manifest with null tags expected to match ManifestJSONSchema got %v", problems)
+	}
+}
+
+// schemaProblems checks value against the subset of JSON Schema produced by GenerateJSONSchema
+func schemaProblems(schema map[string]interface{}, value interface{}, path string) []string {
+
+	problems := []string{}
+
+	if types, ok := schema["type"]; ok {
+		allowed := []interface{}{types}
+		if list, ok := types.([]interface{}); ok {
+			allowed = list
+		}
+		matched := false
+		for _, allowedType := range allowed {
+			matched = matched || schemaTypeMatches(allowedType.(string), value)
+		}
+		if !matched {
+			return append(problems, path+" is not of type "+jsonString(types))
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			found = found || reflect.DeepEqual(allowed, value)
+		}
+		if !found {
+			problems = append(problems, path+" is not one of "+jsonString(enum))
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		for _, required := range asStrings(schema["required"]) {
+			if _, ok := v[required]; !ok {
+				problems = append(problems, path+"."+required+" is required")
+			}
+		}
+		for key, item := range v {
+			if property, ok := properties[key].(map[string]interface{}); ok {
+				problems = append(problems, schemaProblems(property, item, path+"."+key)...)
+			} else if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+				problems = append(problems, schemaProblems(additional, item, path+"."+key)...)
+			} else if schema["additionalProperties"] == false {
+				problems = append(problems, path+"."+key+" is not allowed")
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for _, item := range v {
+				problems = append(problems, schemaProblems(items, item, path+"[]")...)
+			}
+		}
+	}
+
+	return problems
+}
+
+func schemaTypeMatches(schemaType string, value interface{}) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == float64(int64(n))
+	case "null":
+		return value == nil
+	}
+	return false
+}
+
+func asStrings(v interface{}) []string {
+	strs := []string{}
+	list, _ := v.([]interface{})
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
+
+func jsonString(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,309 @@
+package libDatabox
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metric names recorded by the clients
+const (
+	MetricStoreRequests        = "databox_store_requests_total"
+	MetricStoreRequestDuration = "databox_store_request_duration_seconds"
+	MetricStoreObserveMessages = "databox_store_observe_messages_total"
+	MetricStoreCacheRequests   = "databox_store_cache_requests_total"
+	MetricArbiterTokenRequests = "databox_arbiter_token_requests_total"
+	MetricArbiterTokenDuration = "databox_arbiter_token_request_duration_seconds"
+	MetricFuncCalls            = "databox_func_calls_total"
+	MetricFuncCallDuration     = "databox_func_call_duration_seconds"
+	MetricFuncRequestsHandled  = "databox_func_requests_handled_total"
+	MetricFuncHandleDuration   = "databox_func_request_handle_duration_seconds"
+)
+
+var metricHelp = map[string]string{
+	MetricStoreRequests:        "Store requests by operation, store and status.",
+	MetricStoreRequestDuration: "Store request latency including getting an arbiter token.",
+	MetricStoreObserveMessages: "Messages received from store observe requests.",
+	MetricStoreCacheRequests:   "Reads looked up in the read cache by result (hit or miss).",
+	MetricArbiterTokenRequests: "Arbiter token requests by result (hit, miss or error).",
+	MetricArbiterTokenDuration: "Latency of token requests sent to the arbiter on a cache miss.",
+	MetricFuncCalls:            "Function calls made by Func.Call by function and status.",
+	MetricFuncCallDuration:     "Latency of function calls made by Func.Call.",
+	MetricFuncRequestsHandled:  "Function requests handled by registered functions.",
+	MetricFuncHandleDuration:   "Time taken by registered functions to handle a request.",
+}
+
+// DefaultLatencyBuckets are the histogram buckets in seconds used by NewPrometheusMetrics if none are given
+var DefaultLatencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// MetricLabels are the label names and values of a single metric series
+type MetricLabels map[string]string
+
+// Metrics receives measurements from CoreStoreClient and ArbiterClient. Implementations must be
+// safe for concurrent use. Use SetMetrics on the clients to enable it.
+type Metrics interface {
+	// AddCounter adds value to the counter name with labels
+	AddCounter(name string, labels MetricLabels, value float64)
+	// ObserveDuration records d in the latency histogram name with labels
+	ObserveDuration(name string, labels MetricLabels, d time.Duration)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) AddCounter(name string, labels MetricLabels, value float64)        {}
+func (nopMetrics) ObserveDuration(name string, labels MetricLabels, d time.Duration) {}
+
+// metricsRef lets the metrics of a client be replaced while requests are running
+type metricsRef struct {
+	v atomic.Value
+}
+
+type metricsBox struct {
+	m Metrics
+}
+
+func (r *metricsRef) get() Metrics {
+	if r == nil {
+		return nopMetrics{}
+	}
+	if box, ok := r.v.Load().(metricsBox); ok {
+		return box.m
+	}
+	return nopMetrics{}
+}
+
+func (r *metricsRef) set(m Metrics) {
+	if m == nil {
+		m = nopMetrics{}
+	}
+	r.v.Store(metricsBox{m})
+}
+
+func metricStatus(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// PrometheusMetrics is a Metrics that keeps everything in memory and serves it in the
+// Prometheus text exposition format, mount it on an http server to have it scraped.
+type PrometheusMetrics struct {
+	buckets    []float64
+	lock       *sync.Mutex
+	counters   map[string]map[string]*metricSeries
+	histograms map[string]map[string]*metricSeries
+}
+
+// metricSeries is a single counter (value) or histogram (counts, count and sum) series
+type metricSeries struct {
+	labels MetricLabels
+	value  float64
+	counts []uint64 //one per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewPrometheusMetrics returns an empty PrometheusMetrics using buckets (in seconds) for
+// latency histograms, if buckets is empty DefaultLatencyBuckets is used.
+func NewPrometheusMetrics(buckets []float64) *PrometheusMetrics {
+
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+
+	return &PrometheusMetrics{
+		buckets:    sorted,
+		lock:       &sync.Mutex{},
+		counters:   make(map[string]map[string]*metricSeries),
+		histograms: make(map[string]map[string]*metricSeries),
+	}
+}
+
+// AddCounter implements Metrics
+func (p *PrometheusMetrics) AddCounter(name string, labels MetricLabels, value float64) {
+
+	key := labelString(labels)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	series, ok := p.counters[name]
+	if !ok {
+		series = make(map[string]*metricSeries)
+		p.counters[name] = series
+	}
+	c, ok := series[key]
+	if !ok {
+		c = &metricSeries{labels: copyLabels(labels)}
+		series[key] = c
+	}
+	c.value += value
+}
+
+// ObserveDuration implements Metrics
+func (p *PrometheusMetrics) ObserveDuration(name string, labels MetricLabels, d time.Duration) {
+
+	key := labelString(labels)
+	seconds := d.Seconds()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	series, ok := p.histograms[name]
+	if !ok {
+		series = make(map[string]*metricSeries)
+		p.histograms[name] = series
+	}
+	h, ok := series[key]
+	if !ok {
+		h = &metricSeries{labels: copyLabels(labels), counts: make([]uint64, len(p.buckets))}
+		series[key] = h
+	}
+	for i, upper := range p.buckets {
+		if seconds <= upper {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// Counter returns the current value of a counter series, it is mainly useful in tests
+func (p *PrometheusMetrics) Counter(name string, labels MetricLabels) float64 {
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if c, ok := p.counters[name][labelString(labels)]; ok {
+		return c.value
+	}
+	return 0
+}
+
+// WriteTo writes all metrics in the Prometheus text format
+func (p *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+
+	var buf bytes.Buffer
+
+	p.lock.Lock()
+	for _, name := range metricNames(p.counters) {
+		writeMetricHeader(&buf, name, "counter")
+		series := p.counters[name]
+		for _, key := range seriesKeys(series) {
+			fmt.Fprintf(&buf, "%s%s %s\n", name, key, formatMetricValue(series[key].value))
+		}
+	}
+	for _, name := range metricNames(p.histograms) {
+		writeMetricHeader(&buf, name, "histogram")
+		series := p.histograms[name]
+		for _, key := range seriesKeys(series) {
+			h := series[key]
+			var cumulative uint64
+			for i, upper := range p.buckets {
+				cumulative += h.counts[i]
+				fmt.Fprintf(&buf, "%s_bucket%s %d\n", name, labelString(withLabel(h.labels, "le", formatMetricValue(upper))), cumulative)
+			}
+			fmt.Fprintf(&buf, "%s_bucket%s %d\n", name, labelString(withLabel(h.labels, "le", "+Inf")), h.count)
+			fmt.Fprintf(&buf, "%s_sum%s %s\n", name, key, formatMetricValue(h.sum))
+			fmt.Fprintf(&buf, "%s_count%s %d\n", name, key, h.count)
+		}
+	}
+	p.lock.Unlock()
+
+	return buf.WriteTo(w)
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.WriteTo(w)
+}
+
+func writeMetricHeader(buf *bytes.Buffer, name string, metricType string) {
+	if help, ok := metricHelp[name]; ok {
+		fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	}
+	fmt.Fprintf(buf, "# TYPE %s %s\n", name, metricType)
+}
+
+// labelString formats labels as {a="1",b="2"} sorted by name, it is also used as the series key
+func labelString(labels MetricLabels) string {
+
+	if len(labels) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escapeLabelValue(labels[name]) + `"`
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func copyLabels(labels MetricLabels) MetricLabels {
+	return withLabel(labels, "", "")
+}
+
+// withLabel returns a copy of labels with name set to value, an empty name just copies
+func withLabel(labels MetricLabels, name string, value string) MetricLabels {
+
+	c := make(MetricLabels, len(labels)+1)
+	for k, v := range labels {
+		c[k] = v
+	}
+	if name != "" {
+		c[name] = value
+	}
+
+	return c
+}
+
+func metricNames(m map[string]map[string]*metricSeries) []string {
+
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func seriesKeys(m map[string]*metricSeries) []string {
+
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
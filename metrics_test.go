@@ -0,0 +1,130 @@
+package libDatabox
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusMetricsCounter(t *testing.T) {
+
+	p := NewPrometheusMetrics(nil)
+	p.AddCounter(MetricStoreRequests, MetricLabels{"operation": "read", "status": "ok"}, 1)
+	p.AddCounter(MetricStoreRequests, MetricLabels{"status": "ok", "operation": "read"}, 2)
+	p.AddCounter(MetricStoreRequests, MetricLabels{"operation": "write", "status": "ok"}, 1)
+
+	if v := p.Counter(MetricStoreRequests, MetricLabels{"operation": "read", "status": "ok"}); v != 3 {
+		t.Errorf("Counter expected 3 got %v", v)
+	}
+	if v := p.Counter(MetricStoreRequests, MetricLabels{"operation": "delete"}); v != 0 {
+		t.Errorf("Counter for unknown series expected 0 got %v", v)
+	}
+}
+
+func TestPrometheusMetricsText(t *testing.T) {
+
+	p := NewPrometheusMetrics([]float64{0.1, 0.01})
+	p.AddCounter(MetricArbiterTokenRequests, MetricLabels{"result": "hit"}, 2)
+	p.ObserveDuration(MetricStoreRequestDuration, MetricLabels{"operation": "read"}, 5*time.Millisecond)
+	p.ObserveDuration(MetricStoreRequestDuration, MetricLabels{"operation": "read"}, 50*time.Millisecond)
+	p.ObserveDuration(MetricStoreRequestDuration, MetricLabels{"operation": "read"}, time.Second)
+	p.AddCounter("custom_total", MetricLabels{"path": "a\"b\\c"}, 1)
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("ServeHTTP expected prometheus content type got %s", ct)
+	}
+
+	body := rec.Body.String()
+	expected := []string{
+		"# TYPE custom_total counter\ncustom_total{path=\"a\\\"b\\\\c\"} 1\n",
+		"# HELP " + MetricArbiterTokenRequests + " ",
+		"# TYPE " + MetricArbiterTokenRequests + " counter\n" + MetricArbiterTokenRequests + "{result=\"hit\"} 2\n",
+		"# TYPE " + MetricStoreRequestDuration + " histogram\n",
+		MetricStoreRequestDuration + "_bucket{le=\"0.01\",operation=\"read\"} 1\n",
+		MetricStoreRequestDuration + "_bucket{le=\"0.1\",operation=\"read\"} 2\n",
+		MetricStoreRequestDuration + "_bucket{le=\"+Inf\",operation=\"read\"} 3\n",
+		MetricStoreRequestDuration + "_sum{operation=\"read\"} 1.055\n",
+		MetricStoreRequestDuration + "_count{operation=\"read\"} 3\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(body, e) {
+			t.Errorf("metrics output missing %q got:\n%s", e, body)
+		}
+	}
+}
+
+func TestStoreClientMetrics(t *testing.T) {
+
+	csc := NewCoreStoreClient(Arbiter, "", StoreURL, false)
+	p := NewPrometheusMetrics(nil)
+	csc.SetMetrics(p)
+	csc.Close()
+
+	csc.KVJSON.Read(dsID, "key")
+	csc.KVJSON.Write(dsID, "key", []byte("{}"))
+
+	for _, op := range []string{"read", "write"} {
+		if v := p.Counter(MetricStoreRequests, MetricLabels{"operation": op, "store": StoreURL, "status": "error"}); v != 1 {
+			t.Errorf("expected one failed %s got %v", op, v)
+		}
+	}
+
+	csc.SetMetrics(nil)
+	csc.KVJSON.Read(dsID, "key")
+	if v := p.Counter(MetricStoreRequests, MetricLabels{"operation": "read", "store": StoreURL, "status": "error"}); v != 1 {
+		t.Errorf("expected metrics to stop after SetMetrics(nil) got %v", v)
+	}
+}
+
+func TestFuncMetrics(t *testing.T) {
+
+	csc := NewCoreStoreClient(Arbiter, "", StoreURL, false)
+	p := NewPrometheusMetrics(nil)
+	csc.SetMetrics(p)
+
+	csc.FUNC.recordFunc(MetricFuncCalls, MetricFuncCallDuration, "add", FuncResponse{Status: FuncStatusOK}, time.Now(), nil)
+	csc.FUNC.recordFunc(MetricFuncCalls, MetricFuncCallDuration, "add", FuncResponse{Status: FuncStatusError}, time.Now(), nil)
+
+	if v := p.Counter(MetricFuncCalls, MetricLabels{"function": "add", "status": "ok"}); v != 1 {
+		t.Errorf("expected one ok call got %v", v)
+	}
+	if v := p.Counter(MetricFuncCalls, MetricLabels{"function": "add", "status": "error"}); v != 1 {
+		t.Errorf("expected one failed call got %v", v)
+	}
+}
+
+func TestArbiterTokenMetrics(t *testing.T) {
+
+	arb, err := newArbiterClient("secret", "", "")
+	if err != nil {
+		t.Fatalf("newArbiterClient failed expected err to be nil got %s", err.Error())
+	}
+	p := NewPrometheusMetrics(nil)
+	arb.SetMetrics(p)
+
+	//with no arbiter uri set token requests succeed without contacting an arbiter
+	for i := 0; i < 3; i++ {
+		_, err = arb.RequestToken(StoreURL+"/kv/"+dsID, "GET", "")
+		if err != nil {
+			t.Fatalf("RequestToken failed expected err to be nil got %s", err.Error())
+		}
+	}
+
+	if v := p.Counter(MetricArbiterTokenRequests, MetricLabels{"result": "miss"}); v != 1 {
+		t.Errorf("expected one cache miss got %v", v)
+	}
+	if v := p.Counter(MetricArbiterTokenRequests, MetricLabels{"result": "hit"}); v != 2 {
+		t.Errorf("expected two cache hits got %v", v)
+	}
+}
+
+func TestMetricStatus(t *testing.T) {
+	if metricStatus(nil) != "ok" || metricStatus(errors.New("failed")) != "error" {
+		t.Error("metricStatus returned the wrong status")
+	}
+}
@@ -0,0 +1,757 @@
+package libDatabox
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	zest "github.com/me-box/goZestClient"
+)
+
+// DefaultCheckpointInterval is how often a pipeline saves its checkpoint if no interval is given
+const DefaultCheckpointInterval = time.Second
+
+// PipelineCheckpointKeyPrefix is prepended to the name of a pipeline to name the key holding its checkpoint
+const PipelineCheckpointKeyPrefix = internalKeyPrefix + "pipeline."
+
+// DefaultFuncSinkTimeout is how long FuncSink waits for a function to return if no timeout is given
+const DefaultFuncSinkTimeout = 30 * time.Second
+
+// pipelineReplayPageSize is the number of records read per request when replaying from a checkpoint
+const pipelineReplayPageSize = 1000
+
+// PipelineSource is where a pipeline reads its records from, create one with TSSource, TSBlobSource or KVSource
+type PipelineSource struct {
+	dataSourceID string
+	observe      func() (<-chan ObserveResponse, func(), error)
+	replay       func(since int64) *TSIterator //nil if records can not be read again
+}
+
+// TSSource reads the records written to a TSStore datasource, records missed while the pipeline was
+// stopped are replayed from its checkpoint
+func TSSource(tsc TSStore, dataSourceID string) PipelineSource {
+	return PipelineSource{
+		dataSourceID: dataSourceID,
+		observe: func() (<-chan ObserveResponse, func(), error) {
+			return tsc.csc.observeWithCancel("/ts/"+dataSourceID, ContentTypeJSON, zest.ObserveModeData)
+		},
+		replay: func(since int64) *TSIterator {
+			return tsc.SinceIterator(dataSourceID, since, TimeSeriesQueryOptions{}, TSIteratorOptions{PageSize: pipelineReplayPageSize})
+		},
+	}
+}
+
+// TSBlobSource reads the records written to a TSBlobStore datasource, records missed while the pipeline
+// was stopped are replayed from its checkpoint. Replay only works for JSON datasources.
+func TSBlobSource(tbs *TSBlobStore, dataSourceID string) PipelineSource {
+	return PipelineSource{
+		dataSourceID: dataSourceID,
+		observe: func() (<-chan ObserveResponse, func(), error) {
+			return tbs.csc.observeWithCancel("/ts/blob/"+dataSourceID, tbs.contentType, zest.ObserveModeData)
+		},
+		replay: func(since int64) *TSIterator {
+			return tbs.SinceIterator(dataSourceID, since, TSIteratorOptions{PageSize: pipelineReplayPageSize})
+		},
+	}
+}
+
+// KVSource reads the writes to a KVStore datasource, Key is set on each record. Writes missed while
+// the pipeline was stopped are not replayed.
+func KVSource(kv *KVStore, dataSourceID string) PipelineSource {
+	return PipelineSource{
+		dataSourceID: dataSourceID,
+		observe: func() (<-chan ObserveResponse, func(), error) {
+			return kv.csc.observeFiltered("/kv/"+dataSourceID+"/*", kv.contentType, zest.ObserveModeData, isUserKey)
+		},
+	}
+}
+
+// PipelineSink receives the records that reach the end of a pipeline
+type PipelineSink func(record ObserveResponse) error
+
+// TSSink writes records to a TSStore datasource at their timestamp
+func TSSink(tsc TSStore, dataSourceID string) PipelineSink {
+	return func(record ObserveResponse) error {
+		if record.TimestampMS == 0 {
+			return tsc.Write(dataSourceID, record.Data)
+		}
+		return tsc.WriteAt(dataSourceID, record.TimestampMS, record.Data)
+	}
+}
+
+// TSBlobSink writes records to a TSBlobStore datasource at their timestamp
+func TSBlobSink(tbs *TSBlobStore, dataSourceID string) PipelineSink {
+	return func(record ObserveResponse) error {
+		if record.TimestampMS == 0 {
+			return tbs.Write(dataSourceID, record.Data)
+		}
+		return tbs.WriteAt(dataSourceID, record.TimestampMS, record.Data)
+	}
+}
+
+// KVSink writes records to a KVStore datasource under their Key
+func KVSink(kv *KVStore, dataSourceID string) PipelineSink {
+	return func(record ObserveResponse) error {
+		if record.Key == "" {
+			return errors.New("Error writing to " + dataSourceID + ": record has no key")
+		}
+		return kv.Write(dataSourceID, record.Key, record.Data)
+	}
+}
+
+// ExportSink exports records to destination with the export service, the data of each record must be json
+func ExportSink(e *Export, destination string) PipelineSink {
+	return func(record ObserveResponse) error {
+		_, err := e.Longpoll(destination, string(record.Data))
+		return err
+	}
+}
+
+// FuncSink calls functionName with each record and waits up to timeout for it to return, timeout <= 0
+// uses DefaultFuncSinkTimeout. A call that times out fails the record.
+func FuncSink(f *Func, functionName string, contentType StoreContentType, timeout time.Duration) PipelineSink {
+
+	if timeout <= 0 {
+		timeout = DefaultFuncSinkTimeout
+	}
+
+	return func(record ObserveResponse) error {
+		respChan, err := f.Call(functionName, record.Data, contentType)
+		if err != nil {
+			return err
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		var resp FuncResponse
+		var ok bool
+		select {
+		case resp, ok = <-respChan:
+		case <-timer.C:
+			return errors.New("Error calling " + functionName + ": timed out after " + timeout.String())
+		}
+		if !ok {
+			return errors.New("Error calling " + functionName + ": no response")
+		}
+		if resp.Status != FuncStatusOK {
+			return errors.New("Error calling " + functionName + ": status " + strconv.Itoa(int(resp.Status)) + " " + string(resp.Response))
+		}
+		return nil
+	}
+}
+
+// pipelineItem is a record and the source timestamp to checkpoint once it has been written to the sinks
+type pipelineItem struct {
+	record     ObserveResponse
+	checkpoint int64
+}
+
+type pipelineStage func(in <-chan pipelineItem) <-chan pipelineItem
+
+// pipelineJoin keeps the latest record of the source joined to a pipeline
+type pipelineJoin struct {
+	source PipelineSource
+	lock   *sync.Mutex
+	latest ObserveResponse
+	ok     bool
+}
+
+func (j *pipelineJoin) set(record ObserveResponse) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.latest = record
+	j.ok = true
+}
+
+func (j *pipelineJoin) get() (ObserveResponse, bool) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.latest, j.ok
+}
+
+// Pipeline reads records from a source, passes them through its operators in the order they were
+// added and writes the results to its sinks. Build it with NewPipeline and the operator methods then
+// call Start, for example
+//
+//	p := NewPipeline("celsius", TSSource(*csc.TSJSON, "temp")).
+//		Map(toCelsius).
+//		Window(time.Minute, average).
+//		To(TSSink(*csc.TSJSON, "tempCelsius")).
+//		Checkpoint(csc.KVJSON, "pipelines", 0)
+//	err := p.Start()
+//
+// Records that fail in an operator or sink are passed to the error handler set with OnError and
+// are not retried.
+type Pipeline struct {
+	name            string
+	source          PipelineSource
+	stages          []pipelineStage
+	joins           []*pipelineJoin
+	sinks           []PipelineSink
+	checkpointKV    *KVStore
+	checkpointDS    string
+	checkpointEvery time.Duration
+	onError         func(err error)
+
+	done       chan struct{}
+	closeOnce  *sync.Once
+	running    *sync.WaitGroup
+	stops      []func()
+	lock       *sync.Mutex
+	checkpoint int64
+	saved      int64
+	savedAt    time.Time
+}
+
+type pipelineCheckpoint struct {
+	TimestampMS int64 `json:"timestamp"`
+}
+
+// NewPipeline returns a pipeline reading from source, name is used as the key of its checkpoint
+func NewPipeline(name string, source PipelineSource) *Pipeline {
+	return &Pipeline{
+		name:   name,
+		source: source,
+		onError: func(err error) {
+			Warn("[Pipeline] " + err.Error())
+		},
+		done:      make(chan struct{}),
+		closeOnce: &sync.Once{},
+		running:   &sync.WaitGroup{},
+		lock:      &sync.Mutex{},
+	}
+}
+
+// Map replaces each record with the result of fn, records fn returns an error for are dropped
+func (p *Pipeline) Map(fn func(record ObserveResponse) (ObserveResponse, error)) *Pipeline {
+	p.stages = append(p.stages, func(in <-chan pipelineItem) <-chan pipelineItem {
+		return p.stage(in, func(item pipelineItem, out chan<- pipelineItem) bool {
+			record, err := fn(item.record)
+			if err != nil {
+				p.onError(errors.New("Error in map of " + p.name + ": " + err.Error()))
+				return true
+			}
+			item.record = record
+			return p.send(out, item)
+		})
+	})
+	return p
+}
+
+// Filter drops the records fn returns false for
+func (p *Pipeline) Filter(fn func(record ObserveResponse) bool) *Pipeline {
+	p.stages = append(p.stages, func(in <-chan pipelineItem) <-chan pipelineItem {
+		return p.stage(in, func(item pipelineItem, out chan<- pipelineItem) bool {
+			if !fn(item.record) {
+				return true
+			}
+			return p.send(out, item)
+		})
+	})
+	return p
+}
+
+// Window groups records into intervals of size aligned to the unix epoch by their timestamp and
+// replaces each group with the result of fn. A group is passed to fn when a record from a later
+// interval arrives or no record has arrived for size. fn returns false to drop the group, the
+// result is timestamped with the start of the interval if it has no timestamp. Records from an
+// interval that has already been passed on, including one passed on after a quiet period, are dropped.
+func (p *Pipeline) Window(size time.Duration, fn func(start int64, records []ObserveResponse) (ObserveResponse, bool)) *Pipeline {
+	p.stages = append(p.stages, func(in <-chan pipelineItem) <-chan pipelineItem {
+		return p.window(in, size, fn)
+	})
+	return p
+}
+
+// Join passes each record and the latest record received from other to fn and replaces the record
+// with the result. Records are dropped until other has received a record, if fn returns false or if
+// within > 0 and the two records are further apart than within. other is only observed, its records
+// are not replayed.
+func (p *Pipeline) Join(other PipelineSource, within time.Duration, fn func(record ObserveResponse, other ObserveResponse) (ObserveResponse, bool)) *Pipeline {
+
+	j := &pipelineJoin{source: other, lock: &sync.Mutex{}}
+	p.joins = append(p.joins, j)
+
+	withinMs := int64(within / time.Millisecond)
+	p.stages = append(p.stages, func(in <-chan pipelineItem) <-chan pipelineItem {
+		return p.stage(in, func(item pipelineItem, out chan<- pipelineItem) bool {
+			latest, ok := j.get()
+			if !ok {
+				return true
+			}
+			apart := item.record.TimestampMS - latest.TimestampMS
+			if withinMs > 0 && (apart > withinMs || apart < -withinMs) {
+				return true
+			}
+			record, ok := fn(item.record, latest)
+			if !ok {
+				return true
+			}
+			item.record = record
+			return p.send(out, item)
+		})
+	})
+	return p
+}
+
+// To adds sinks, each record that reaches the end of the pipeline is written to all of them
+func (p *Pipeline) To(sinks ...PipelineSink) *Pipeline {
+	p.sinks = append(p.sinks, sinks...)
+	return p
+}
+
+// Checkpoint saves the timestamp of the last source record written to the sinks in the KV datasource
+// dataSourceID at most every interval and when the pipeline is closed. When the pipeline starts again
+// the records since the checkpoint are replayed if the source supports it.
+func (p *Pipeline) Checkpoint(kv *KVStore, dataSourceID string, interval time.Duration) *Pipeline {
+	if interval <= 0 {
+		interval = DefaultCheckpointInterval
+	}
+	p.checkpointKV = kv
+	p.checkpointDS = dataSourceID
+	p.checkpointEvery = interval
+	return p
+}
+
+// OnError sets the function errors from operators and sinks are passed to, by default they are logged
+func (p *Pipeline) OnError(fn func(err error)) *Pipeline {
+	p.onError = fn
+	return p
+}
+
+// CheckpointTimestamp returns the timestamp of the last source record written to the sinks
+func (p *Pipeline) CheckpointTimestamp() int64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.checkpoint
+}
+
+// Start reads the checkpoint, observes the sources and starts processing records in the background
+func (p *Pipeline) Start() error {
+
+	if len(p.sinks) == 0 {
+		return errors.New("Error starting pipeline " + p.name + ": no sinks")
+	}
+
+	since := int64(0)
+	if p.checkpointKV != nil {
+		var err error
+		since, err = p.loadCheckpoint()
+		if err != nil {
+			return err
+		}
+	}
+
+	return p.run(since)
+}
+
+// Close stops the pipeline, waits for the record being written to finish and saves the checkpoint.
+// Records in a window that has not been passed on are dropped.
+func (p *Pipeline) Close() error {
+
+	p.closeOnce.Do(func() {
+		close(p.done)
+		for _, stop := range p.stops {
+			stop()
+		}
+	})
+	p.running.Wait()
+
+	return p.saveCheckpoint(true)
+}
+
+// run starts the pipeline replaying the records after since
+func (p *Pipeline) run(since int64) error {
+
+	p.checkpoint = since
+	p.saved = since
+	p.savedAt = time.Now()
+
+	live, stop, err := p.source.observe()
+	if err != nil {
+		return errors.New("Error starting pipeline " + p.name + ": " + err.Error())
+	}
+	p.stops = append(p.stops, stop)
+
+	for _, j := range p.joins {
+		err := p.startJoin(j)
+		if err != nil {
+			p.Close()
+			return errors.New("Error starting pipeline " + p.name + ": " + err.Error())
+		}
+	}
+
+	items := p.produce(live, since)
+	for _, stage := range p.stages {
+		items = stage(items)
+	}
+
+	p.running.Add(1)
+	go func() {
+		defer p.running.Done()
+		p.deliver(items)
+	}()
+
+	return nil
+}
+
+func (p *Pipeline) startJoin(j *pipelineJoin) error {
+
+	records, stop, err := j.source.observe()
+	if err != nil {
+		return err
+	}
+	p.stops = append(p.stops, stop)
+
+	p.running.Add(1)
+	go func() {
+		defer p.running.Done()
+		for record := range records {
+			j.set(record)
+		}
+	}()
+
+	return nil
+}
+
+// produce replays the source records after since and then passes on the observed records. Records
+// observed during the replay are kept in memory until it finishes.
+func (p *Pipeline) produce(live <-chan ObserveResponse, since int64) <-chan pipelineItem {
+
+	out := make(chan pipelineItem)
+
+	p.running.Add(1)
+	go func() {
+		defer p.running.Done()
+		defer close(out)
+
+		var replayed *replayMark
+		if since > 0 && p.source.replay != nil {
+			var ok bool
+			replayed, ok = p.replay(out, live, since)
+			if !ok {
+				return
+			}
+		}
+
+		for {
+			select {
+			case <-p.done:
+				return
+			case record, ok := <-live:
+				if !ok {
+					return
+				}
+				if !p.sendLive(out, record, replayed) {
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+// replay sends the source records after since while buffering live, then sends the buffered records
+// that were not replayed. It returns what was replayed and false if the pipeline stopped or live was closed.
+func (p *Pipeline) replay(out chan<- pipelineItem, live <-chan ObserveResponse, since int64) (*replayMark, bool) {
+
+	buffered := bufferRecords(live)
+
+	//records at the checkpoint were all written to the sinks before the pipeline stopped
+	replayed := &replayMark{timestamp: since}
+	it := p.source.replay(since + 1)
+	for it.Next() {
+		record := it.Record()
+		item := pipelineItem{
+			record:     ObserveResponse{TimestampMS: record.TimestampMS, DataSourceID: p.source.dataSourceID, Data: record.Data},
+			checkpoint: record.TimestampMS,
+		}
+		if !p.send(out, item) {
+			it.Close()
+			buffered()
+			return replayed, false
+		}
+		replayed.add(item.record)
+	}
+	it.Close()
+	if it.Err() != nil {
+		p.onError(errors.New("Error replaying " + p.source.dataSourceID + ": " + it.Err().Error()))
+	}
+
+	records, closed := buffered()
+	for _, record := range records {
+		if !p.sendLive(out, record, replayed) {
+			return replayed, false
+		}
+	}
+
+	return replayed, !closed
+}
+
+// sendLive sends an observed record unless the replay already sent it
+func (p *Pipeline) sendLive(out chan<- pipelineItem, record ObserveResponse, replayed *replayMark) bool {
+	if replayed.seen(record) {
+		return true
+	}
+	return p.send(out, pipelineItem{record: record, checkpoint: record.TimestampMS})
+}
+
+// replayMark is the timestamp of the last replayed record and the data of the records replayed at it,
+// data is nil if all records at timestamp were sent. Observed records are compared by timestamp and
+// data so records sharing the last millisecond that were not replayed are still sent.
+type replayMark struct {
+	timestamp int64
+	data      map[string]int
+}
+
+func (m *replayMark) add(record ObserveResponse) {
+	if record.TimestampMS != m.timestamp || m.data == nil {
+		m.timestamp = record.TimestampMS
+		m.data = make(map[string]int)
+	}
+	m.data[replayKey(record.Data)]++
+}
+
+// seen returns true if record was replayed, each replayed record matches one observed record
+func (m *replayMark) seen(record ObserveResponse) bool {
+
+	switch {
+	case m == nil || record.TimestampMS > m.timestamp:
+		return false
+	case record.TimestampMS < m.timestamp || m.data == nil:
+		return true
+	}
+
+	key := replayKey(record.Data)
+	if m.data[key] == 0 {
+		return false
+	}
+	m.data[key]--
+
+	return true
+}
+
+// replayKey compares json data ignoring formatting as replayed records are re-encoded by the store
+func replayKey(data []byte) string {
+	var compact bytes.Buffer
+	if json.Compact(&compact, data) == nil {
+		return compact.String()
+	}
+	return string(data)
+}
+
+// bufferRecords reads live into memory until the returned function is called, it returns the records
+// read and whether live was closed
+func bufferRecords(live <-chan ObserveResponse) func() ([]ObserveResponse, bool) {
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	records := []ObserveResponse{}
+	closed := false
+
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			case record, ok := <-live:
+				if !ok {
+					closed = true
+					return
+				}
+				records = append(records, record)
+			}
+		}
+	}()
+
+	stopOnce := &sync.Once{}
+	return func() ([]ObserveResponse, bool) {
+		stopOnce.Do(func() {
+			close(stop)
+		})
+		<-done
+		return records, closed
+	}
+}
+
+// stage runs process on each item from in in its own goroutine, process returns false to stop
+func (p *Pipeline) stage(in <-chan pipelineItem, process func(item pipelineItem, out chan<- pipelineItem) bool) <-chan pipelineItem {
+
+	out := make(chan pipelineItem)
+
+	p.running.Add(1)
+	go func() {
+		defer p.running.Done()
+		defer close(out)
+		for item := range in {
+			if !process(item, out) {
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+func (p *Pipeline) window(in <-chan pipelineItem, size time.Duration, fn func(start int64, records []ObserveResponse) (ObserveResponse, bool)) <-chan pipelineItem {
+
+	out := make(chan pipelineItem)
+	sizeMs := int64(size / time.Millisecond)
+	if sizeMs < 1 {
+		sizeMs = 1
+	}
+
+	p.running.Add(1)
+	go func() {
+		defer p.running.Done()
+		defer close(out)
+
+		ticker := time.NewTicker(time.Duration(sizeMs) * time.Millisecond)
+		defer ticker.Stop()
+
+		start := int64(0)
+		records := []ObserveResponse{}
+		checkpoint := int64(0)
+		lastArrival := time.Now()
+		passed := false //the interval at start has been passed on
+
+		flush := func() bool {
+			if len(records) == 0 {
+				return true
+			}
+			passed = true
+			record, ok := fn(start, records)
+			records = []ObserveResponse{}
+			if !ok {
+				return true
+			}
+			if record.TimestampMS == 0 {
+				record.TimestampMS = start
+			}
+			return p.send(out, pipelineItem{record: record, checkpoint: checkpoint})
+		}
+
+		for {
+			select {
+			case <-p.done:
+				return
+			case item, ok := <-in:
+				if !ok {
+					return
+				}
+				itemStart := windowStart(item.record.TimestampMS, sizeMs)
+				if itemStart < start || itemStart == start && passed {
+					Debug("[Pipeline] dropping late record for window " + strconv.FormatInt(itemStart, 10))
+					continue
+				}
+				if itemStart > start {
+					if !flush() {
+						return
+					}
+					start = itemStart
+					passed = false
+				}
+				records = append(records, item.record)
+				if item.checkpoint > checkpoint {
+					checkpoint = item.checkpoint
+				}
+				lastArrival = time.Now()
+			case <-ticker.C:
+				if time.Since(lastArrival) >= size && !flush() {
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+// deliver writes each item to the sinks and advances the checkpoint once all of them succeed
+func (p *Pipeline) deliver(in <-chan pipelineItem) {
+
+	for item := range in {
+		failed := false
+		for _, sink := range p.sinks {
+			err := sink(item.record)
+			if err != nil {
+				p.onError(errors.New("Error in sink of " + p.name + ": " + err.Error()))
+				failed = true
+			}
+		}
+		if failed {
+			continue
+		}
+
+		p.lock.Lock()
+		if item.checkpoint > p.checkpoint {
+			p.checkpoint = item.checkpoint
+		}
+		p.lock.Unlock()
+
+		err := p.saveCheckpoint(false)
+		if err != nil {
+			p.onError(err)
+		}
+	}
+}
+
+func (p *Pipeline) send(out chan<- pipelineItem, item pipelineItem) bool {
+	select {
+	case out <- item:
+		return true
+	case <-p.done:
+		return false
+	}
+}
+
+func (p *Pipeline) loadCheckpoint() (int64, error) {
+
+	data, err := p.checkpointKV.Read(p.checkpointDS, PipelineCheckpointKeyPrefix+p.name)
+	if err != nil {
+		return 0, errors.New("Error reading checkpoint of " + p.name + ": " + err.Error())
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	checkpoint := pipelineCheckpoint{}
+	err = json.Unmarshal(data, &checkpoint)
+	if err != nil {
+		return 0, errors.New("Error decoding checkpoint of " + p.name + ": " + err.Error())
+	}
+
+	return checkpoint.TimestampMS, nil
+}
+
+// saveCheckpoint writes the checkpoint if it has changed and checkpointEvery has passed or force is true
+func (p *Pipeline) saveCheckpoint(force bool) error {
+
+	if p.checkpointKV == nil {
+		return nil
+	}
+
+	p.lock.Lock()
+	checkpoint := p.checkpoint
+	due := checkpoint != p.saved && (force || time.Since(p.savedAt) >= p.checkpointEvery)
+	p.lock.Unlock()
+	if !due {
+		return nil
+	}
+
+	err := p.checkpointKV.writeJSON(p.checkpointDS, PipelineCheckpointKeyPrefix+p.name, pipelineCheckpoint{TimestampMS: checkpoint})
+	if err != nil {
+		return errors.New("Error saving checkpoint of " + p.name + ": " + err.Error())
+	}
+
+	p.lock.Lock()
+	p.saved = checkpoint
+	p.savedAt = time.Now()
+	p.lock.Unlock()
+
+	return nil
+}
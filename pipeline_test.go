@@ -0,0 +1,380 @@
+package libDatabox
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePipelineSource returns a source fed from the returned channel that replays records
+func fakePipelineSource(replay []int64) (PipelineSource, chan ObserveResponse) {
+
+	live := make(chan ObserveResponse)
+	source := PipelineSource{
+		dataSourceID: "source",
+		observe: func() (<-chan ObserveResponse, func(), error) {
+			out := make(chan ObserveResponse)
+			stop := make(chan struct{})
+			once := &sync.Once{}
+			go func() {
+				defer close(out)
+				for {
+					select {
+					case record := <-live:
+						select {
+						case out <- record:
+						case <-stop:
+							return
+						}
+					case <-stop:
+						return
+					}
+				}
+			}()
+			return out, func() { once.Do(func() { close(stop) }) }, nil
+		},
+		replay: func(since int64) *TSIterator {
+			return newTSIterator(since, since+1000, TSIteratorOptions{}, func(from int64, to int64) ([]byte, error) {
+				records := []string{}
+				for _, ts := range replay {
+					if ts >= from && ts <= to {
+						records = append(records, `{"timestamp":`+strconv.FormatInt(ts, 10)+`,"data":{"value":`+strconv.FormatInt(ts, 10)+`}}`)
+					}
+				}
+				return []byte("[" + strings.Join(records, ",") + "]"), nil
+			})
+		},
+	}
+
+	return source, live
+}
+
+// collectingSink records what it receives and signals each record on received
+type collectingSink struct {
+	lock     *sync.Mutex
+	records  []ObserveResponse
+	received chan struct{}
+}
+
+func newCollectingSink() *collectingSink {
+	return &collectingSink{lock: &sync.Mutex{}, received: make(chan struct{}, 100)}
+}
+
+func (s *collectingSink) sink(record ObserveResponse) error {
+	s.lock.Lock()
+	s.records = append(s.records, record)
+	s.lock.Unlock()
+	s.received <- struct{}{}
+	return nil
+}
+
+func (s *collectingSink) wait(t *testing.T, n int) []ObserveResponse {
+	for i := 0; i < n; i++ {
+		select {
+		case <-s.received:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("sink expected %d records got %d", n, i)
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]ObserveResponse{}, s.records...)
+}
+
+func recordValue(record ObserveResponse) int64 {
+	value := struct {
+		Value int64 `json:"value"`
+	}{}
+	json.Unmarshal(record.Data, &value)
+	return value.Value
+}
+
+func TestPipelineMapFilter(t *testing.T) {
+
+	source, live := fakePipelineSource(nil)
+	sink := newCollectingSink()
+
+	p := NewPipeline("mapfilter", source).
+		Filter(func(record ObserveResponse) bool {
+			return recordValue(record)%2 == 0
+		}).
+		Map(func(record ObserveResponse) (ObserveResponse, error) {
+			if recordValue(record) == 4 {
+				return record, errors.New("bad record")
+			}
+			record.Data = []byte(`{"value":` + strconv.FormatInt(recordValue(record)*10, 10) + `}`)
+			return record, nil
+		}).
+		To(sink.sink)
+
+	errs := make(chan error, 10)
+	p.OnError(func(err error) { errs <- err })
+
+	err := p.run(0)
+	if err != nil {
+		t.Fatalf("run failed expected err to be nil got %s", err.Error())
+	}
+	for i := int64(1); i <= 6; i++ {
+		live <- ObserveResponse{TimestampMS: i, Data: []byte(`{"value":` + strconv.FormatInt(i, 10) + `}`)}
+	}
+
+	records := sink.wait(t, 2)
+	p.Close()
+
+	if recordValue(records[0]) != 20 || recordValue(records[1]) != 60 {
+		t.Errorf("pipeline expected values 20 and 60 got %s %s", records[0].Data, records[1].Data)
+	}
+	if len(errs) != 1 {
+		t.Errorf("pipeline expected one error from map got %d", len(errs))
+	}
+	if p.CheckpointTimestamp() != 6 {
+		t.Errorf("CheckpointTimestamp expected 6 got %d", p.CheckpointTimestamp())
+	}
+}
+
+func TestPipelineReplay(t *testing.T) {
+
+	source, live := fakePipelineSource([]int64{5, 10, 11, 12})
+	sink := newCollectingSink()
+
+	p := NewPipeline("replay", source).To(sink.sink)
+	err := p.run(10)
+	if err != nil {
+		t.Fatalf("run failed expected err to be nil got %s", err.Error())
+	}
+
+	//12 was replayed so only 13 should be passed on
+	live <- ObserveResponse{TimestampMS: 12, Data: []byte(`{"value":12}`)}
+	live <- ObserveResponse{TimestampMS: 13, Data: []byte(`{"value":13}`)}
+
+	records := sink.wait(t, 3)
+	p.Close()
+
+	got := []int64{}
+	for _, record := range records {
+		got = append(got, record.TimestampMS)
+	}
+	if len(got) != 3 || got[0] != 11 || got[1] != 12 || got[2] != 13 {
+		t.Errorf("pipeline expected records 11 12 13 got %v", got)
+	}
+}
+
+func TestPipelineBuffersDuringReplay(t *testing.T) {
+
+	source, live := fakePipelineSource([]int64{11, 12})
+	replay := source.replay
+	release := make(chan struct{})
+	source.replay = func(since int64) *TSIterator {
+		it := replay(since)
+		fetch := it.fetch
+		it.fetch = func(from int64, to int64) ([]byte, error) {
+			<-release
+			return fetch(from, to)
+		}
+		return it
+	}
+	sink := newCollectingSink()
+
+	p := NewPipeline("buffer", source).To(sink.sink)
+	err := p.run(10)
+	if err != nil {
+		t.Fatalf("run failed expected err to be nil got %s", err.Error())
+	}
+
+	//the replay is blocked so these are only accepted if they are buffered
+	for _, ts := range []int64{12, 13} {
+		select {
+		case live <- ObserveResponse{TimestampMS: ts, Data: []byte(`{"value":` + strconv.FormatInt(ts, 10) + `}`)}:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("pipeline did not read live record %d during the replay", ts)
+		}
+	}
+	close(release)
+
+	records := sink.wait(t, 3)
+	p.Close()
+
+	got := []int64{}
+	for _, record := range records {
+		got = append(got, record.TimestampMS)
+	}
+	if len(got) != 3 || got[0] != 11 || got[1] != 12 || got[2] != 13 {
+		t.Errorf("pipeline expected records 11 12 13 got %v", got)
+	}
+}
+
+func TestPipelineWindow(t *testing.T) {
+
+	source, live := fakePipelineSource(nil)
+	sink := newCollectingSink()
+
+	p := NewPipeline("window", source).
+		Window(50*time.Millisecond, func(start int64, records []ObserveResponse) (ObserveResponse, bool) {
+			total := int64(0)
+			for _, record := range records {
+				total += recordValue(record)
+			}
+			return ObserveResponse{Data: []byte(`{"value":` + strconv.FormatInt(total, 10) + `}`)}, true
+		}).
+		To(sink.sink)
+
+	err := p.run(0)
+	if err != nil {
+		t.Fatalf("run failed expected err to be nil got %s", err.Error())
+	}
+
+	for _, ts := range []int64{1000, 1010, 1049, 1050, 1020, 1075} {
+		live <- ObserveResponse{TimestampMS: ts, Data: []byte(`{"value":1}`)}
+	}
+
+	//the second window is passed on once no records arrive for the window size
+	records := sink.wait(t, 2)
+	p.Close()
+
+	if records[0].TimestampMS != 1000 || recordValue(records[0]) != 3 {
+		t.Errorf("first window expected 3 records at 1000 got %d at %d", recordValue(records[0]), records[0].TimestampMS)
+	}
+	if records[1].TimestampMS != 1050 || recordValue(records[1]) != 2 {
+		t.Errorf("second window expected 2 records at 1050 got %d at %d", recordValue(records[1]), records[1].TimestampMS)
+	}
+}
+
+func TestPipelineWindowAfterQuietFlush(t *testing.T) {
+
+	source, live := fakePipelineSource(nil)
+	sink := newCollectingSink()
+
+	p := NewPipeline("quiet", source).
+		Window(20*time.Millisecond, func(start int64, records []ObserveResponse) (ObserveResponse, bool) {
+			return ObserveResponse{Data: []byte(`{"value":` + strconv.Itoa(len(records)) + `}`)}, true
+		}).
+		To(sink.sink)
+
+	err := p.run(0)
+	if err != nil {
+		t.Fatalf("run failed expected err to be nil got %s", err.Error())
+	}
+
+	live <- ObserveResponse{TimestampMS: 1000, Data: []byte(`{"value":1}`)}
+	sink.wait(t, 1)
+
+	//1005 is in the interval already passed on, 1020 starts the next one
+	live <- ObserveResponse{TimestampMS: 1005, Data: []byte(`{"value":1}`)}
+	live <- ObserveResponse{TimestampMS: 1020, Data: []byte(`{"value":1}`)}
+	records := sink.wait(t, 1)
+	p.Close()
+
+	if len(records) != 2 || records[1].TimestampMS != 1020 || recordValue(records[1]) != 1 {
+		t.Errorf("window after a quiet flush expected the next window at 1020 got %+v", records)
+	}
+}
+
+func TestReplayMark(t *testing.T) {
+
+	mark := &replayMark{timestamp: 10}
+	if !mark.seen(ObserveResponse{TimestampMS: 10, Data: []byte(`{"a":1}`)}) {
+		t.Error("seen expected records at the checkpoint to have been sent")
+	}
+
+	mark.add(ObserveResponse{TimestampMS: 12, Data: []byte(`{"a": 1}`)})
+	tests := []struct {
+		record ObserveResponse
+		seen   bool
+	}{
+		{ObserveResponse{TimestampMS: 11, Data: []byte(`{"a":9}`)}, true},
+		{ObserveResponse{TimestampMS: 12, Data: []byte(`{"a":1}`)}, true},
+		{ObserveResponse{TimestampMS: 12, Data: []byte(`{"a":1}`)}, false}, //only one was replayed
+		{ObserveResponse{TimestampMS: 12, Data: []byte(`{"a":2}`)}, false},
+		{ObserveResponse{TimestampMS: 13, Data: []byte(`{"a":1}`)}, false},
+	}
+	for _, test := range tests {
+		if got := mark.seen(test.record); got != test.seen {
+			t.Errorf("seen(%d %s) expected %t got %t", test.record.TimestampMS, test.record.Data, test.seen, got)
+		}
+	}
+
+	var none *replayMark
+	if none.seen(ObserveResponse{TimestampMS: 1}) {
+		t.Error("seen expected false without a replay")
+	}
+}
+
+func TestPipelineJoin(t *testing.T) {
+
+	source, live := fakePipelineSource(nil)
+	other, otherLive := fakePipelineSource(nil)
+	sink := newCollectingSink()
+
+	p := NewPipeline("join", source).
+		Join(other, 100*time.Millisecond, func(record ObserveResponse, other ObserveResponse) (ObserveResponse, bool) {
+			record.Data = []byte(`{"value":` + strconv.FormatInt(recordValue(record)+recordValue(other), 10) + `}`)
+			return record, true
+		}).
+		To(sink.sink)
+
+	err := p.run(0)
+	if err != nil {
+		t.Fatalf("run failed expected err to be nil got %s", err.Error())
+	}
+
+	live <- ObserveResponse{TimestampMS: 1000, Data: []byte(`{"value":1}`)} //nothing to join with yet
+	time.Sleep(20 * time.Millisecond)
+	otherLive <- ObserveResponse{TimestampMS: 1000, Data: []byte(`{"value":10}`)}
+	time.Sleep(20 * time.Millisecond)
+	live <- ObserveResponse{TimestampMS: 1050, Data: []byte(`{"value":2}`)}
+	live <- ObserveResponse{TimestampMS: 1500, Data: []byte(`{"value":3}`)} //too far apart
+
+	records := sink.wait(t, 1)
+	time.Sleep(20 * time.Millisecond)
+	p.Close()
+
+	if len(sink.records) != 1 || recordValue(records[0]) != 12 {
+		t.Errorf("join expected one record with value 12 got %v", sink.records)
+	}
+}
+
+func TestPipelineNoSinks(t *testing.T) {
+
+	source, _ := fakePipelineSource(nil)
+	err := NewPipeline("nosinks", source).Start()
+	if err == nil {
+		t.Error("Start expected an error without sinks")
+	}
+}
+
+func TestKVSinkRequiresKey(t *testing.T) {
+
+	err := KVSink(StoreClient.KVJSON, dsID)(ObserveResponse{Data: []byte("{}")})
+	if err == nil {
+		t.Error("KVSink expected an error for a record without a key")
+	}
+}
+
+func TestPipelineCheckpoint(t *testing.T) {
+
+	source, live := fakePipelineSource(nil)
+	sink := newCollectingSink()
+	name := "checkpoint" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	p := NewPipeline(name, source).To(sink.sink).Checkpoint(StoreClient.KVJSON, dsID, time.Hour)
+	err := p.Start()
+	if err != nil {
+		t.Fatalf("Start failed expected err to be nil got %s", err.Error())
+	}
+	live <- ObserveResponse{TimestampMS: 42, Data: []byte(`{"value":42}`)}
+	sink.wait(t, 1)
+	err = p.Close()
+	if err != nil {
+		t.Fatalf("Close failed expected err to be nil got %s", err.Error())
+	}
+
+	restarted := NewPipeline(name, source).To(sink.sink).Checkpoint(StoreClient.KVJSON, dsID, time.Hour)
+	since, err := restarted.loadCheckpoint()
+	if err != nil || since != 42 {
+		t.Errorf("loadCheckpoint expected 42 got %d %v", since, err)
+	}
+}
@@ -0,0 +1,191 @@
+package libDatabox
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// PermissionPlan holds the arbiter permissions and external hosts needed to run a component with a given SLA.
+type PermissionPlan struct {
+	Permissions   []ContainerPermissions
+	ExternalHosts []string
+}
+
+// PermissionDiff describes the changes needed to move from the currently granted permissions to a new plan.
+type PermissionDiff struct {
+	Grant     []ContainerPermissions
+	Revoke    []ContainerPermissions
+	Unchanged []ContainerPermissions
+}
+
+// PlanSLAPermissions converts an SLA into the full, deduplicated set of permissions that should be granted
+// on the arbiter using GrantContainerPermissions. resolved maps a datasource clientid to the hypercat item
+// chosen for it, entries in resolved take precedence over the hypercat item stored in the SLA.
+// Every datasource gets read and observe access, actuators also get write access to their keys or to
+// WriteAt for time series and functions get
+// access to the request and response paths used by Func.Call. Export whitelist entries are turned into
+// export-service permissions with a destination caveat and external whitelist urls are returned in ExternalHosts.
+func PlanSLAPermissions(sla SLA, resolved map[string]HypercatItem) (PermissionPlan, error) {
+
+	plan := PermissionPlan{}
+	seen := make(map[string]bool)
+
+	add := func(p ContainerPermissions) {
+		key := permissionKey(p)
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		plan.Permissions = append(plan.Permissions, p)
+	}
+
+	for _, ds := range sla.Datasources {
+		item := ds.Hypercat
+		if r, ok := resolved[ds.Clientid]; ok {
+			item = r
+		}
+
+		if item.Href == "" {
+			if ds.Required {
+				return PermissionPlan{}, errors.New("No hypercat item for required datasource " + ds.Clientid)
+			}
+			continue
+		}
+
+		u, err := url.Parse(item.Href)
+		if err != nil {
+			return PermissionPlan{}, errors.New("Invalid href for datasource " + ds.Clientid + ": " + err.Error())
+		}
+		target, _, err := net.SplitHostPort(u.Host)
+		if err != nil {
+			target = u.Host
+		}
+
+		resolvedDs := ds
+		resolvedDs.Hypercat = item
+
+		if IsFunc(resolvedDs) {
+			functionName := u.Path[strings.LastIndex(u.Path, "/")+1:]
+			add(newPermission(sla.Name, target, "/notification/request/"+functionName+"/*", "POST", ""))
+			add(newPermission(sla.Name, target, "/notification/response/"+functionName+"/*", "GET", ""))
+			continue
+		}
+
+		add(newPermission(sla.Name, target, u.Path, "GET", ""))
+		add(newPermission(sla.Name, target, u.Path+"/*", "GET", ""))
+
+		if IsActuator(resolvedDs) {
+			add(newPermission(sla.Name, target, u.Path, "POST", ""))
+			if strings.HasPrefix(u.Path, "/kv/") {
+				//KVStore writes each key at its own path
+				add(newPermission(sla.Name, target, u.Path+"/*", "POST", ""))
+			} else {
+				//WriteAt requests a token for the /at/ path
+				add(newPermission(sla.Name, target, u.Path+"/at/*", "POST", ""))
+			}
+		}
+	}
+
+	for _, wl := range sla.ExportWhitelists {
+		if wl.Url == "" {
+			continue
+		}
+		add(newPermission(sla.Name, exportServiceName, "/lp/export", "POST", `{"destination":"`+wl.Url+`"}`))
+	}
+
+	hosts := make(map[string]bool)
+	for _, wl := range sla.ExternalWhitelist {
+		for _, u := range wl.Urls {
+			if u == "" || hosts[u] {
+				continue
+			}
+			hosts[u] = true
+			plan.ExternalHosts = append(plan.ExternalHosts, u)
+		}
+	}
+
+	sortPermissions(plan.Permissions)
+	sort.Strings(plan.ExternalHosts)
+
+	return plan, nil
+}
+
+// DiffPermissions compares the permissions already granted to a component with the planned ones
+// and returns what needs to be granted and revoked.
+func DiffPermissions(granted []ContainerPermissions, planned []ContainerPermissions) PermissionDiff {
+
+	diff := PermissionDiff{}
+
+	have := make(map[string]bool)
+	for _, p := range granted {
+		have[permissionKey(p)] = true
+	}
+
+	want := make(map[string]bool)
+	for _, p := range planned {
+		key := permissionKey(p)
+		if want[key] {
+			continue
+		}
+		want[key] = true
+		if have[key] {
+			diff.Unchanged = append(diff.Unchanged, p)
+		} else {
+			diff.Grant = append(diff.Grant, p)
+		}
+	}
+
+	revoked := make(map[string]bool)
+	for _, p := range granted {
+		key := permissionKey(p)
+		if want[key] || revoked[key] {
+			continue
+		}
+		revoked[key] = true
+		diff.Revoke = append(diff.Revoke, p)
+	}
+
+	sortPermissions(diff.Grant)
+	sortPermissions(diff.Revoke)
+	sortPermissions(diff.Unchanged)
+
+	return diff
+}
+
+// GrantPermissionPlan grants all permissions in plan using GrantContainerPermissions.
+func (arb *ArbiterClient) GrantPermissionPlan(plan PermissionPlan) error {
+
+	for _, p := range plan.Permissions {
+		err := arb.GrantContainerPermissions(p)
+		if err != nil {
+			return errors.New("Error granting " + p.Route.Method + " " + p.Route.Target + p.Route.Path + " to " + p.Name + ": " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+func newPermission(name string, target string, path string, method string, caveat string) ContainerPermissions {
+	return ContainerPermissions{
+		Name: name,
+		Route: Route{
+			Target: target,
+			Path:   path,
+			Method: method,
+		},
+		Caveat: caveat,
+	}
+}
+
+func permissionKey(p ContainerPermissions) string {
+	return p.Name + "\x00" + p.Route.Target + "\x00" + p.Route.Path + "\x00" + p.Route.Method + "\x00" + p.Caveat
+}
+
+func sortPermissions(perms []ContainerPermissions) {
+	sort.Slice(perms, func(i, j int) bool {
+		return permissionKey(perms[i]) < permissionKey(perms[j])
+	})
+}
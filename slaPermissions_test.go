@@ -0,0 +1,178 @@
+package libDatabox
+
+import (
+	"testing"
+)
+
+func testSLA() SLA {
+	return SLA{
+		Name: "test-app",
+		Datasources: []DataSource{
+			{
+				Clientid: "SENSOR",
+				Required: true,
+				Hypercat: HypercatItem{
+					ItemMetadata: []interface{}{
+						RelValPair{Rel: "urn:X-databox:rels:hasDatasourceid", Val: "sensor"},
+					},
+					Href: "tcp://driver-test-core-store:5555/ts/sensor",
+				},
+			},
+			{
+				Clientid: "LIGHT",
+				Required: true,
+				Hypercat: HypercatItem{
+					ItemMetadata: []interface{}{
+						RelValPairBool{Rel: "urn:X-databox:rels:isActuator", Val: true},
+					},
+					Href: "tcp://driver-test-core-store:5555/kv/light",
+				},
+			},
+			{
+				Clientid: "OPTIONAL",
+				Required: false,
+			},
+		},
+		ExportWhitelists: []ExportWhitelist{
+			{Url: "https://export.example.com/", Description: "test"},
+			{Url: "https://export.example.com/", Description: "duplicate"},
+		},
+		ExternalWhitelist: []ExternalWhitelist{
+			{Urls: []string{"https://api.example.com/", "https://api.example.com/"}},
+		},
+	}
+}
+
+func TestPlanSLAPermissions(t *testing.T) {
+
+	plan, err := PlanSLAPermissions(testSLA(), nil)
+	if err != nil {
+		t.Fatalf("PlanSLAPermissions failed expected err to be nil got %s", err.Error())
+	}
+
+	expected := []ContainerPermissions{
+		newPermission("test-app", "driver-test-core-store", "/ts/sensor", "GET", ""),
+		newPermission("test-app", "driver-test-core-store", "/ts/sensor/*", "GET", ""),
+		newPermission("test-app", "driver-test-core-store", "/kv/light", "GET", ""),
+		newPermission("test-app", "driver-test-core-store", "/kv/light/*", "GET", ""),
+		newPermission("test-app", "driver-test-core-store", "/kv/light", "POST", ""),
+		newPermission("test-app", "driver-test-core-store", "/kv/light/*", "POST", ""),
+		newPermission("test-app", "export-service", "/lp/export", "POST", `{"destination":"https://export.example.com/"}`),
+	}
+
+	if len(plan.Permissions) != len(expected) {
+		t.Fatalf("PlanSLAPermissions expected %d permissions got %d: %v", len(expected), len(plan.Permissions), plan.Permissions)
+	}
+
+	diff := DiffPermissions(expected, plan.Permissions)
+	if len(diff.Grant) != 0 || len(diff.Revoke) != 0 {
+		t.Errorf("PlanSLAPermissions unexpected permissions grant %v revoke %v", diff.Grant, diff.Revoke)
+	}
+
+	if len(plan.ExternalHosts) != 1 || plan.ExternalHosts[0] != "https://api.example.com/" {
+		t.Errorf("PlanSLAPermissions expected one external host got %v", plan.ExternalHosts)
+	}
+}
+
+func TestPlanSLAPermissionsFunc(t *testing.T) {
+
+	sla := SLA{
+		Name: "test-app",
+		Datasources: []DataSource{
+			{
+				Clientid: "FUNC",
+				Required: true,
+			},
+		},
+	}
+
+	resolved := map[string]HypercatItem{
+		"FUNC": {
+			ItemMetadata: []interface{}{
+				RelValPairBool{Rel: "urn:X-databox:rels:isFunc", Val: true},
+			},
+			Href: "tcp://driver-test-core-store:5555/request/doThing",
+		},
+	}
+
+	plan, err := PlanSLAPermissions(sla, resolved)
+	if err != nil {
+		t.Fatalf("PlanSLAPermissions failed expected err to be nil got %s", err.Error())
+	}
+
+	expected := []ContainerPermissions{
+		newPermission("test-app", "driver-test-core-store", "/notification/request/doThing/*", "POST", ""),
+		newPermission("test-app", "driver-test-core-store", "/notification/response/doThing/*", "GET", ""),
+	}
+
+	diff := DiffPermissions(expected, plan.Permissions)
+	if len(diff.Grant) != 0 || len(diff.Revoke) != 0 {
+		t.Errorf("PlanSLAPermissions unexpected permissions grant %v revoke %v", diff.Grant, diff.Revoke)
+	}
+}
+
+func TestPlanSLAPermissionsTSActuator(t *testing.T) {
+
+	sla := SLA{
+		Name: "test-app",
+		Datasources: []DataSource{
+			{
+				Clientid: "HEATER",
+				Required: true,
+				Hypercat: HypercatItem{
+					ItemMetadata: []interface{}{
+						RelValPairBool{Rel: "urn:X-databox:rels:isActuator", Val: true},
+					},
+					Href: "tcp://driver-test-core-store:5555/ts/blob/heater",
+				},
+			},
+		},
+	}
+
+	plan, err := PlanSLAPermissions(sla, nil)
+	if err != nil {
+		t.Fatalf("PlanSLAPermissions failed expected err to be nil got %s", err.Error())
+	}
+
+	expected := []ContainerPermissions{
+		newPermission("test-app", "driver-test-core-store", "/ts/blob/heater", "GET", ""),
+		newPermission("test-app", "driver-test-core-store", "/ts/blob/heater/*", "GET", ""),
+		newPermission("test-app", "driver-test-core-store", "/ts/blob/heater", "POST", ""),
+		newPermission("test-app", "driver-test-core-store", "/ts/blob/heater/at/*", "POST", ""),
+	}
+
+	diff := DiffPermissions(expected, plan.Permissions)
+	if len(diff.Grant) != 0 || len(diff.Revoke) != 0 {
+		t.Errorf("PlanSLAPermissions unexpected permissions grant %v revoke %v", diff.Grant, diff.Revoke)
+	}
+}
+
+func TestPlanSLAPermissionsMissingRequired(t *testing.T) {
+
+	sla := testSLA()
+	sla.Datasources[0].Hypercat = HypercatItem{}
+
+	_, err := PlanSLAPermissions(sla, nil)
+	if err == nil {
+		t.Errorf("PlanSLAPermissions expected an error for missing required datasource")
+	}
+}
+
+func TestDiffPermissions(t *testing.T) {
+
+	read := newPermission("test-app", "store", "/ts/a", "GET", "")
+	write := newPermission("test-app", "store", "/ts/a", "POST", "")
+	old := newPermission("test-app", "store", "/ts/old", "GET", "")
+
+	diff := DiffPermissions([]ContainerPermissions{read, old}, []ContainerPermissions{read, write, write})
+
+	if len(diff.Grant) != 1 || diff.Grant[0] != write {
+		t.Errorf("DiffPermissions expected grant %v got %v", write, diff.Grant)
+	}
+	if len(diff.Revoke) != 1 || diff.Revoke[0] != old {
+		t.Errorf("DiffPermissions expected revoke %v got %v", old, diff.Revoke)
+	}
+	if len(diff.Unchanged) != 1 || diff.Unchanged[0] != read {
+		t.Errorf("DiffPermissions expected unchanged %v got %v", read, diff.Unchanged)
+	}
+}
@@ -0,0 +1,245 @@
+package libDatabox
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DataSourceHandle gives access to a single datasource. Only the store matching the datasource
+// StoreType and ContentType is set, the others are nil.
+type DataSourceHandle struct {
+	Metadata     DataSourceMetadata
+	DataSourceID string
+	StoreURL     string
+	KV           *KVStore
+	TSBlob       *TSBlobStore
+	TS           *TSStore
+	Func         *Func
+}
+
+// StorePool lazily creates and caches a CoreStoreClient for each store url. All clients share a
+// single ArbiterClient so arbiter tokens are cached once for all stores.
+type StorePool struct {
+	arbiter          *ArbiterClient
+	zmqPublicKeyPath string
+	enableLogging    bool
+	idleTimeout      time.Duration
+	clients          map[string]*pooledStoreClient
+	lock             *sync.Mutex
+	done             chan struct{}
+	closeOnce        *sync.Once
+}
+
+type pooledStoreClient struct {
+	csc      *CoreStoreClient
+	lastUsed time.Time
+}
+
+// NewStorePool returns a StorePool that creates clients using arbiterClient and the ZMQ public key at zmqPublicKeyPath.
+// If idleTimeout is greater than zero clients that have not been used for idleTimeout are released, see CloseIdle.
+func NewStorePool(arbiterClient *ArbiterClient, zmqPublicKeyPath string, enableLogging bool, idleTimeout time.Duration) *StorePool {
+
+	sp := &StorePool{
+		arbiter:          arbiterClient,
+		zmqPublicKeyPath: zmqPublicKeyPath,
+		enableLogging:    enableLogging,
+		idleTimeout:      idleTimeout,
+		clients:          make(map[string]*pooledStoreClient),
+		lock:             &sync.Mutex{},
+		done:             make(chan struct{}),
+		closeOnce:        &sync.Once{},
+	}
+
+	if idleTimeout > 0 {
+		go sp.closeIdleLoop()
+	}
+
+	return sp
+}
+
+// Get returns the CoreStoreClient for the store at href, href can be a store url or a datasource href
+// as returned in the hypercat catalogue.
+func (sp *StorePool) Get(href string) (*CoreStoreClient, error) {
+
+	storeURL, err := GetStoreURLFromDsHref(href)
+	if err != nil {
+		return nil, err
+	}
+	if storeURL == "://" {
+		return nil, errors.New("Invalid store url " + href)
+	}
+
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+
+	pooled, ok := sp.clients[storeURL]
+	if !ok {
+		pooled = &pooledStoreClient{
+			csc: NewCoreStoreClient(sp.arbiter, sp.zmqPublicKeyPath, storeURL, sp.enableLogging),
+		}
+		sp.clients[storeURL] = pooled
+	}
+	pooled.lastUsed = time.Now()
+
+	return pooled.csc, nil
+}
+
+// ForDataSource returns a DataSourceHandle for the datasource described by item using the client for its store
+func (sp *StorePool) ForDataSource(item HypercatItem) (DataSourceHandle, error) {
+
+	dm, storeURL, err := HypercatItemToDataSourceMetadata(item)
+	if err != nil {
+		return DataSourceHandle{}, err
+	}
+
+	csc, err := sp.Get(storeURL)
+	if err != nil {
+		return DataSourceHandle{}, err
+	}
+
+	return csc.DataSourceHandle(dm)
+}
+
+// DataSourceHandle returns a DataSourceHandle for a datasource in this clients store
+func (csc *CoreStoreClient) DataSourceHandle(dm DataSourceMetadata) (DataSourceHandle, error) {
+
+	if dm.DataSourceID == "" {
+		return DataSourceHandle{}, errors.New("Missing DataSourceID")
+	}
+
+	handle := DataSourceHandle{
+		Metadata:     dm,
+		DataSourceID: dm.DataSourceID,
+		StoreURL:     csc.ZEndpoint,
+	}
+
+	if dm.IsFunc {
+		handle.Func = csc.FUNC
+		return handle, nil
+	}
+
+	ct := strings.ToLower(string(dm.ContentType))
+	switch dm.StoreType {
+	case StoreTypeKV:
+		switch {
+		case strings.Contains(ct, "json"):
+			handle.KV = csc.KVJSON
+		case strings.Contains(ct, "text"):
+			handle.KV = csc.KVText
+		default:
+			handle.KV = csc.KVBin
+		}
+	case StoreTypeTS:
+		handle.TS = csc.TSJSON
+	case StoreTypeTSBlob:
+		switch {
+		case strings.Contains(ct, "json"):
+			handle.TSBlob = csc.TSBlobJSON
+		case strings.Contains(ct, "text"):
+			handle.TSBlob = csc.TSBlobText
+		default:
+			handle.TSBlob = csc.TSBlobBin
+		}
+	case StoreTypeFunc:
+		handle.Func = csc.FUNC
+	default:
+		return DataSourceHandle{}, errors.New("Unknown store type " + string(dm.StoreType) + " for " + dm.DataSourceID)
+	}
+
+	return handle, nil
+}
+
+// CloseIdle releases the clients that have not been used for the pools idle timeout (or all clients if
+// the timeout is zero) and returns how many were released. Released clients are not closed as callers
+// and handles from ForDataSource may still be using them, the next Get for their store creates a new
+// client. Clients with requests in progress or running observes or function calls are not idle.
+func (sp *StorePool) CloseIdle() int {
+
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+
+	released := 0
+	for storeURL, pooled := range sp.clients {
+		if pooled.csc.inUse() {
+			pooled.lastUsed = time.Now()
+			continue
+		}
+		if time.Since(pooled.lastUsed) >= sp.idleTimeout {
+			delete(sp.clients, storeURL)
+			released++
+		}
+	}
+
+	return released
+}
+
+// Len returns the number of store clients in the pool
+func (sp *StorePool) Len() int {
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+	return len(sp.clients)
+}
+
+// Close stops the idle check and closes all clients in the pool. Clients released by CloseIdle and
+// the shared ArbiterClient are not closed.
+func (sp *StorePool) Close() error {
+
+	sp.closeOnce.Do(func() {
+		close(sp.done)
+	})
+
+	sp.lock.Lock()
+	all := []*CoreStoreClient{}
+	for _, pooled := range sp.clients {
+		all = append(all, pooled.csc)
+	}
+	sp.clients = make(map[string]*pooledStoreClient)
+	sp.lock.Unlock()
+
+	return closeStoreClients(all)
+}
+
+// closeStoreClients closes clients concurrently so one slow store does not hold up the others
+func closeStoreClients(clients []*CoreStoreClient) error {
+
+	errs := make(chan error, len(clients))
+	for _, csc := range clients {
+		go func(csc *CoreStoreClient) {
+			err := csc.Close()
+			if err != nil {
+				err = errors.New(csc.ZEndpoint + ": " + err.Error())
+			}
+			errs <- err
+		}(csc)
+	}
+
+	failed := []string{}
+	for range clients {
+		if err := <-errs; err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New("Error closing store clients: " + strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+func (sp *StorePool) closeIdleLoop() {
+
+	ticker := time.NewTicker(sp.idleTimeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sp.done:
+			return
+		case <-ticker.C:
+			sp.CloseIdle()
+		}
+	}
+}
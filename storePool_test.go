@@ -0,0 +1,139 @@
+package libDatabox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStorePoolGet(t *testing.T) {
+
+	sp := NewStorePool(Arbiter, "", false, 0)
+	defer sp.Close()
+
+	csc1, err := sp.Get("tcp://driver-a-core-store:5555/ts/blob/test")
+	if err != nil {
+		t.Fatalf("Get failed expected err to be nil got %s", err.Error())
+	}
+
+	csc2, _ := sp.Get("tcp://driver-a-core-store:5555")
+	if csc1 != csc2 {
+		t.Errorf("Get expected the same client for the same store")
+	}
+
+	csc3, _ := sp.Get("tcp://driver-b-core-store:5555/kv/test")
+	if csc3 == csc1 || csc3.ZEndpoint != "tcp://driver-b-core-store:5555" {
+		t.Errorf("Get expected a new client for tcp://driver-b-core-store:5555 got %s", csc3.ZEndpoint)
+	}
+
+	if csc1.Arbiter != csc3.Arbiter {
+		t.Errorf("Get expected clients to share the ArbiterClient")
+	}
+
+	if sp.Len() != 2 {
+		t.Errorf("Get expected 2 clients got %d", sp.Len())
+	}
+
+	if _, err := sp.Get("not a url"); err == nil {
+		t.Errorf("Get expected an error for an invalid url")
+	}
+}
+
+func TestStorePoolCloseIdle(t *testing.T) {
+
+	sp := NewStorePool(Arbiter, "", false, 20*time.Millisecond)
+	defer sp.Close()
+
+	sp.Get("tcp://driver-a-core-store:5555")
+
+	time.Sleep(100 * time.Millisecond)
+
+	if sp.Len() != 0 {
+		t.Errorf("CloseIdle expected idle clients to be removed got %d", sp.Len())
+	}
+}
+
+func TestStorePoolCloseIdleInUse(t *testing.T) {
+
+	sp := NewStorePool(Arbiter, "", false, 0)
+	defer sp.Close()
+
+	csc, _ := sp.Get("tcp://driver-a-core-store:5555")
+
+	req := csc.startRequest("read", "/kv/test/key")
+	if sp.CloseIdle() != 0 || sp.Len() != 1 {
+		t.Errorf("CloseIdle expected a client with a request in progress to be kept")
+	}
+
+	var err error
+	req.finish(&err)
+	if sp.CloseIdle() != 1 || sp.Len() != 0 {
+		t.Errorf("CloseIdle expected the client to be released once the request finished")
+	}
+	if csc.isClosed() {
+		t.Error("CloseIdle expected the released client to stay open for its callers")
+	}
+}
+
+func TestStorePoolCloseClosesClients(t *testing.T) {
+
+	sp := NewStorePool(Arbiter, "", false, 0)
+
+	csc, err := sp.Get("tcp://driver-a-core-store:5555")
+	if err != nil {
+		t.Fatalf("Get failed expected err to be nil got %s", err.Error())
+	}
+
+	err = sp.Close()
+	if err != nil {
+		t.Errorf("Close failed expected err to be nil got %s", err.Error())
+	}
+	if !csc.isClosed() {
+		t.Error("StorePool Close expected pooled client to be closed")
+	}
+}
+
+func TestStorePoolForDataSource(t *testing.T) {
+
+	sp := NewStorePool(Arbiter, "", false, 0)
+	defer sp.Close()
+
+	tests := []struct {
+		metadata DataSourceMetadata
+		check    func(h DataSourceHandle) bool
+	}{
+		{
+			DataSourceMetadata{Description: "d", ContentType: ContentTypeJSON, Vendor: "v", DataSourceType: "t", DataSourceID: "kv", StoreType: StoreTypeKV},
+			func(h DataSourceHandle) bool { return h.KV != nil && h.KV.contentType == ContentTypeJSON },
+		},
+		{
+			DataSourceMetadata{Description: "d", ContentType: ContentTypeTEXT, Vendor: "v", DataSourceType: "t", DataSourceID: "blob", StoreType: StoreTypeTSBlob},
+			func(h DataSourceHandle) bool { return h.TSBlob != nil && h.TSBlob.contentType == ContentTypeTEXT },
+		},
+		{
+			DataSourceMetadata{Description: "d", ContentType: ContentTypeJSON, Vendor: "v", DataSourceType: "t", DataSourceID: "ts", StoreType: StoreTypeTS},
+			func(h DataSourceHandle) bool { return h.TS != nil },
+		},
+		{
+			DataSourceMetadata{Description: "d", ContentType: ContentTypeJSON, Vendor: "v", DataSourceType: "t", DataSourceID: "fn", StoreType: StoreTypeFunc, IsFunc: true},
+			func(h DataSourceHandle) bool { return h.Func != nil },
+		},
+	}
+
+	for _, test := range tests {
+		item, err := DataSourceMetadataToHypercat(test.metadata, "tcp://driver-a-core-store:5555")
+		if err != nil {
+			t.Fatalf("DataSourceMetadataToHypercat failed expected err to be nil got %s", err.Error())
+		}
+		handle, err := sp.ForDataSource(item)
+		if err != nil {
+			t.Errorf("ForDataSource failed expected err to be nil got %s", err.Error())
+			continue
+		}
+		if handle.DataSourceID != test.metadata.DataSourceID || handle.StoreURL != "tcp://driver-a-core-store:5555" {
+			t.Errorf("ForDataSource returned handle for %s on %s", handle.DataSourceID, handle.StoreURL)
+		}
+		if !test.check(handle) {
+			t.Errorf("ForDataSource returned the wrong store for %s: %+v", test.metadata.DataSourceID, handle)
+		}
+	}
+}
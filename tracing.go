@@ -0,0 +1,240 @@
+package libDatabox
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SpanContext identifies a span and the trace it belongs to using W3C trace context ids in hex
+type SpanContext struct {
+	TraceID string `json:"traceId"`
+	SpanID  string `json:"spanId"`
+}
+
+// IsValid returns true if both ids are set
+func (sc SpanContext) IsValid() bool {
+	return len(sc.TraceID) == 32 && len(sc.SpanID) == 16
+}
+
+// Traceparent formats sc as a W3C traceparent header value
+func (sc SpanContext) Traceparent() string {
+	return "00-" + sc.TraceID + "-" + sc.SpanID + "-01"
+}
+
+// ParseTraceparent parses a W3C traceparent header value
+func ParseTraceparent(traceparent string) (SpanContext, error) {
+
+	parts := strings.Split(traceparent, "-")
+	if len(parts) != 4 || len(parts[0]) != 2 {
+		return SpanContext{}, errors.New("Invalid traceparent " + traceparent)
+	}
+
+	sc := SpanContext{TraceID: parts[1], SpanID: parts[2]}
+	for _, id := range []string{sc.TraceID, sc.SpanID} {
+		if _, err := hex.DecodeString(id); err != nil {
+			return SpanContext{}, errors.New("Invalid traceparent " + traceparent)
+		}
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, errors.New("Invalid traceparent " + traceparent)
+	}
+
+	return sc, nil
+}
+
+// Span is a finished operation passed to a SpanExporter. Parent is empty for the root span of a trace.
+type Span struct {
+	Name       string
+	Context    SpanContext
+	Parent     SpanContext
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	Error      string
+}
+
+// SpanExporter receives spans when they end. Implementations must be safe for concurrent use.
+// Use SetSpanExporter on the clients to enable tracing.
+type SpanExporter interface {
+	ExportSpan(span Span)
+}
+
+// InMemorySpanExporter keeps exported spans in memory, it is intended for tests
+type InMemorySpanExporter struct {
+	lock  *sync.Mutex
+	spans []Span
+}
+
+// NewInMemorySpanExporter returns an empty InMemorySpanExporter
+func NewInMemorySpanExporter() *InMemorySpanExporter {
+	return &InMemorySpanExporter{
+		lock:  &sync.Mutex{},
+		spans: []Span{},
+	}
+}
+
+// ExportSpan implements SpanExporter
+func (e *InMemorySpanExporter) ExportSpan(span Span) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// Spans returns the spans exported so far in the order they ended
+func (e *InMemorySpanExporter) Spans() []Span {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]Span{}, e.spans...)
+}
+
+// Reset removes all exported spans
+func (e *InMemorySpanExporter) Reset() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.spans = []Span{}
+}
+
+// exporterRef lets the exporter of a client be replaced while requests are running
+type exporterRef struct {
+	v atomic.Value
+}
+
+type exporterBox struct {
+	e SpanExporter
+}
+
+func (r *exporterRef) get() SpanExporter {
+	if r == nil {
+		return nil
+	}
+	if box, ok := r.v.Load().(exporterBox); ok {
+		return box.e
+	}
+	return nil
+}
+
+func (r *exporterRef) set(e SpanExporter) {
+	r.v.Store(exporterBox{e})
+}
+
+// activeSpan is a span that has not ended. All methods can be called on a nil activeSpan
+// which is what startSpan returns when tracing is disabled.
+type activeSpan struct {
+	span     Span
+	exporter SpanExporter
+}
+
+// startSpan starts a span that is a child of parent or the root of a new trace if parent is not valid
+func startSpan(exporter SpanExporter, name string, parent SpanContext, attributes map[string]string) *activeSpan {
+
+	if exporter == nil {
+		return nil
+	}
+
+	sc := SpanContext{
+		TraceID: parent.TraceID,
+		SpanID:  randomHex(8),
+	}
+	if !parent.IsValid() {
+		sc.TraceID = randomHex(16)
+		parent = SpanContext{}
+	}
+
+	return &activeSpan{
+		span: Span{
+			Name:       name,
+			Context:    sc,
+			Parent:     parent,
+			Start:      time.Now(),
+			Attributes: attributes,
+		},
+		exporter: exporter,
+	}
+}
+
+// context returns the span context of s or an empty SpanContext if tracing is disabled
+func (s *activeSpan) context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.span.Context
+}
+
+func (s *activeSpan) setAttribute(name string, value string) {
+	if s == nil {
+		return
+	}
+	if s.span.Attributes == nil {
+		s.span.Attributes = make(map[string]string)
+	}
+	s.span.Attributes[name] = value
+}
+
+func (s *activeSpan) end(err error) {
+	if s == nil {
+		return
+	}
+	s.span.End = time.Now()
+	if err != nil {
+		s.span.Error = err.Error()
+	}
+	s.exporter.ExportSpan(s.span)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// funcTraceEnvelope wraps a Func.Call payload to pass the callers span context to the function
+const funcTraceEnvelopeVersion = "databox-trace/1"
+
+type funcTraceEnvelope struct {
+	Envelope    string `json:"databoxEnvelope"`
+	Traceparent string `json:"traceparent"`
+	Payload     []byte `json:"payload"`
+}
+
+func wrapFuncPayload(sc SpanContext, payload []byte) []byte {
+
+	wrapped, err := json.Marshal(funcTraceEnvelope{
+		Envelope:    funcTraceEnvelopeVersion,
+		Traceparent: sc.Traceparent(),
+		Payload:     payload,
+	})
+	if err != nil {
+		return payload
+	}
+
+	return wrapped
+}
+
+// unwrapFuncPayload returns the payload and span context from a wrapped payload, other payloads
+// are returned unchanged with an empty SpanContext
+func unwrapFuncPayload(payload []byte) ([]byte, SpanContext) {
+
+	if !bytes.Contains(payload, []byte(funcTraceEnvelopeVersion)) {
+		return payload, SpanContext{}
+	}
+
+	envelope := funcTraceEnvelope{}
+	err := json.Unmarshal(payload, &envelope)
+	if err != nil || envelope.Envelope != funcTraceEnvelopeVersion {
+		return payload, SpanContext{}
+	}
+
+	sc, err := ParseTraceparent(envelope.Traceparent)
+	if err != nil {
+		Warn("[Notifications] ignoring " + err.Error())
+	}
+
+	return envelope.Payload, sc
+}
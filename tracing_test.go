@@ -0,0 +1,184 @@
+package libDatabox
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+
+	sc := SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"}
+
+	parsed, err := ParseTraceparent(sc.Traceparent())
+	if err != nil {
+		t.Fatalf("ParseTraceparent failed expected err to be nil got %s", err.Error())
+	}
+	if parsed != sc {
+		t.Errorf("ParseTraceparent expected %+v got %+v", sc, parsed)
+	}
+
+	for _, invalid := range []string{"", "00-abc-def-01", "00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01"} {
+		if _, err := ParseTraceparent(invalid); err == nil {
+			t.Errorf("ParseTraceparent expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestStartSpan(t *testing.T) {
+
+	var disabled *activeSpan = startSpan(nil, "disabled", SpanContext{}, nil)
+	if disabled != nil {
+		t.Fatal("startSpan expected nil without an exporter")
+	}
+	disabled.setAttribute("a", "b")
+	disabled.end(nil)
+	if disabled.context().IsValid() {
+		t.Error("disabled span expected an empty context")
+	}
+
+	exporter := NewInMemorySpanExporter()
+	root := startSpan(exporter, "root", SpanContext{}, nil)
+	child := startSpan(exporter, "child", root.context(), nil)
+	child.setAttribute("key", "value")
+	child.end(errors.New("failed"))
+	root.end(nil)
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans got %d", len(spans))
+	}
+	if !spans[1].Context.IsValid() || spans[1].Parent.IsValid() {
+		t.Errorf("root span expected a new trace got %+v", spans[1])
+	}
+	if spans[0].Context.TraceID != spans[1].Context.TraceID || spans[0].Parent != spans[1].Context {
+		t.Errorf("child span expected parent %+v got %+v", spans[1].Context, spans[0].Parent)
+	}
+	if spans[0].Error != "failed" || spans[0].Attributes["key"] != "value" {
+		t.Errorf("child span expected error and attribute got %+v", spans[0])
+	}
+
+	exporter.Reset()
+	if len(exporter.Spans()) != 0 {
+		t.Error("Reset expected no spans")
+	}
+}
+
+func TestFuncPayloadEnvelope(t *testing.T) {
+
+	sc := SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"}
+	payload := []byte(`{"a":1}`)
+
+	unwrapped, caller := unwrapFuncPayload(wrapFuncPayload(sc, payload))
+	if string(unwrapped) != string(payload) || caller != sc {
+		t.Errorf("unwrapFuncPayload expected %s %+v got %s %+v", payload, sc, unwrapped, caller)
+	}
+
+	for _, plain := range [][]byte{payload, []byte("text mentioning " + funcTraceEnvelopeVersion), {}} {
+		unwrapped, caller = unwrapFuncPayload(plain)
+		if string(unwrapped) != string(plain) || caller.IsValid() {
+			t.Errorf("unwrapFuncPayload expected %q unchanged got %q %+v", plain, unwrapped, caller)
+		}
+	}
+}
+
+func TestStoreRequestSpans(t *testing.T) {
+
+	exporter := NewInMemorySpanExporter()
+	csc := NewCoreStoreClient(Arbiter, "", StoreURL, false)
+	csc.SetSpanExporter(exporter)
+	csc.Close()
+
+	parent := SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"}
+	csc.WithSpan(parent).KVJSON.Read(dsID, "key")
+
+	spans := exporter.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span got %d", len(spans))
+	}
+	if spans[0].Name != "store.read" || spans[0].Parent != parent || spans[0].Error != errClientClosed.Error() {
+		t.Errorf("expected failed store.read span with parent %+v got %+v", parent, spans[0])
+	}
+	if spans[0].Attributes["path"] != "/kv/"+dsID+"/key" {
+		t.Errorf("expected path attribute got %+v", spans[0].Attributes)
+	}
+}
+
+func TestFuncCallSpans(t *testing.T) {
+
+	arb, err := newArbiterClient("secret", "", ArbiterURL)
+	if err != nil {
+		t.Fatalf("newArbiterClient failed expected err to be nil got %s", err.Error())
+	}
+	arb.Close()
+	exporter := NewInMemorySpanExporter()
+	csc, _ := newCoreStoreClient(arb, "", StoreURL, false)
+	csc.SetSpanExporter(exporter)
+	defer csc.Close()
+
+	resChan, err := csc.FUNC.Call("add", []byte("{}"), ContentTypeJSON)
+	if err != nil {
+		t.Fatalf("Call failed expected err to be nil got %s", err.Error())
+	}
+	resp := <-resChan
+	if resp.Status != FuncStatusError {
+		t.Errorf("Call expected an error response with a closed arbiter got %+v", resp)
+	}
+
+	spans := exporter.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span got %d", len(spans))
+	}
+	if spans[0].Name != "func.call" || spans[0].Attributes["function"] != "add" || spans[0].Error == "" {
+		t.Errorf("expected failed func.call span got %+v", spans[0])
+	}
+}
+
+func TestWithSpanSharesFuncListener(t *testing.T) {
+
+	csc := NewCoreStoreClient(Arbiter, "", StoreURL, false)
+	defer csc.Close()
+
+	traced := csc.WithSpan(SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"})
+	if traced.FUNC.listener != csc.FUNC.listener || traced.FUNC.lock != csc.FUNC.lock {
+		t.Errorf("WithSpan expected the function listener to be shared")
+	}
+	if traced.FUNC.csc != traced {
+		t.Errorf("WithSpan expected function calls to use the new client")
+	}
+}
+
+func TestFuncHandleSpanLinksToCaller(t *testing.T) {
+
+	exporter := NewInMemorySpanExporter()
+	csc := NewCoreStoreClient(Arbiter, "", StoreURL, false)
+	csc.SetSpanExporter(exporter)
+	csc.Close()
+
+	received := ""
+	csc.FUNC.registeredFuncHandler["add"] = func(contentType StoreContentType, payload []byte) ([]byte, error) {
+		received = string(payload)
+		return []byte("3"), nil
+	}
+
+	caller := SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"}
+	requests := make(chan ObserveResponse, 1)
+	requests <- ObserveResponse{Data: append([]byte("1 dsid /notification/response/add/job1 json "), wrapFuncPayload(caller, []byte("[1,2]"))...)}
+	close(requests)
+	csc.FUNC.parseRawFuncRequest(requests)
+
+	if received != "[1,2]" {
+		t.Errorf("handler expected unwrapped payload got %s", received)
+	}
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans got %d", len(spans))
+	}
+	handle, write := spans[0], spans[1]
+	if handle.Name != "func.handle" || handle.Parent != caller || handle.Context.TraceID != caller.TraceID {
+		t.Errorf("expected func.handle span with parent %+v got %+v", caller, handle)
+	}
+	if write.Name != "store.write" || write.Parent != handle.Context {
+		t.Errorf("expected store.write span with parent %+v got %+v", handle.Context, write)
+	}
+}
@@ -139,6 +139,7 @@ type DataSourceMetadata struct {
 	IsFunc         bool
 	Unit           string
 	Location       string
+	CustomRels     []RelVal //any extra hypercat rels, these must not use the rels above
 }
 
 type StoreType string
@@ -170,8 +171,8 @@ type HypercatRoot struct {
 }
 
 type HypercatItem struct {
-	ItemMetadata []interface{} `json:"item-metadata"`
-	Href         string        `json:"href"`
+	ItemMetadata HypercatMetadata `json:"item-metadata"`
+	Href         string           `json:"href"`
 }
 
 //